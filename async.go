@@ -0,0 +1,250 @@
+package zlog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// maxAsyncBuffered bounds the entries kept while the OpenSearch output initializes.
+const maxAsyncBuffered = 10000
+
+// For testing purposes
+var createOpenSearchCores = newOpenSearchCores
+
+// asyncCore stands in for the OpenSearch cores while they are created in the
+// background, see WithOpenSearchAsyncInit. Entries logged meanwhile are buffered
+// and replayed in order once the cores are attached.
+type asyncCore struct {
+	zapcore.LevelEnabler
+
+	// fields added with With
+	fields []zapcore.Field
+	state  *asyncState
+}
+
+type asyncState struct {
+	mu sync.Mutex
+	// nil until initialized, a no-op core if initialization failed
+	core     zapcore.Core
+	writers  openSearchWriters
+	buffered []bufferedEntry
+	dropped  int
+	ready    chan struct{}
+	err      error
+
+	// receives the entries instead of the buffer until initialized, see
+	// WithOpenSearchReconnect
+	fallback zapcore.Core
+	// closed to stop the reconnect attempts
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+type bufferedEntry struct {
+	entry  zapcore.Entry
+	fields []zapcore.Field
+}
+
+// newAsyncCore returns a core buffering entries until the OpenSearch cores are
+// created in the background.
+func newAsyncCore(opt *LogOpts) *asyncCore {
+	core := &asyncCore{LevelEnabler: opt.levelEnabler(), state: &asyncState{ready: make(chan struct{})}}
+
+	go core.state.init(opt)
+
+	return core
+}
+
+// newReconnectCore returns a core writing to fallback until OpenSearch becomes
+// reachable, checked every opt.openSearchReconnect in the background.
+func newReconnectCore(opt *LogOpts, fallback zapcore.Core) *asyncCore {
+	core := &asyncCore{
+		LevelEnabler: opt.levelEnabler(),
+		state:        &asyncState{ready: make(chan struct{}), fallback: fallback, quit: make(chan struct{})},
+	}
+
+	go core.state.reconnect(opt)
+
+	return core
+}
+
+func (c *asyncCore) With(fields []zapcore.Field) zapcore.Core {
+	combined := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+
+	return &asyncCore{
+		LevelEnabler: c.LevelEnabler,
+		fields:       append(append(combined, c.fields...), fields...),
+		state:        c.state,
+	}
+}
+
+func (c *asyncCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *asyncCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	all := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	all = append(append(all, c.fields...), fields...)
+
+	s := c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.core == nil && s.fallback != nil {
+		return s.fallback.Write(ent, all)
+	}
+
+	if s.core == nil {
+		if len(s.buffered) >= maxAsyncBuffered {
+			s.dropped++
+			return nil
+		}
+
+		s.buffered = append(s.buffered, bufferedEntry{entry: ent, fields: all})
+
+		return nil
+	}
+
+	return s.core.Write(ent, all)
+}
+
+func (c *asyncCore) Sync() error {
+	s := c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.core == nil {
+		if s.fallback != nil {
+			return s.fallback.Sync()
+		}
+
+		return nil
+	}
+
+	return s.core.Sync()
+}
+
+// writers returns the writers of the OpenSearch cores, nil until initialized.
+func (c *asyncCore) writers() openSearchWriters {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	return c.state.writers
+}
+
+// wait blocks until the initialization completed and returns its error.
+func (c *asyncCore) wait(ctx context.Context) error {
+	select {
+	case <-c.state.ready:
+		return c.state.err
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for OpenSearch initialization: %w", ctx.Err())
+	}
+}
+
+// stop ends the reconnect attempts and waits for them to return.
+func (c *asyncCore) stop() {
+	c.state.quitOnce.Do(func() { close(c.state.quit) })
+	<-c.state.ready
+}
+
+// reconnect pings OpenSearch until it's reachable and the cores are created, then
+// replaces the fallback with them.
+func (s *asyncState) reconnect(opt *LogOpts) {
+	defer close(s.ready)
+
+	ticker := time.NewTicker(opt.openSearchReconnect)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+		}
+
+		if err := pingOpenSearch(opt); err != nil {
+			opt.internalLogger.Debug("OpenSearch still unreachable", zap.Error(err))
+			continue
+		}
+
+		cores, writers, err := createOpenSearchCores(opt)
+		if err != nil {
+			opt.internalLogger.Warn("Failed to create OpenSearch cores, retrying", zap.Error(err))
+			continue
+		}
+
+		s.mu.Lock()
+		s.core = zapcore.NewTee(cores...)
+		s.writers = writers
+		s.mu.Unlock()
+
+		if opt.handle != nil {
+			opt.handle.bind(writers)
+		}
+
+		opt.internalLogger.Info("Connected to OpenSearch, leaving the fallback output")
+
+		return
+	}
+}
+
+// init creates the OpenSearch cores and replays the buffered entries.
+func (s *asyncState) init(opt *LogOpts) {
+	defer close(s.ready)
+
+	cores, writers, err := createOpenSearchCores(opt)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.err = fmt.Errorf("%w: %w", ErrCreateOpensearchCore, err)
+		s.core = zapcore.NewNopCore()
+		opt.internalLogger.Error("OpenSearch initialization failed, discarding buffered entries",
+			zap.Int("buffered", len(s.buffered)), zap.Error(err))
+		s.buffered = nil
+
+		return
+	}
+
+	s.core = zapcore.NewTee(cores...)
+	s.writers = writers
+
+	if opt.handle != nil {
+		opt.handle.bind(writers)
+	}
+
+	var (
+		failed  int
+		lastErr error
+	)
+
+	for _, buffered := range s.buffered {
+		if err := s.core.Write(buffered.entry, buffered.fields); err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+
+	if failed > 0 {
+		opt.internalLogger.Error("Failed to write entries buffered during OpenSearch initialization",
+			zap.Int("failed", failed), zap.Error(lastErr))
+	}
+
+	if s.dropped > 0 {
+		opt.internalLogger.Warn("Dropped entries logged during OpenSearch initialization",
+			zap.Int("dropped", s.dropped))
+	}
+
+	s.buffered = nil
+}
@@ -0,0 +1,85 @@
+package zlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// blockOpenSearchInit delays the async initialization until the returned
+// function is called.
+func blockOpenSearchInit(t *testing.T) func() {
+	t.Helper()
+
+	release := make(chan struct{})
+	original := createOpenSearchCores
+	createOpenSearchCores = func(opt *LogOpts) ([]zapcore.Core, openSearchWriters, error) {
+		<-release
+		return original(opt)
+	}
+
+	t.Cleanup(func() { createOpenSearchCores = original })
+
+	return func() { close(release) }
+}
+
+func TestOpenSearchAsyncInit(t *testing.T) {
+	release := blockOpenSearchInit(t)
+
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+	buf := captureStdout(t)
+
+	var handle Handle
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchAsyncInit(true),
+		WithOpenSearchWorkers(1),
+		WithConsole(true),
+		WithHandle(&handle),
+	)
+	require.NoError(t, err)
+
+	logger.Info("first")
+	logger.With(zap.String("component", "api")).Info("second")
+	logger.Debug("filtered")
+
+	assert.Contains(t, buf.String(), "second", "console is active right away")
+	assert.Equal(t, IndexerStats{}, handle.Stats())
+
+	release()
+	logger.Info("third")
+	require.NoError(t, flushFunc(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 3)
+
+	var messages []interface{}
+	for _, doc := range docs {
+		messages = append(messages, doc.Source["msg"])
+	}
+
+	assert.Equal(t, []interface{}{"first", "second", "third"}, messages)
+	assert.Equal(t, "api", docs[1].Source["component"])
+	assert.Equal(t, uint64(3), handle.Stats().Added)
+}
+
+func TestOpenSearchAsyncInitError(t *testing.T) {
+	badConfig := DefaultOpenSearchConfig("://not-a-url", false)
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&badConfig),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchAsyncInit(true),
+	)
+	require.NoError(t, err)
+
+	logger.Info("discarded")
+	assert.ErrorIs(t, flushFunc(context.Background()), ErrCreateOpensearchCore)
+}
@@ -0,0 +1,115 @@
+package zlog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// backpressure bounds the entries pending in the bulk indexers, see
+// WithOpenSearchBackpressure. An entry is pending from the moment it's handed
+// to a bulk indexer until OpenSearch acknowledges or rejects it, or its bulk
+// request fails as a whole. The bulk indexer stats can't tell: they count the
+// adds that timed out, and the failed requests of an indexer replaced by Flush.
+//
+// Writes wait for room before taking the writer's mutex, so concurrent writes
+// may exceed the bound by their number.
+type backpressure struct {
+	max int
+
+	mu      sync.Mutex
+	pending map[uint64]struct{}
+	// closed and replaced whenever entries are settled
+	settled chan struct{}
+}
+
+func newBackpressure(maxPending int) *backpressure {
+	if maxPending <= 0 {
+		return nil
+	}
+
+	return &backpressure{
+		max:     maxPending,
+		pending: make(map[uint64]struct{}),
+		settled: make(chan struct{}),
+	}
+}
+
+// track counts the item id as pending.
+func (b *backpressure) track(id uint64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending[id] = struct{}{}
+}
+
+// settle stops counting the item id, settling it more than once is harmless.
+func (b *backpressure) settle(id uint64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.pending[id]; ok {
+		delete(b.pending, id)
+		b.signal()
+	}
+}
+
+// settleUpTo stops counting the items with IDs up to lastID, e.g. those of a
+// failed bulk request.
+func (b *backpressure) settleUpTo(lastID uint64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(b.pending)
+
+	for id := range b.pending {
+		if id <= lastID {
+			delete(b.pending, id)
+		}
+	}
+
+	if len(b.pending) < n {
+		b.signal()
+	}
+}
+
+// signal wakes up the waiting writes; it must be called under b.mu.
+func (b *backpressure) signal() {
+	close(b.settled)
+	b.settled = make(chan struct{})
+}
+
+// wait blocks while the bound is reached, until ctx is done.
+func (b *backpressure) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	for {
+		b.mu.Lock()
+		full, settled := len(b.pending) >= b.max, b.settled
+		b.mu.Unlock()
+
+		if !full {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ErrIndexerSaturated, ctx.Err())
+		case <-settled:
+		}
+	}
+}
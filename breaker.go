@@ -0,0 +1,122 @@
+package zlog
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	// the cooldown passed, entries go through until the next outcome
+	breakerHalfOpen
+)
+
+// circuitBreaker stops handing entries to the bulk indexers while OpenSearch
+// keeps failing, so logging doesn't block on the writer timeout. It's shared by
+// all writers of a logger, which ship to the same cluster.
+//
+// The circuit opens after threshold consecutive failures, each less than a
+// cooldown apart, and half-opens once the cooldown passed: the next outcome
+// closes or reopens it.
+type circuitBreaker struct {
+	mu          sync.Mutex
+	threshold   int
+	cooldown    time.Duration
+	state       breakerState
+	failures    int
+	lastFailure time.Time
+	openedAt    time.Time
+	logger      *zap.Logger
+}
+
+// circuitBreaker returns the breaker of the logger, creating it on first use, or
+// nil without WithOpenSearchCircuitBreaker.
+func (o *LogOpts) circuitBreaker() *circuitBreaker {
+	if o.openSearchBreakerThreshold <= 0 {
+		return nil
+	}
+
+	if o.openSearchBreaker == nil {
+		o.openSearchBreaker = &circuitBreaker{
+			threshold: o.openSearchBreakerThreshold,
+			cooldown:  o.openSearchBreakerCooldown,
+			logger:    o.internalLogger,
+		}
+	}
+
+	return o.openSearchBreaker
+}
+
+// allow reports whether an entry may be handed to the bulk indexer.
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.state = breakerHalfOpen
+		b.logger.Info("OpenSearch circuit breaker half-open, probing the cluster")
+	}
+
+	return b.state != breakerOpen
+}
+
+// record updates the circuit with the outcome of a bulk request.
+func (b *circuitBreaker) record(state *flushState) {
+	switch {
+	case state.failed:
+		b.failure()
+	case state.delivered:
+		b.success()
+	}
+}
+
+// success records entries accepted by OpenSearch.
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerClosed {
+		b.logger.Info("OpenSearch circuit breaker closed")
+	}
+
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// failure records a failed bulk request or a refused entry.
+func (b *circuitBreaker) failure() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(b.lastFailure) > b.cooldown {
+		b.failures = 0
+	}
+
+	b.failures++
+	b.lastFailure = now
+
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.openedAt = now
+		b.logger.Warn("OpenSearch circuit breaker opened, skipping the bulk indexer",
+			zap.Int("failures", b.failures), zap.Duration("cooldown", b.cooldown))
+	}
+}
@@ -0,0 +1,121 @@
+package zlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// compressionCounter sums the request body sizes before and after compression.
+type compressionCounter struct {
+	uncompressed atomic.Uint64
+	compressed   atomic.Uint64
+}
+
+// compressTransport gzips request bodies in place of the OpenSearch client,
+// which compresses without telling how much it saved.
+type compressTransport struct {
+	next    http.RoundTripper
+	counter *compressionCounter
+}
+
+func (t *compressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return t.next.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	payload, err := gzipBytes(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress request body: %w", err)
+	}
+
+	t.counter.uncompressed.Add(uint64(len(body)))
+	t.counter.compressed.Add(uint64(len(payload)))
+
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(payload))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(payload)), nil
+	}
+	req.ContentLength = int64(len(payload))
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Length", strconv.Itoa(len(payload)))
+
+	return t.next.RoundTrip(req)
+}
+
+// compressedFieldSuffix marks the fields compressed with WithCompressLargeFields.
+const compressedFieldSuffix = "_compressed"
+
+// compressLargeFields replaces the top-level string fields of entry longer than
+// threshold bytes by their gzipped, base64 encoded value under the key with
+// compressedFieldSuffix. The reserved keys, e.g. the message, stay searchable.
+func compressLargeFields(entry map[string]interface{}, threshold int, reserved []string) error {
+	for key, value := range entry {
+		text, ok := value.(string)
+		if !ok || len(text) <= threshold || strings.HasSuffix(key, compressedFieldSuffix) ||
+			slices.Contains(reserved, key) {
+			continue
+		}
+
+		compressed, err := gzipBytes([]byte(text))
+		if err != nil {
+			return fmt.Errorf("failed to compress field %q: %w", key, err)
+		}
+
+		delete(entry, key)
+		entry[key+compressedFieldSuffix] = base64.StdEncoding.EncodeToString(compressed)
+	}
+
+	return nil
+}
+
+// DecompressField decodes the value of a field compressed with
+// WithCompressLargeFields, i.e. one whose key ends with "_compressed".
+func DecompressField(value string) (string, error) {
+	compressed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode compressed field: %w", err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress field: %w", err)
+	}
+
+	text, err := io.ReadAll(zr)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress field: %w", err)
+	}
+
+	return string(text), nil
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return compressed.Bytes(), nil
+}
@@ -0,0 +1,96 @@
+package zlog
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// contextFields are the WithContextKeys keys and WithContextFields extractors
+// of a logger.
+type contextFields struct {
+	keys       []interface{}
+	extractors []func(context.Context) []zap.Field
+}
+
+// from returns the fields found in ctx.
+func (c contextFields) from(ctx context.Context) []zap.Field {
+	var fields []zap.Field
+
+	for _, key := range c.keys {
+		if value := ctx.Value(key); value != nil {
+			fields = append(fields, zap.Any(fmt.Sprint(key), value))
+		}
+	}
+
+	for _, extract := range c.extractors {
+		fields = append(fields, extract(ctx)...)
+	}
+
+	return fields
+}
+
+// with returns a child of logger carrying the fields found in ctx.
+func (c contextFields) with(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	fields := c.from(ctx)
+	if len(fields) == 0 {
+		return logger
+	}
+
+	return logger.With(fields...)
+}
+
+// contextCore marks a logger built with WithContextKeys or WithContextFields and
+// carries what With looks up. It doesn't change how entries are written.
+type contextCore struct {
+	zapcore.Core
+	contextFields
+}
+
+func (c *contextCore) With(fields []zapcore.Field) zapcore.Core {
+	return &contextCore{Core: c.Core.With(fields), contextFields: c.contextFields}
+}
+
+// With returns a child of logger carrying the fields found in ctx: the values
+// stored under the keys registered with WithContextKeys, each named after
+// fmt.Sprint(key), followed by the fields returned by the WithContextFields
+// extractors. Keys missing from ctx are skipped.
+//
+// The keys and extractors are looked up in the core of logger, so only loggers
+// built by this package and their children, e.g. from With or Named, carry
+// them. Loggers whose core was wrapped since, e.g. with zap.WrapCore or
+// zap.IncreaseLevel, are returned unchanged like those built without either
+// option; Handle.With works with them.
+func With(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	core, ok := logger.Core().(*contextCore)
+	if !ok {
+		return logger
+	}
+
+	return core.with(ctx, logger)
+}
+
+// WithContext is the same as With.
+func WithContext(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	return With(ctx, logger)
+}
+
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx carrying logger, e.g. the request logger set
+// up by a middleware, see FromContext.
+func NewContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx by NewContext, or the global
+// logger, zap.L(), without one.
+func FromContext(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+
+	return zap.L()
+}
@@ -0,0 +1,81 @@
+package zlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type testCtxKey string
+
+func TestWithContext(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+
+	opt := &LogOpts{}
+	bindLogOpts(opt, WithContextKeys(testCtxKey("user_id"), testCtxKey("tenant_id"), testCtxKey("correlation_id")))
+	logger := zap.New(opt.wrapCore(obs))
+
+	ctx := context.WithValue(context.Background(), testCtxKey("user_id"), "u-42")
+	ctx = context.WithValue(ctx, testCtxKey("tenant_id"), 7)
+	ctx = context.WithValue(ctx, testCtxKey("unrelated"), "ignored")
+
+	WithContext(ctx, logger.With(zap.String("component", "api"))).Info("request handled")
+
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, map[string]interface{}{
+		"component": "api",
+		"user_id":   "u-42",
+		"tenant_id": int64(7),
+	}, logs.All()[0].ContextMap())
+}
+
+func TestWithContextWithoutKeys(t *testing.T) {
+	obs, _ := observer.New(zapcore.InfoLevel)
+	logger := zap.New(obs)
+
+	ctx := context.WithValue(context.Background(), testCtxKey("user_id"), "u-42")
+	assert.Same(t, logger, WithContext(ctx, logger))
+}
+
+type traceIDKey struct{}
+
+func TestWithContextFields(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+
+	traceID := func(ctx context.Context) []zap.Field {
+		if id, ok := ctx.Value(traceIDKey{}).(string); ok {
+			return []zap.Field{zap.String("trace_id", id)}
+		}
+
+		return nil
+	}
+
+	opt := &LogOpts{}
+	bindLogOpts(opt, WithContextKeys(testCtxKey("user_id")), WithContextFields(traceID))
+	logger := zap.New(opt.wrapCore(obs))
+
+	ctx := context.WithValue(context.Background(), traceIDKey{}, "4bf92f3577b34da6a3ce929d0e0e4736")
+	ctx = context.WithValue(ctx, testCtxKey("user_id"), "u-42")
+
+	With(ctx, logger).Info("traced")
+	With(context.Background(), logger).Info("untraced")
+
+	require.Equal(t, 2, logs.Len())
+	assert.Equal(t, map[string]interface{}{
+		"user_id":  "u-42",
+		"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+	}, logs.All()[0].ContextMap())
+	assert.Empty(t, logs.All()[1].ContextMap())
+}
+
+func TestNewContext(t *testing.T) {
+	assert.Same(t, zap.L(), FromContext(context.Background()))
+
+	logger := zap.NewNop()
+	assert.Same(t, logger, FromContext(NewContext(context.Background(), logger)))
+}
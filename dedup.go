@@ -0,0 +1,120 @@
+package zlog
+
+import (
+	"container/list"
+	"maps"
+	"sync"
+	"time"
+)
+
+const (
+	// repeatedKey holds the number of duplicates a summary entry stands for.
+	repeatedKey = "repeated"
+	// dedupMaxEntries bounds the entries tracked by a deduplicator; the oldest
+	// one is closed early when a new one doesn't fit.
+	dedupMaxEntries = 1000
+)
+
+// deduplicator suppresses the repeats of an entry within a window after it was
+// shipped and hands a summary of the suppressed ones to emit once the window
+// closes. Entries are identified by their fingerprint.
+type deduplicator struct {
+	window time.Duration
+	// receives a copy of the first entry and the number of suppressed repeats,
+	// called without holding mu
+	emit func(entry map[string]interface{}, repeated int)
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// open windows, oldest first
+	order *list.List
+}
+
+type dedupEntry struct {
+	key      string
+	first    map[string]interface{}
+	repeated int
+	timer    *time.Timer
+}
+
+func newDeduplicator(window time.Duration, emit func(entry map[string]interface{}, repeated int)) *deduplicator {
+	return &deduplicator{
+		window:  window,
+		emit:    emit,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// suppress reports whether the entry with the given fingerprint repeats one
+// shipped within the window, and counts it if so. Otherwise, it opens a window
+// for the entry and returns the entry evicted to make room for it, if any. The
+// caller passes it to summarize once it holds no lock emit needs.
+func (d *deduplicator) suppress(key string, entry map[string]interface{}) (bool, *dedupEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, ok := d.entries[key]; ok {
+		elem.Value.(*dedupEntry).repeated++
+
+		return true, nil
+	}
+
+	var evicted *dedupEntry
+	if d.order.Len() >= dedupMaxEntries {
+		evicted = d.remove(d.order.Front())
+	}
+
+	// the writer keeps modifying entry
+	ent := &dedupEntry{key: key, first: maps.Clone(entry)}
+	ent.timer = time.AfterFunc(d.window, func() { d.close(ent) })
+	d.entries[key] = d.order.PushBack(ent)
+
+	return false, evicted
+}
+
+// close ends the window of ent, unless it was closed already.
+func (d *deduplicator) close(ent *dedupEntry) {
+	d.mu.Lock()
+
+	var closed *dedupEntry
+	if elem, ok := d.entries[ent.key]; ok && elem.Value == ent {
+		closed = d.remove(elem)
+	}
+
+	d.mu.Unlock()
+
+	d.summarize(closed)
+}
+
+// flush ends all windows, e.g. on shutdown.
+func (d *deduplicator) flush() {
+	d.mu.Lock()
+
+	closed := make([]*dedupEntry, 0, d.order.Len())
+	for d.order.Len() > 0 {
+		closed = append(closed, d.remove(d.order.Front()))
+	}
+
+	d.mu.Unlock()
+
+	for _, ent := range closed {
+		d.summarize(ent)
+	}
+}
+
+// remove forgets an entry, d.mu must be held.
+func (d *deduplicator) remove(elem *list.Element) *dedupEntry {
+	ent := d.order.Remove(elem).(*dedupEntry)
+	ent.timer.Stop()
+	delete(d.entries, ent.key)
+
+	return ent
+}
+
+// summarize hands the suppressed repeats of ent to emit, ent may be nil.
+func (d *deduplicator) summarize(ent *dedupEntry) {
+	if ent != nil && ent.repeated > 0 {
+		d.emit(ent.first, ent.repeated)
+	}
+}
@@ -0,0 +1,173 @@
+package zlog
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// fallbackWriter keeps entries that could not be shipped to OpenSearch in a
+// local file. It's used from Write, under the writer's mutex, as well as from
+// the bulk indexer workers, so it relies on its own lock: taking the writer's
+// mutex from a worker could deadlock with a Write blocked in indexer.Add.
+//
+// Entries are tracked from the moment they are handed to the bulk indexer until
+// OpenSearch acknowledges them. A bulk request that fails as a whole, e.g.
+// because the cluster is unreachable, only reports an error without the
+// affected items, so the entries still unacknowledged when it started are
+// written to the file once it ends. Those include entries queued for, or in
+// flight in, other workers, which may thus end up both in OpenSearch and in the
+// file. At most maxFallbackPending entries are tracked, the oldest ones are
+// written to the file beyond that, so an outage can't exhaust the memory.
+type fallbackWriter struct {
+	mu  sync.Mutex
+	out *lumberjack.Logger
+	// the range of IDs of the pending entries
+	oldest  uint64
+	newest  uint64
+	pending map[uint64][]byte
+}
+
+// maxFallbackPending caps the entries tracked by a fallbackWriter.
+const maxFallbackPending = 10000
+
+type fallbackSavedKey struct{}
+
+func newFallbackWriter(filename string) *fallbackWriter {
+	return &fallbackWriter{
+		out:     newLJ(filename),
+		pending: make(map[uint64][]byte),
+	}
+}
+
+// track registers an entry handed to the bulk indexer under id, the error is
+// that of writing the oldest entry to the file once over the cap.
+func (f *fallbackWriter) track(id uint64, body []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pending[id] = body
+	f.newest = max(f.newest, id)
+
+	if len(f.pending) <= maxFallbackPending {
+		return nil
+	}
+
+	for ; f.oldest <= f.newest; f.oldest++ {
+		if oldest, ok := f.pending[f.oldest]; ok {
+			delete(f.pending, f.oldest)
+			return f.write(oldest)
+		}
+	}
+
+	return nil
+}
+
+// ack forgets an entry OpenSearch has accepted.
+func (f *fallbackWriter) ack(id uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.pending, id)
+}
+
+// fail writes a tracked entry to the fallback file.
+func (f *fallbackWriter) fail(id uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, ok := f.pending[id]
+	if !ok {
+		return nil
+	}
+
+	delete(f.pending, id)
+
+	return f.write(body)
+}
+
+// store writes an entry that was never handed to the bulk indexer to the file.
+func (f *fallbackWriter) store(body []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.write(body)
+}
+
+// endFlush writes the entries still unacknowledged at the start of a bulk
+// request that failed as a whole to the file.
+func (f *fallbackWriter) endFlush(state *flushState) error {
+	if f == nil || !state.failed {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.spill(state.lastTracked)
+}
+
+// flush writes the unacknowledged entries with IDs up to lastID, i.e. those
+// handed to a bulk indexer that has been closed since, to the fallback file and
+// closes it. The file is reopened by the next write.
+func (f *fallbackWriter) flush(lastID uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.spill(lastID); err != nil {
+		return err
+	}
+
+	if err := f.out.Close(); err != nil {
+		return fmt.Errorf("failed to close fallback file: %w", err)
+	}
+
+	return nil
+}
+
+// spill writes the unacknowledged entries with IDs up to lastID to the file in
+// log order; it must be called under f.mu.
+func (f *fallbackWriter) spill(lastID uint64) error {
+	ids := make([]uint64, 0, len(f.pending))
+	for id := range f.pending {
+		if id <= lastID {
+			ids = append(ids, id)
+		}
+	}
+
+	slices.Sort(ids)
+
+	for _, id := range ids {
+		body := f.pending[id]
+		delete(f.pending, id)
+
+		if err := f.write(body); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write appends an entry to the file; it must be called under f.mu.
+func (f *fallbackWriter) write(body []byte) error {
+	line := make([]byte, 0, len(body)+1)
+	line = append(append(line, body...), '\n')
+
+	if _, err := f.out.Write(line); err != nil {
+		return fmt.Errorf("failed to write fallback entry: %w", err)
+	}
+
+	return nil
+}
+
+// fallbackSaved records, in the context handed to OnFailure by failItem, that
+// the item was written to the fallback file.
+func fallbackSaved(ctx context.Context) {
+	if saved, ok := ctx.Value(fallbackSavedKey{}).(*bool); ok {
+		*saved = true
+	}
+}
@@ -0,0 +1,280 @@
+package zlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// missingFieldsKey lists the required fields an OpenSearch document lacks.
+	missingFieldsKey = "missing_fields"
+	// callerFunctionKey holds the calling function with WithCallerFunction.
+	callerFunctionKey = "func"
+	// originKey holds the origin of the entry with WithOriginTag.
+	originKey = "origin"
+	// errorFieldKey is the key of zap.Error.
+	errorFieldKey = "error"
+	// errorChainMaxLen bounds the errors ErrorChain walks, in case of cycles.
+	errorChainMaxLen = 32
+)
+
+// Origin returns the field overriding the origin set with WithOriginTag, for
+// sub-loggers, e.g. logger.With(zlog.Origin("http")), or single entries.
+func Origin(tag string) zap.Field {
+	return zap.String(originKey, tag)
+}
+
+// missingFields returns the keys entry doesn't have.
+func missingFields(entry map[string]interface{}, keys []string) []string {
+	var missing []string
+
+	for _, key := range keys {
+		if _, ok := entry[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+
+	return missing
+}
+
+// redactor returns the function applying WithRedactFields and WithRedactFunc to
+// an entry, or nil without them.
+func (o *LogOpts) redactor() func(entry map[string]interface{}) {
+	if len(o.redactFields) == 0 && len(o.redactFuncs) == 0 {
+		return nil
+	}
+
+	keys, mask, funcs := o.redactFields, o.redactMask, o.redactFuncs
+
+	return func(entry map[string]interface{}) {
+		for _, key := range keys {
+			redactField(entry, key, mask)
+		}
+
+		for _, fn := range funcs {
+			fn(entry)
+		}
+	}
+}
+
+// redactField replaces the value of key with mask. A key that isn't a field of
+// entry is split on its first dot and looked up in the nested object.
+func redactField(entry map[string]interface{}, key, mask string) {
+	if _, ok := entry[key]; ok {
+		entry[key] = mask
+		return
+	}
+
+	parent, rest, ok := strings.Cut(key, ".")
+	if !ok {
+		return
+	}
+
+	if nested, ok := entry[parent].(map[string]interface{}); ok {
+		redactField(nested, rest, mask)
+	}
+}
+
+// GeoPoint constructs a field holding coordinates in the {"lat":..,"lon":..}
+// shape OpenSearch accepts for geo_point mappings, as used by map visualizations.
+func GeoPoint(key string, lat, lon float64) zap.Field {
+	return zap.Object(key, zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddFloat64("lat", lat)
+		enc.AddFloat64("lon", lon)
+
+		return nil
+	}))
+}
+
+// RawJSON constructs a field embedding raw, e.g. an upstream response body, as
+// JSON rather than as an escaped string, so OpenSearch indexes its content.
+// Malformed JSON is logged as a string.
+func RawJSON(key string, raw []byte) zap.Field {
+	if !json.Valid(raw) {
+		return zap.ByteString(key, raw)
+	}
+
+	return zap.Reflect(key, json.RawMessage(raw))
+}
+
+// ErrorChain constructs an "error" field like zap.Error, as an object holding the
+// message, the messages of the errors it wraps in the "chain" array, and the
+// type of the root cause, e.g.
+//
+//	{"message":"load config: open app.yaml: no such file or directory",
+//	 "chain":["load config: open app.yaml: no such file or directory",
+//	          "open app.yaml: no such file or directory","no such file or directory"],
+//	 "type":"syscall.Errno"}
+//
+// The chain walks errors.Unwrap as well as the errors joined with errors.Join
+// or multiple %w verbs, depth first; the type is the one of the first error
+// that wraps nothing. OpenSearch can't map "error" both as a string and as an
+// object, so use it in place of zap.Error for all the entries of an index.
+func ErrorChain(err error) zap.Field {
+	if err == nil {
+		return zap.Skip()
+	}
+
+	var (
+		chain []string
+		leaf  error
+	)
+
+	var walk func(err error)
+	walk = func(err error) {
+		if err == nil || len(chain) >= errorChainMaxLen {
+			return
+		}
+
+		chain = append(chain, err.Error())
+
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				walk(e)
+			}
+
+			return
+		}
+
+		if next := errors.Unwrap(err); next != nil {
+			walk(next)
+		} else if leaf == nil {
+			leaf = err
+		}
+	}
+
+	walk(err)
+
+	if leaf == nil {
+		leaf = err
+	}
+
+	return zap.Object(errorFieldKey, zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("message", err.Error())
+		enc.AddString("type", fmt.Sprintf("%T", leaf))
+
+		return enc.AddArray("chain", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+			for _, msg := range chain {
+				enc.AppendString(msg)
+			}
+
+			return nil
+		}))
+	}))
+}
+
+// hiddenFieldsCore drops the fields with the given keys before they reach the
+// wrapped core.
+type hiddenFieldsCore struct {
+	zapcore.Core
+
+	hidden map[string]struct{}
+}
+
+func newHiddenFieldsCore(core zapcore.Core, keys []string) zapcore.Core {
+	hidden := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		hidden[key] = struct{}{}
+	}
+
+	return &hiddenFieldsCore{Core: core, hidden: hidden}
+}
+
+func (c *hiddenFieldsCore) With(fields []zapcore.Field) zapcore.Core {
+	return &hiddenFieldsCore{Core: c.Core.With(c.filter(fields)), hidden: c.hidden}
+}
+
+func (c *hiddenFieldsCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *hiddenFieldsCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(ent, c.filter(fields))
+}
+
+func (c *hiddenFieldsCore) filter(fields []zapcore.Field) []zapcore.Field {
+	kept := make([]zapcore.Field, 0, len(fields))
+
+	for _, field := range fields {
+		if _, ok := c.hidden[field.Key]; !ok {
+			kept = append(kept, field)
+		}
+	}
+
+	return kept
+}
+
+// callerFunctionCore adds the function of the entry's caller as a field.
+type callerFunctionCore struct {
+	zapcore.Core
+}
+
+func (c *callerFunctionCore) With(fields []zapcore.Field) zapcore.Core {
+	return &callerFunctionCore{Core: c.Core.With(fields)}
+}
+
+func (c *callerFunctionCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *callerFunctionCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if ent.Caller.Function != "" {
+		fields = append(fields[:len(fields):len(fields)], zap.String(callerFunctionKey, ent.Caller.Function))
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+// originCore adds the origin field to every entry. An origin field added with
+// With replaces the current origin instead of duplicating the key.
+type originCore struct {
+	zapcore.Core
+	origin string
+}
+
+func (c *originCore) With(fields []zapcore.Field) zapcore.Core {
+	origin := c.origin
+	kept := make([]zapcore.Field, 0, len(fields))
+
+	for _, field := range fields {
+		if field.Key == originKey && field.Type == zapcore.StringType {
+			origin = field.String
+			continue
+		}
+
+		kept = append(kept, field)
+	}
+
+	return &originCore{Core: c.Core.With(kept), origin: origin}
+}
+
+func (c *originCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *originCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	for _, field := range fields {
+		if field.Key == originKey {
+			return c.Core.Write(ent, fields)
+		}
+	}
+
+	return c.Core.Write(ent, append(fields[:len(fields):len(fields)], zap.String(originKey, c.origin)))
+}
@@ -0,0 +1,262 @@
+package zlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestGeoPoint(t *testing.T) {
+	buf, err := genJSONEncoder().EncodeEntry(zapcore.Entry{Message: "visit"}, []zap.Field{
+		GeoPoint("location", 52.52, 13.405),
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, buf.String(), `"location":{"lat":52.52,"lon":13.405}`)
+}
+
+func TestRawJSON(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchWorkers(1),
+	)
+	require.NoError(t, err)
+
+	logger.Info("upstream", RawJSON("response", []byte(`{"status":"ok","items":[1,2]}`)))
+	logger.Info("broken", RawJSON("response", []byte(`{"status":`)))
+	require.NoError(t, flushFunc(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 2)
+	assert.Equal(t, map[string]interface{}{"status": "ok", "items": []interface{}{float64(1), float64(2)}},
+		docs[0].Source["response"])
+	assert.Equal(t, `{"status":`, docs[1].Source["response"])
+}
+
+func TestConsoleHiddenFields(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+	buf := captureStdout(t)
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithConsole(true),
+		WithConsoleHiddenFields("trace_id", "span_id"),
+	)
+	require.NoError(t, err)
+
+	logger.With(zap.String("trace_id", "t-1")).Info("hello", zap.String("span_id", "s-1"), zap.String("user", "u-1"))
+	require.NoError(t, flushFunc(context.Background()))
+
+	console := buf.String()
+	assert.Contains(t, console, "hello")
+	assert.Contains(t, console, "u-1")
+	assert.NotContains(t, console, "trace_id")
+	assert.NotContains(t, console, "span_id")
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+	assert.Equal(t, "t-1", docs[0].Source["trace_id"])
+	assert.Equal(t, "s-1", docs[0].Source["span_id"])
+	assert.Equal(t, "u-1", docs[0].Source["user"])
+}
+
+func TestRedactFields(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t,
+		WithOpenSearchWorkers(1),
+		WithOpenSearchFallback(fallbackFile),
+		WithRedactFields([]string{"token", "user.email", "user.address.street", "missing.key"}, "***"),
+		WithRedactFunc(func(entry map[string]interface{}) {
+			if card, ok := entry["card"].(string); ok && len(card) > 4 {
+				entry["card"] = "****" + card[len(card)-4:]
+			}
+		}),
+	)
+
+	entry := `{"msg":"signup","token":"secret","card":"4111111111111111",` +
+		`"user":{"name":"ann","email":"ann@example.com","address":{"street":"Main St 1","city":"Oslo"}}}`
+
+	_, err := writer.Write([]byte(entry))
+	require.NoError(t, err)
+	require.NoError(t, writer.Flush(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+	assert.Equal(t, map[string]interface{}{
+		"msg":   "signup",
+		"token": "***",
+		"card":  "****1111",
+		"user": map[string]interface{}{
+			"name":    "ann",
+			"email":   "***",
+			"address": map[string]interface{}{"street": "***", "city": "Oslo"},
+		},
+	}, docs[0].Source)
+
+	fake.SetBulkStatus(http.StatusServiceUnavailable)
+
+	_, err = writer.Write([]byte(entry))
+	require.NoError(t, err)
+	require.NoError(t, writer.Flush(context.Background()))
+
+	lines := readLines(t, fallbackFile)
+	require.Len(t, lines, 1)
+	assert.NotContains(t, lines[0], "secret")
+	assert.NotContains(t, lines[0], "ann@example.com")
+	assert.NotContains(t, lines[0], "4111111111111111")
+}
+
+func TestCallerFunction(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+	buf := captureStdout(t)
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchErrorIndex("zlog-errors"),
+		WithConsole(true),
+		WithCallerFunction(true),
+	)
+	require.NoError(t, err)
+
+	logger.Info("hello")
+	require.NoError(t, flushFunc(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1, "level filters still apply")
+	assert.Equal(t, "github.com/coghost/zlog.TestCallerFunction", docs[0].Source["func"])
+	assert.Contains(t, buf.String(), "TestCallerFunction")
+}
+
+func TestOriginTag(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOriginTag("app"),
+	)
+	require.NoError(t, err)
+
+	httpLogger := logger.With(Origin("http"))
+
+	logger.Info("app")
+	httpLogger.Info("http")
+	httpLogger.With(Origin("db")).Info("db")
+	logger.Info("entry", Origin("cron"))
+	require.NoError(t, flushFunc(context.Background()))
+
+	origins := map[string]interface{}{}
+	for _, doc := range fake.Docs() {
+		origins[toString(doc.Source["msg"])] = doc.Source[originKey]
+	}
+
+	assert.Equal(t, map[string]interface{}{"app": "app", "http": "http", "db": "db", "entry": "cron"}, origins)
+}
+
+// failingWriter fails every write.
+var errDiskFull = errors.New("disk full")
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errDiskFull }
+
+func TestWrapperCoresReturnWriteErrors(t *testing.T) {
+	errorsOnly, logs := observer.New(zapcore.DebugLevel)
+	failing := zapcore.NewCore(genJSONEncoder(), zapcore.AddSync(failingWriter{}), zapcore.InfoLevel)
+	inner := zapcore.NewTee(failing, newLevelFilterCore(errorsOnly, zapcore.ErrorLevel))
+
+	for name, core := range map[string]zapcore.Core{
+		"hidden fields":   newHiddenFieldsCore(inner, []string{"secret"}),
+		"caller function": &callerFunctionCore{Core: inner},
+		"origin":          &originCore{Core: inner, origin: "app"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := core.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "hello"}, nil)
+			require.ErrorIs(t, err, errDiskFull)
+			assert.Zero(t, logs.Len(), "the levels of the tee still apply")
+		})
+	}
+}
+
+func TestWrapperCoresKeepSampling(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+
+	opt := &LogOpts{samplingTick: time.Minute, samplingFirst: 1}
+	core := &callerFunctionCore{Core: zapcore.NewTee(opt.sample(obs))}
+	logger := zap.New(core)
+
+	for range 3 {
+		logger.Info("repeated")
+	}
+
+	assert.Equal(t, 1, logs.Len(), "sampled although written without Check")
+}
+
+func TestErrorChain(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchWorkers(1),
+	)
+	require.NoError(t, err)
+
+	root := &os.PathError{Op: "open", Path: "/etc/app.yaml", Err: os.ErrNotExist}
+	wrapped := fmt.Errorf("load config: %w", root)
+	joined := errors.Join(wrapped, errors.New("cache cold"))
+
+	logger.Error("wrapped", ErrorChain(wrapped))
+	logger.Error("joined", ErrorChain(joined))
+	logger.Error("nil", ErrorChain(nil))
+	require.NoError(t, flushFunc(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 3)
+
+	assert.Equal(t, map[string]interface{}{
+		"message": "load config: open /etc/app.yaml: file does not exist",
+		"chain": []interface{}{
+			"load config: open /etc/app.yaml: file does not exist",
+			"open /etc/app.yaml: file does not exist",
+			"file does not exist",
+		},
+		"type": "*errors.errorString",
+	}, docs[0].Source["error"])
+
+	assert.Equal(t, map[string]interface{}{
+		"message": "load config: open /etc/app.yaml: file does not exist\ncache cold",
+		"chain": []interface{}{
+			"load config: open /etc/app.yaml: file does not exist\ncache cold",
+			"load config: open /etc/app.yaml: file does not exist",
+			"open /etc/app.yaml: file does not exist",
+			"file does not exist",
+			"cache cold",
+		},
+		"type": "*errors.errorString",
+	}, docs[1].Source["error"])
+
+	assert.NotContains(t, docs[2].Source, "error")
+}
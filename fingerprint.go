@@ -0,0 +1,37 @@
+package zlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+const (
+	fingerprintKey = "fingerprint"
+	// number of hash bytes kept, long enough to avoid collisions between log sites
+	fingerprintBytes = 8
+)
+
+// entryFingerprint returns a stable hash of the entry's message and the values
+// of the given fields. Everything else, e.g. timestamps or request IDs, is
+// ignored, so recurring events share a fingerprint. Missing fields hash as null.
+func entryFingerprint(entry map[string]interface{}, messageKey string, fields []string) string {
+	hash := sha256.New()
+	hash.Write([]byte(toString(entry[messageKey])))
+
+	for _, field := range fields {
+		value, _ := json.Marshal(entry[field])
+
+		hash.Write([]byte{0})
+		hash.Write([]byte(field))
+		hash.Write([]byte{'='})
+		hash.Write(value)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)[:fingerprintBytes])
+}
+
+func toString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
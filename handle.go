@@ -0,0 +1,134 @@
+package zlog
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Handle gives access to the outputs of a logger after its creation, e.g. to
+// export the indexer stats as gauges or to derive loggers sharing them. Pass it
+// to the constructor with WithHandle; it's safe for concurrent use.
+type Handle struct {
+	mu      sync.Mutex
+	writers openSearchWriters
+	// the logger before WithName and WithFields
+	logger *zap.Logger
+	// the WithContextKeys keys and WithContextFields extractors of the logger
+	ctxFields contextFields
+}
+
+func (h *Handle) bind(writers openSearchWriters) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writers = writers
+}
+
+func (h *Handle) bindLogger(logger *zap.Logger, ctxFields contextFields) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.logger = logger
+	h.ctxFields = ctxFields
+}
+
+// Stats returns the counters of the OpenSearch output, summed over all indices.
+// They are zero until the handle is bound to a logger.
+func (h *Handle) Stats() IndexerStats {
+	h.mu.Lock()
+	writers := h.writers
+	h.mu.Unlock()
+
+	return writers.stats()
+}
+
+// Clone returns a logger writing to the outputs of the bound logger, without
+// creating new ones, e.g. for a sub-component with its own name and fields.
+// Only WithName and WithFields apply; the name and fields of the bound logger
+// are not inherited. It returns a no-op logger until the handle is bound.
+func (h *Handle) Clone(opts ...LogOptFunc) *zap.Logger {
+	h.mu.Lock()
+	logger := h.logger
+	h.mu.Unlock()
+
+	if logger == nil {
+		return zap.NewNop()
+	}
+
+	opt := &LogOpts{}
+	bindLogOpts(opt, opts...)
+
+	return opt.decorate(logger)
+}
+
+// With is like the package level With, but uses the keys and extractors of the
+// bound logger, whatever the core of logger, e.g. once wrapped with
+// zap.WrapCore. It returns logger unchanged until the handle is bound.
+func (h *Handle) With(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	h.mu.Lock()
+	ctxFields := h.ctxFields
+	h.mu.Unlock()
+
+	return ctxFields.with(ctx, logger)
+}
+
+// StartPeriodicFlush ships the entries buffered for OpenSearch every interval,
+// keeping the outputs open, see openSearchWriter.Flush, until ctx is done or
+// stop is called. Unlike the CleanUp function, it leaves the background tasks
+// of the logger running, e.g. the stats report. stop waits for a running flush
+// and may be called more than once. Flushing stops by itself once the outputs
+// are closed.
+func (h *Handle) StartPeriodicFlush(ctx context.Context, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !h.flush(ctx) {
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// flush flushes the bound writers, reporting whether they are still open.
+func (h *Handle) flush(ctx context.Context) bool {
+	h.mu.Lock()
+	writers := h.writers
+	h.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+
+	for _, w := range writers {
+		err := w.Flush(ctx)
+		if errors.Is(err, ErrWriterClosed) {
+			return false
+		}
+
+		if err != nil && ctx.Err() == nil {
+			w.logger.Warn("Periodic flush failed", zap.Error(err))
+		}
+	}
+
+	return true
+}
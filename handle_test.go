@@ -0,0 +1,141 @@
+package zlog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestHandleStats(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	var handle Handle
+	assert.Equal(t, IndexerStats{}, handle.Stats())
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchErrorIndex("zlog-errors"),
+		WithHandle(&handle),
+	)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for range 3 {
+			logger.Info("hello")
+		}
+
+		logger.Error("boom")
+	}()
+
+	// concurrent reads while logging
+	_ = handle.Stats()
+
+	<-done
+	require.NoError(t, flushFunc(context.Background()))
+
+	assert.Equal(t, IndexerStats{Added: 4, Flushed: 4}, handle.Stats())
+}
+
+func TestHandleClone(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	var handle Handle
+	assert.NotNil(t, handle.Clone())
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchWorkers(1),
+		WithName("app"),
+		WithFields(zap.String("component", "main")),
+		WithHandle(&handle),
+	)
+	require.NoError(t, err)
+
+	clone := handle.Clone(WithName("worker"), WithFields(zap.Int("shard", 3)))
+
+	logger.Info("from main")
+	clone.Info("from worker")
+
+	require.NoError(t, flushFunc(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 2)
+	assert.Equal(t, "app", docs[0].Source["logger"])
+	assert.Equal(t, "main", docs[0].Source["component"])
+	assert.Equal(t, "worker", docs[1].Source["logger"])
+	assert.Equal(t, float64(3), docs[1].Source["shard"])
+	assert.NotContains(t, docs[1].Source, "component")
+	assert.Equal(t, IndexerStats{Added: 2, Flushed: 2}, handle.Stats(), "shared writer")
+}
+
+func TestHandlePeriodicFlush(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	var handle Handle
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchFlushInterval(time.Hour),
+		WithHandle(&handle),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := handle.StartPeriodicFlush(ctx, 20*time.Millisecond)
+
+	for i := 1; i <= 3; i++ {
+		logger.Info("tick")
+
+		require.Eventually(t, func() bool {
+			return len(fake.Docs()) == i
+		}, time.Second, 5*time.Millisecond, "flush %d", i)
+	}
+
+	cancel()
+	stop()
+	stop()
+
+	logger.Info("after stop")
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, fake.Docs(), 3, "no flush after stop")
+
+	require.NoError(t, flushFunc(context.Background()))
+	assert.Len(t, fake.Docs(), 4)
+}
+
+func TestHandleWith(t *testing.T) {
+	buf := captureStdout(t)
+
+	var handle Handle
+
+	logger := MustNewZapLogger(
+		WithDevEnv(false),
+		WithLJ(false),
+		WithContextKeys(testCtxKey("user_id")),
+		WithHandle(&handle),
+	)
+	wrapped := logger.WithOptions(zap.IncreaseLevel(zapcore.InfoLevel))
+
+	ctx := context.WithValue(context.Background(), testCtxKey("user_id"), "u-42")
+	assert.Same(t, wrapped, With(ctx, wrapped), "the wrapped core hides the keys")
+
+	handle.With(ctx, wrapped).Info("request handled")
+
+	require.Len(t, buf.Lines(), 1)
+	assert.Contains(t, buf.Lines()[0], `{"user_id": "u-42"}`)
+}
@@ -0,0 +1,77 @@
+package zlog
+
+import (
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// HTTPMiddleware logs every request served by the wrapped handler with its
+// method, path, status, duration, response size and remote address. The entry
+// is logged on the logger returned by With for the request context, so the
+// context fields are included, at error level for 5xx responses. The handler
+// finds that request logger with FromContext.
+func HTTPMiddleware(logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &responseRecorder{ResponseWriter: w}
+			reqLogger := With(r.Context(), logger)
+
+			next.ServeHTTP(recorder, r.WithContext(NewContext(r.Context(), reqLogger)))
+
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			fields := []zap.Field{
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", status),
+				zap.Duration("duration", time.Since(start)),
+				zap.Int("bytes", recorder.bytes),
+				zap.String("remote_addr", r.RemoteAddr),
+			}
+
+			if status >= http.StatusInternalServerError {
+				reqLogger.Error("HTTP request", fields...)
+				return
+			}
+
+			reqLogger.Info("HTTP request", fields...)
+		})
+	}
+}
+
+// responseRecorder captures the status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,69 @@
+package zlog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+
+	opt := &LogOpts{}
+	bindLogOpts(opt, WithContextKeys(testCtxKey("request_id")))
+	logger := zap.New(opt.wrapCore(obs))
+
+	handler := HTTPMiddleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+
+		FromContext(r.Context()).Info("creating item")
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/items?draft=1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), testCtxKey("request_id"), "r-1"))
+	req.RemoteAddr = "10.0.0.1:5000"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	require.Equal(t, 2, logs.Len())
+
+	handlerEntry := logs.All()[0]
+	assert.Equal(t, "creating item", handlerEntry.Message)
+	assert.Equal(t, map[string]interface{}{"request_id": "r-1"}, handlerEntry.ContextMap(), "the handler gets the request logger")
+
+	entry := logs.All()[1]
+	assert.Equal(t, "HTTP request", entry.Message)
+	assert.Equal(t, zapcore.InfoLevel, entry.Level)
+
+	fields := entry.ContextMap()
+	assert.Contains(t, fields, "duration")
+	delete(fields, "duration")
+	assert.Equal(t, map[string]interface{}{
+		"method":      http.MethodPost,
+		"path":        "/items",
+		"status":      int64(http.StatusCreated),
+		"bytes":       int64(len("created")),
+		"remote_addr": "10.0.0.1:5000",
+		"request_id":  "r-1",
+	}, fields)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+	require.Equal(t, 3, logs.Len())
+	assert.Equal(t, zapcore.ErrorLevel, logs.All()[2].Level)
+	assert.Equal(t, int64(http.StatusInternalServerError), logs.All()[2].ContextMap()["status"])
+}
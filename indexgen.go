@@ -1,7 +1,10 @@
 package zlog
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -13,16 +16,40 @@ const (
 	DateFormatDot   IndexFormat = "2006.01.02" // example: logs-2024.01.25
 	DateFormatDash  IndexFormat = "2006-01-02" // example: logs-2024-01-25
 	DateFormatShort IndexFormat = "20060102"   // example: logs-20240125
+
+	DateFormatMonthly IndexFormat = "2006.01" // example: logs-2024.01
+	// DateFormatWeekly names indices after the ISO week, which the time layouts
+	// can't express, example: logs-2024.w04
+	DateFormatWeekly IndexFormat = "weekly"
 )
 
-// For testing purposes
-var timeNow = time.Now
+var ErrInvalidIndexFormat = errors.New("invalid index date format")
+
+// formatCheckTime formats differently from the layout for every layout element.
+var formatCheckTime = time.Date(2001, 11, 23, 22, 44, 55, 0, time.UTC)
+
+// indexNameReplacer replaces the characters OpenSearch forbids in index names.
+var indexNameReplacer = strings.NewReplacer(
+	" ", "-", ",", "-", "#", "-", ":", "-", "\\", "-", "/", "-",
+	"*", "-", "?", "-", "\"", "-", "<", "-", ">", "-", "|", "-",
+)
 
 // IndexGenerator generates time-based index names
 type IndexGenerator struct {
+	// the base index name with the prefix and suffix
 	baseIndexName string
+	separator     string
 	format        string
 	location      *time.Location
+	sanitize      bool
+	clock         func() time.Time
+
+	// size based rollover, see withMaxBytes
+	maxBytes   int64
+	mu         sync.Mutex
+	date       string
+	written    int64
+	generation int
 }
 
 // IndexConfig configures how index names are generated
@@ -30,9 +57,20 @@ type IndexConfig struct {
 	BaseIndexName string
 	Format        string         // If empty, defaults to FormatDot
 	Location      *time.Location // If nil, defaults to UTC
+	// Prefix and Suffix surround the base index name, e.g. "env-team-logs-2024.01.25"
+	Prefix string
+	Suffix string
+	// Separator joins the parts of the name, defaults to "-"
+	Separator string
+	// Sanitize makes the generated names valid for OpenSearch, e.g. "App Logs"
+	// becomes "app-logs"
+	Sanitize bool
+	// Clock returns the time the index names are generated for, defaults to time.Now
+	Clock func() time.Time
 }
 
-// NewIndexGenerator creates a new index name generator
+// NewIndexGenerator creates a new index name generator. Config.Format isn't
+// checked, see ValidateIndexFormat.
 func NewIndexGenerator(config IndexConfig) *IndexGenerator {
 	if config.Location == nil {
 		config.Location = time.UTC
@@ -42,15 +80,154 @@ func NewIndexGenerator(config IndexConfig) *IndexGenerator {
 		config.Format = string(DateFormatDot) // Default format "2006.01.02"
 	}
 
+	if config.Separator == "" {
+		config.Separator = "-"
+	}
+
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+
+	baseIndexName := config.BaseIndexName
+	if config.Prefix != "" {
+		baseIndexName = config.Prefix + config.Separator + baseIndexName
+	}
+
+	if config.Suffix != "" {
+		baseIndexName += config.Separator + config.Suffix
+	}
+
+	if config.Sanitize {
+		baseIndexName = sanitizeIndexName(baseIndexName)
+	}
+
 	return &IndexGenerator{
-		baseIndexName: config.BaseIndexName,
+		baseIndexName: baseIndexName,
+		separator:     config.Separator,
 		format:        config.Format,
 		location:      config.Location,
+		sanitize:      config.Sanitize,
+		clock:         config.Clock,
 	}
 }
 
 func (g *IndexGenerator) GetIndexName() string {
-	return fmt.Sprintf("%s-%s", g.baseIndexName, timeNow().In(g.location).Format(g.format))
+	if g.maxBytes <= 0 {
+		return g.datedName()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.rolledName(g.datedName())
+}
+
+// indexNameFor returns the index name for an entry of size bytes. With
+// withMaxBytes, it counts the bytes written to the index and rolls over to the
+// next one once the limit would be crossed.
+func (g *IndexGenerator) indexNameFor(size int) string {
+	if g.maxBytes <= 0 {
+		return g.datedName()
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	name := g.datedName()
+	if name != g.date {
+		g.date, g.written, g.generation = name, 0, 0
+	}
+
+	if g.written > 0 && g.written+int64(size) > g.maxBytes {
+		g.written = 0
+		g.generation++
+	}
+
+	g.written += int64(size)
+
+	return g.rolledName(name)
+}
+
+// rolledName appends the rollover generation to the dated index name, if the
+// index rolled over since the date changed. g.mu must be held.
+func (g *IndexGenerator) rolledName(name string) string {
+	if name != g.date || g.generation == 0 {
+		return name
+	}
+
+	return fmt.Sprintf("%s-%06d", name, g.generation)
+}
+
+// withMaxBytes makes the generator roll over to a new index, suffixed with
+// -000001, -000002, ..., once about maxBytes were written to the current one.
+func (g *IndexGenerator) withMaxBytes(maxBytes int64) *IndexGenerator {
+	g.maxBytes = maxBytes
+	return g
+}
+
+// GetTenantIndexName returns the index name for tenant, which follows the base
+// index name, e.g. logs-acme-2024.01.25. Size based rollover doesn't apply.
+func (g *IndexGenerator) GetTenantIndexName(tenant string) string {
+	return g.dated(g.baseIndexName + g.separator + tenant)
+}
+
+func (g *IndexGenerator) datedName() string {
+	return g.dated(g.baseIndexName)
+}
+
+// dated appends the formatted date to base.
+func (g *IndexGenerator) dated(base string) string {
+	name := fmt.Sprintf("%s%s%s", base, g.separator, g.formatDate(g.clock().In(g.location)))
+	if g.sanitize {
+		// the format may produce illegal characters as well
+		return sanitizeIndexName(name)
+	}
+
+	return name
+}
+
+func (g *IndexGenerator) formatDate(t time.Time) string {
+	if g.format == string(DateFormatWeekly) {
+		// the ISO year differs from the calendar year around new year
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d.w%02d", year, week)
+	}
+
+	return t.Format(g.format)
+}
+
+// ValidateIndexFormat reports formats without any Go time layout element, e.g.
+// the Java style "yyyy-MM-dd", which would produce the same index name forever.
+func ValidateIndexFormat(format string) error {
+	if format == "" || format == string(DateFormatWeekly) {
+		return nil
+	}
+
+	if formatCheckTime.Format(format) == format {
+		return fmt.Errorf("%w: %q has no time layout elements, e.g. %q", ErrInvalidIndexFormat, format, DateFormatDot)
+	}
+
+	return nil
+}
+
+// sanitizeIndexName lowercases name and replaces the characters OpenSearch
+// rejects in index names with "-". Leading "-", "_" and "+" are removed.
+func sanitizeIndexName(name string) string {
+	name = indexNameReplacer.Replace(strings.ToLower(name))
+	return strings.TrimLeft(name, "-_+")
+}
+
+// withSuffix returns a generator for the indices named after the base index
+// name followed by suffix, e.g. logs-warm-2024.01.25.
+func (g *IndexGenerator) withSuffix(suffix string) *IndexGenerator {
+	return &IndexGenerator{
+		baseIndexName: g.baseIndexName + suffix,
+		separator:     g.separator,
+		format:        g.format,
+		location:      g.location,
+		sanitize:      g.sanitize,
+		clock:         g.clock,
+	}
 }
 
 // LoadLocation loads a timezone location or panics on error
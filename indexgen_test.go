@@ -90,14 +90,9 @@ func TestDailyIndexNameGenerator(t *testing.T) {
 		},
 	}
 
-	// Store original time.Now
-	originalTimeNow := timeNow
-	defer func() { timeNow = originalTimeNow }()
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Mock time.Now for this test
-			timeNow = tt.timeFunc
+			tt.config.Clock = tt.timeFunc
 
 			generator := NewIndexGenerator(tt.config)
 			got := generator.GetIndexName()
@@ -107,15 +102,15 @@ func TestDailyIndexNameGenerator(t *testing.T) {
 }
 
 func TestIndexRotation(t *testing.T) {
-	// Store original time.Now
-	originalTimeNow := timeNow
-	defer func() { timeNow = originalTimeNow }()
+	var now time.Time
+	clock := func() time.Time { return now }
 
 	// Create a generator with UTC timezone
 	generator := NewIndexGenerator(IndexConfig{
 		BaseIndexName: "app-logs",
 		Format:        string(DateFormatDot),
 		Location:      time.UTC,
+		Clock:         clock,
 	})
 
 	// Test scenarios for rotation
@@ -151,22 +146,22 @@ func TestIndexRotation(t *testing.T) {
 	}
 
 	for _, sc := range scenarios {
-		timeNow = func() time.Time { return sc.timestamp }
+		now = sc.timestamp
 		got := generator.GetIndexName()
 		assert.Equal(t, sc.expected, got, "Time: "+sc.timestamp.String())
 	}
 }
 
 func TestHourlyRotation(t *testing.T) {
-	// Store original time.Now
-	originalTimeNow := timeNow
-	defer func() { timeNow = originalTimeNow }()
+	var now time.Time
+	clock := func() time.Time { return now }
 
 	// Create a generator with hourly rotation
 	generator := NewIndexGenerator(IndexConfig{
 		BaseIndexName: "hourly-logs",
 		Format:        "2006.01.02-15",
 		Location:      time.UTC,
+		Clock:         clock,
 	})
 
 	scenarios := []struct {
@@ -195,28 +190,29 @@ func TestHourlyRotation(t *testing.T) {
 	}
 
 	for _, sc := range scenarios {
-		timeNow = func() time.Time { return sc.timestamp }
+		now = sc.timestamp
 		got := generator.GetIndexName()
 		assert.Equal(t, sc.expected, got, "Time: "+sc.timestamp.String())
 	}
 }
 
 func TestTimezoneRotation(t *testing.T) {
-	// Store original time.Now
-	originalTimeNow := timeNow
-	defer func() { timeNow = originalTimeNow }()
+	var now time.Time
+	clock := func() time.Time { return now }
 
 	// Create generators for different timezones
 	utcGen := NewIndexGenerator(IndexConfig{
 		BaseIndexName: "logs",
 		Format:        string(DateFormatDot),
 		Location:      time.UTC,
+		Clock:         clock,
 	})
 
 	tokyoGen := NewIndexGenerator(IndexConfig{
 		BaseIndexName: "logs",
 		Format:        string(DateFormatDot),
 		Location:      MustLoadLocation("Asia/Tokyo"),
+		Clock:         clock,
 	})
 
 	// Test UTC midnight and corresponding Tokyo time
@@ -244,7 +240,7 @@ func TestTimezoneRotation(t *testing.T) {
 
 	for _, sc := range scenarios {
 		t.Run(sc.description, func(t *testing.T) {
-			timeNow = func() time.Time { return sc.timestamp }
+			now = sc.timestamp
 
 			gotUTC := utcGen.GetIndexName()
 			assert.Equal(t, sc.expectedUTC, gotUTC, "UTC index at "+sc.timestamp.String())
@@ -254,3 +250,194 @@ func TestTimezoneRotation(t *testing.T) {
 		})
 	}
 }
+
+func TestSanitizedIndexName(t *testing.T) {
+	clock := func() time.Time { return time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name   string
+		config IndexConfig
+		want   string
+	}{
+		{
+			name:   "uppercase",
+			config: IndexConfig{BaseIndexName: "AppLogs", Sanitize: true},
+			want:   "applogs-2024.01.25",
+		},
+		{
+			name:   "spaces",
+			config: IndexConfig{BaseIndexName: "App Logs", Sanitize: true},
+			want:   "app-logs-2024.01.25",
+		},
+		{
+			name:   "reserved characters",
+			config: IndexConfig{BaseIndexName: `a,b#c:d\e/f*g?h"i<j>k|l`, Sanitize: true},
+			want:   "a-b-c-d-e-f-g-h-i-j-k-l-2024.01.25",
+		},
+		{
+			name:   "leading characters",
+			config: IndexConfig{BaseIndexName: "_+-logs", Sanitize: true},
+			want:   "logs-2024.01.25",
+		},
+		{
+			name:   "format",
+			config: IndexConfig{BaseIndexName: "logs", Format: "Jan 2006", Sanitize: true},
+			want:   "logs-jan-2024",
+		},
+		{
+			name:   "disabled",
+			config: IndexConfig{BaseIndexName: "App Logs"},
+			want:   "App Logs-2024.01.25",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Clock = clock
+			generator := NewIndexGenerator(tt.config)
+			assert.Equal(t, tt.want, generator.GetIndexName())
+		})
+	}
+}
+
+func TestIndexNameAffixes(t *testing.T) {
+	clock := func() time.Time { return time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name   string
+		config IndexConfig
+		want   string
+	}{
+		{
+			name:   "defaults",
+			config: IndexConfig{BaseIndexName: "logs"},
+			want:   "logs-2024.01.25",
+		},
+		{
+			name:   "prefix",
+			config: IndexConfig{BaseIndexName: "logs", Prefix: "env-team"},
+			want:   "env-team-logs-2024.01.25",
+		},
+		{
+			name:   "suffix",
+			config: IndexConfig{BaseIndexName: "logs", Suffix: "eu"},
+			want:   "logs-eu-2024.01.25",
+		},
+		{
+			name:   "prefix and suffix",
+			config: IndexConfig{BaseIndexName: "logs", Prefix: "prod", Suffix: "eu"},
+			want:   "prod-logs-eu-2024.01.25",
+		},
+		{
+			name:   "dot separator",
+			config: IndexConfig{BaseIndexName: "logs", Separator: "."},
+			want:   "logs.2024.01.25",
+		},
+		{
+			name:   "custom separator with prefix and suffix",
+			config: IndexConfig{BaseIndexName: "logs", Prefix: "prod", Suffix: "eu", Separator: "_", Format: string(DateFormatShort)},
+			want:   "prod_logs_eu_20240125",
+		},
+		{
+			name:   "sanitized prefix",
+			config: IndexConfig{BaseIndexName: "logs", Prefix: "Team A", Sanitize: true},
+			want:   "team-a-logs-2024.01.25",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Clock = clock
+			generator := NewIndexGenerator(tt.config)
+			assert.Equal(t, tt.want, generator.GetIndexName())
+		})
+	}
+}
+
+func TestWeeklyAndMonthlyIndexNames(t *testing.T) {
+	var now time.Time
+	clock := func() time.Time { return now }
+
+	weekly := NewIndexGenerator(IndexConfig{BaseIndexName: "logs", Format: string(DateFormatWeekly), Clock: clock})
+	monthly := NewIndexGenerator(IndexConfig{BaseIndexName: "logs", Format: string(DateFormatMonthly), Clock: clock})
+
+	tests := []struct {
+		name        string
+		timestamp   time.Time
+		wantWeekly  string
+		wantMonthly string
+	}{
+		{
+			name:        "mid year",
+			timestamp:   time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC),
+			wantWeekly:  "logs-2024.w04",
+			wantMonthly: "logs-2024.01",
+		},
+		{
+			name:        "Jan 1 in week 52 of the prior year",
+			timestamp:   time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+			wantWeekly:  "logs-2022.w52",
+			wantMonthly: "logs-2023.01",
+		},
+		{
+			name:        "Jan 1 in week 53 of the prior year",
+			timestamp:   time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC),
+			wantWeekly:  "logs-2020.w53",
+			wantMonthly: "logs-2021.01",
+		},
+		{
+			name:        "Dec 30 in week 1 of the next year",
+			timestamp:   time.Date(2024, 12, 30, 12, 0, 0, 0, time.UTC),
+			wantWeekly:  "logs-2025.w01",
+			wantMonthly: "logs-2024.12",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = tt.timestamp
+
+			assert.Equal(t, tt.wantWeekly, weekly.GetIndexName())
+			assert.Equal(t, tt.wantWeekly, sanitizeIndexName(tt.wantWeekly), "valid without IndexConfig.Sanitize")
+			assert.Equal(t, tt.wantMonthly, monthly.GetIndexName())
+		})
+	}
+}
+
+func TestIndexSizeRollover(t *testing.T) {
+	now := time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC)
+	generator := NewIndexGenerator(IndexConfig{BaseIndexName: "logs", Clock: func() time.Time { return now }}).withMaxBytes(100)
+
+	assert.Equal(t, "logs-2024.01.25", generator.indexNameFor(60))
+	assert.Equal(t, "logs-2024.01.25", generator.indexNameFor(40), "the limit is not crossed yet")
+	assert.Equal(t, "logs-2024.01.25-000001", generator.indexNameFor(1))
+	assert.Equal(t, "logs-2024.01.25-000001", generator.GetIndexName())
+	assert.Equal(t, "logs-2024.01.25-000002", generator.indexNameFor(150), "an entry larger than the limit gets its own index")
+	assert.Equal(t, "logs-2024.01.25-000003", generator.indexNameFor(10))
+
+	now = now.Add(24 * time.Hour)
+
+	assert.Equal(t, "logs-2024.01.26", generator.GetIndexName(), "the counter restarts with the date")
+	assert.Equal(t, "logs-2024.01.26", generator.indexNameFor(90))
+	assert.Equal(t, "logs-2024.01.26-000001", generator.indexNameFor(20))
+}
+
+func TestValidateIndexFormat(t *testing.T) {
+	for _, format := range []string{"", string(DateFormatDot), string(DateFormatShort), string(DateFormatWeekly), "2006-01-02-15", "logs-2006"} {
+		assert.NoError(t, ValidateIndexFormat(format), format)
+	}
+
+	for _, format := range []string{"yyyy-MM-dd", "YYYY.MM.DD", "daily"} {
+		assert.ErrorIs(t, ValidateIndexFormat(format), ErrInvalidIndexFormat, format)
+	}
+}
+
+func TestTenantIndexName(t *testing.T) {
+	generator := NewIndexGenerator(IndexConfig{
+		BaseIndexName: "logs",
+		Clock:         func() time.Time { return time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC) },
+	})
+
+	assert.Equal(t, "logs-acme-2024.01.25", generator.GetTenantIndexName("acme"))
+	assert.Equal(t, "logs-warm-acme-2024.01.25", generator.withSuffix(warmIndexSuffix).GetTenantIndexName("acme"))
+}
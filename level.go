@@ -0,0 +1,156 @@
+package zlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// levelFilterCore only passes the levels allowed by enabler to the wrapped core,
+// unlike zapcore.NewIncreaseLevelCore it may also drop the higher levels.
+type levelFilterCore struct {
+	zapcore.Core
+
+	enabler zapcore.LevelEnabler
+}
+
+func newLevelFilterCore(core zapcore.Core, enabler zapcore.LevelEnabler) zapcore.Core {
+	return &levelFilterCore{Core: core, enabler: enabler}
+}
+
+func (c *levelFilterCore) Enabled(level zapcore.Level) bool {
+	return c.enabler.Enabled(level) && c.Core.Enabled(level)
+}
+
+func (c *levelFilterCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelFilterCore{Core: c.Core.With(fields), enabler: c.enabler}
+}
+
+func (c *levelFilterCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabler.Enabled(ent.Level) {
+		return ce
+	}
+
+	return c.Core.Check(ent, ce)
+}
+
+// Write drops the levels filtered out too, since the cores wrapping a tee, see
+// wrapCore, write to it without going through Check.
+func (c *levelFilterCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if !c.enabler.Enabled(ent.Level) {
+		return nil
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+// levelHooks holds the WithOnLevelChange callbacks of the loggers by level,
+// from their creation until their CleanUp function is called.
+var levelHooks = struct {
+	sync.Mutex
+	hooks map[zap.AtomicLevel][]*levelHook
+}{hooks: make(map[zap.AtomicLevel][]*levelHook)}
+
+// levelHook holds the callbacks of a logger.
+type levelHook struct {
+	fns []func(old, new zapcore.Level)
+}
+
+// addLevelHooks registers hooks for lvl and returns the function removing them,
+// which may be called more than once.
+func addLevelHooks(lvl zap.AtomicLevel, hooks ...func(old, new zapcore.Level)) (remove func()) {
+	hook := &levelHook{fns: hooks}
+
+	levelHooks.Lock()
+	defer levelHooks.Unlock()
+
+	levelHooks.hooks[lvl] = append(levelHooks.hooks[lvl], hook)
+
+	return sync.OnceFunc(func() {
+		levelHooks.Lock()
+		defer levelHooks.Unlock()
+
+		// SetLevel may be calling the current slice
+		remaining := slices.DeleteFunc(slices.Clone(levelHooks.hooks[lvl]), func(h *levelHook) bool {
+			return h == hook
+		})
+
+		if len(remaining) == 0 {
+			delete(levelHooks.hooks, lvl)
+			return
+		}
+
+		levelHooks.hooks[lvl] = remaining
+	})
+}
+
+// SetLevel changes lvl, the level given to WithAtomicLevel, and calls the
+// WithOnLevelChange callbacks of the loggers using it if the level changed.
+// Calling lvl.SetLevel directly bypasses the callbacks.
+func SetLevel(lvl zap.AtomicLevel, level zapcore.Level) {
+	levelHooks.Lock()
+	old := lvl.Level()
+	lvl.SetLevel(level)
+	hooks := levelHooks.hooks[lvl]
+	levelHooks.Unlock()
+
+	if old == level {
+		return
+	}
+
+	for _, hook := range hooks {
+		for _, fn := range hook.fns {
+			fn(old, level)
+		}
+	}
+}
+
+type levelPayload struct {
+	Level *zapcore.Level `json:"level"`
+}
+
+type levelError struct {
+	Error string `json:"error"`
+}
+
+// LevelHandler serves the level shared by the outputs of a logger, as given to
+// WithAtomicLevel, e.g. under /debug/loglevel. GET returns it as
+// {"level":"info"} and PUT sets it from a body of the same form, like SetLevel.
+func LevelHandler(lvl zap.AtomicLevel) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var payload levelPayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				writeLevelError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+				return
+			}
+
+			if payload.Level == nil {
+				writeLevelError(w, http.StatusBadRequest, "must specify a logging level")
+				return
+			}
+
+			SetLevel(lvl, *payload.Level)
+		default:
+			writeLevelError(w, http.StatusMethodNotAllowed, "only GET and PUT are supported")
+			return
+		}
+
+		current := lvl.Level()
+		_ = json.NewEncoder(w).Encode(levelPayload{Level: &current})
+	})
+}
+
+func writeLevelError(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(levelError{Error: msg})
+}
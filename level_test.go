@@ -0,0 +1,111 @@
+package zlog
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLevelHandler(t *testing.T) {
+	buf := captureStdout(t)
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	logger := MustNewZapLogger(WithDevEnv(false), WithLJ(false), WithAtomicLevel(level))
+
+	server := httptest.NewServer(LevelHandler(level))
+	defer server.Close()
+
+	do := func(method, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL, strings.NewReader(body))
+		require.NoError(t, err)
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		var out strings.Builder
+		_, err = io.Copy(&out, res.Body)
+		require.NoError(t, err)
+
+		return res.StatusCode, strings.TrimSpace(out.String())
+	}
+
+	status, body := do(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"level":"info"}`, body)
+
+	logger.Debug("filtered")
+
+	status, body = do(http.MethodPut, `{"level":"debug"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"level":"debug"}`, body)
+
+	logger.Debug("passes")
+
+	lines := buf.Lines()
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], "passes")
+
+	status, _ = do(http.MethodPut, `{"level":"loud"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = do(http.MethodPut, `{}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = do(http.MethodPost, `{"level":"warn"}`)
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+	assert.Equal(t, zapcore.DebugLevel, level.Level())
+}
+
+func TestOnLevelChange(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
+	var changes [][2]zapcore.Level
+
+	_, cleanUp := MustNewZapLoggerWithFlush(WithDevEnv(false), WithLJ(false), WithAtomicLevel(level),
+		WithOnLevelChange(func(old, new zapcore.Level) {
+			changes = append(changes, [2]zapcore.Level{old, new})
+		}))
+
+	SetLevel(level, zapcore.WarnLevel)
+	SetLevel(level, zapcore.WarnLevel)
+
+	server := httptest.NewServer(LevelHandler(level))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPut, server.URL, strings.NewReader(`{"level":"debug"}`))
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	assert.Equal(t, [][2]zapcore.Level{
+		{zapcore.InfoLevel, zapcore.WarnLevel},
+		{zapcore.WarnLevel, zapcore.DebugLevel},
+	}, changes)
+
+	require.NoError(t, cleanUp(context.Background()))
+	SetLevel(level, zapcore.ErrorLevel)
+
+	assert.Len(t, changes, 2, "CleanUp removes the callbacks")
+
+	levelHooks.Lock()
+	assert.NotContains(t, levelHooks.hooks, level)
+	levelHooks.Unlock()
+}
+
+func TestOnLevelChangeWithoutAtomicLevel(t *testing.T) {
+	hook := WithOnLevelChange(func(zapcore.Level, zapcore.Level) {})
+
+	_, err := NewZapLogger(WithLJ(false), hook)
+	require.ErrorIs(t, err, ErrOnLevelChangeWithoutAtomicLevel)
+
+	_, _, err = NewZapLoggerWithOpenSearch(WithOpenSearchConfig(&opensearch.Config{}), WithOpenSearchIndex("logs", ""), hook)
+	require.ErrorIs(t, err, ErrOnLevelChangeWithoutAtomicLevel)
+}
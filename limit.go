@@ -0,0 +1,51 @@
+package zlog
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const limitReachedMessage = "log limit reached"
+
+// limitCore forwards at most max entries to the wrapped core. The first entry
+// past the limit is replaced by a single warning so the cutoff is visible in
+// every sink; everything after that is discarded.
+type limitCore struct {
+	zapcore.Core
+
+	max   uint64
+	count *atomic.Uint64
+}
+
+func newLimitCore(core zapcore.Core, maxEntries uint64) zapcore.Core {
+	return &limitCore{Core: core, max: maxEntries, count: new(atomic.Uint64)}
+}
+
+func (c *limitCore) With(fields []zapcore.Field) zapcore.Core {
+	return &limitCore{Core: c.Core.With(fields), max: c.max, count: c.count}
+}
+
+func (c *limitCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
+	}
+
+	n := c.count.Add(1)
+	if n <= c.max {
+		return c.Core.Check(ent, ce)
+	}
+
+	if n == c.max+1 {
+		warn := zapcore.Entry{
+			Level:      zapcore.WarnLevel,
+			Time:       ent.Time,
+			LoggerName: ent.LoggerName,
+			Message:    limitReachedMessage,
+		}
+		_ = c.Core.Write(warn, []zapcore.Field{zap.Uint64("max_entries", c.max)})
+	}
+
+	return ce
+}
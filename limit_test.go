@@ -0,0 +1,33 @@
+package zlog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLimitCore(t *testing.T) {
+	obs, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(newLimitCore(obs, 3)).With(zap.String("component", "job"))
+
+	for i := 0; i < 10; i++ {
+		logger.Info("working", zap.Int("i", i))
+	}
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 4)
+
+	for i, entry := range entries[:3] {
+		assert.Equal(t, "working", entry.Message)
+		assert.Equal(t, int64(i), entry.ContextMap()["i"])
+	}
+
+	last := entries[3]
+	assert.Equal(t, zapcore.WarnLevel, last.Level)
+	assert.Equal(t, limitReachedMessage, last.Message)
+	assert.Equal(t, uint64(3), last.ContextMap()["max_entries"])
+	assert.Equal(t, "job", last.ContextMap()["component"])
+}
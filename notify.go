@@ -0,0 +1,57 @@
+package zlog
+
+import "context"
+
+type flushStateKey struct{}
+
+// flushState tracks the outcome of one bulk request, from OnFlushStart to
+// OnFlushEnd. The bulk indexer calls the hooks and the item callbacks of a
+// request from the same worker goroutine.
+type flushState struct {
+	delivered bool
+	failed    bool
+	// the ID of the last item handed to the bulk indexers at the start
+	lastTracked uint64
+}
+
+func flushStateFrom(ctx context.Context) *flushState {
+	state, _ := ctx.Value(flushStateKey{}).(*flushState)
+	return state
+}
+
+func startFlush(ctx context.Context) context.Context {
+	return context.WithValue(ctx, flushStateKey{}, &flushState{})
+}
+
+// flushDelivered records an entry accepted by OpenSearch during the flush of ctx.
+func flushDelivered(ctx context.Context) {
+	if state := flushStateFrom(ctx); state != nil {
+		state.delivered = true
+	}
+}
+
+// flushFailed records an error of the flush of ctx. Errors reported outside a
+// flush, or again by the worker once the flush returned, are ignored.
+func flushFailed(ctx context.Context) {
+	if state := flushStateFrom(ctx); state != nil {
+		state.failed = true
+	}
+}
+
+// flushNotifier signals ch after every bulk request that delivered entries
+// without failing as a whole. Signals are dropped while ch is full, so it never
+// stalls the bulk indexer.
+type flushNotifier struct {
+	ch chan<- struct{}
+}
+
+func (n *flushNotifier) end(state *flushState) {
+	if n == nil || !state.delivered || state.failed {
+		return
+	}
+
+	select {
+	case n.ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,22 @@
+package zlog
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// loggedOnce holds the keys passed to LogOnce so far.
+var loggedOnce sync.Map
+
+// LogOnce logs msg at level unless a message was already logged with the same key,
+// e.g. for startup warnings or deprecation notices on a hot path. Keys are global
+// to the process, whatever logger is passed.
+func LogOnce(logger *zap.Logger, key string, level zapcore.Level, msg string, fields ...zap.Field) {
+	if _, seen := loggedOnce.LoadOrStore(key, struct{}{}); seen {
+		return
+	}
+
+	logger.WithOptions(zap.AddCallerSkip(1)).Log(level, msg, fields...)
+}
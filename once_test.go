@@ -0,0 +1,40 @@
+package zlog
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogOnce(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(obs, zap.AddCaller())
+
+	var wg sync.WaitGroup
+
+	for i := range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			LogOnce(logger, "test-deprecated-option", zapcore.WarnLevel, "option is deprecated", zap.Int("call", i))
+		}()
+	}
+
+	wg.Wait()
+
+	LogOnce(logger, "test-other-key", zapcore.InfoLevel, "other")
+
+	require.Equal(t, 2, logs.Len())
+
+	entry := logs.All()[0]
+	assert.Equal(t, "option is deprecated", entry.Message)
+	assert.Equal(t, zapcore.WarnLevel, entry.Level)
+	assert.Contains(t, entry.Caller.File, "once_test.go")
+	assert.Equal(t, "other", logs.All()[1].Message)
+}
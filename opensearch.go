@@ -7,13 +7,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
-	"os"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/time/rate"
 
 	"github.com/opensearch-project/opensearch-go"
 	"github.com/opensearch-project/opensearch-go/opensearchutil"
@@ -23,12 +29,45 @@ type CleanUp func(context.Context) error
 
 const (
 	writerCtxTimeout = 5 * time.Second
+	syncTimeout      = 30 * time.Second
+	shutdownTimeout  = 30 * time.Second
 	numberOfWorkers  = 2
+	maxWorkersPerCPU = 4
 	flushBytes       = 256 * 1024
 	flushInterval    = 10 * time.Second
+
+	versionTypeExternal = "external"
+
+	bulkActionIndex  = "index"
+	bulkActionCreate = "create"
+
+	warmIndexSuffix = "-warm"
+
+	// appended to the stack traces shortened by WithOpenSearchMaxDocSize
+	truncatedMarker = "... (truncated)"
 )
 
-var ErrCreateOpensearchCore = errors.New("failed to create OpenSearch core")
+var (
+	ErrCreateOpensearchCore       = errors.New("failed to create OpenSearch core")
+	ErrOpenSearchConfigMissing    = errors.New("OpenSearch config must be provided when OpenSearch logging is enabled")
+	ErrOpenSearchIndexMissing     = errors.New("OpenSearch index must be provided when OpenSearch logging is enabled")
+	ErrDataStreamWithIndex        = errors.New("OpenSearch data stream can't be combined with a dated index")
+	ErrRolloverWithIndex          = errors.New("OpenSearch rollover alias can't be combined with a dated index or data stream")
+	ErrNoRolloverConditions       = errors.New("at least one OpenSearch rollover condition must be set")
+	ErrInvalidRefreshPolicy       = errors.New(`OpenSearch refresh policy must be "true", "false" or "wait_for"`)
+	ErrNoLoggingOutputs           = errors.New("no logging outputs specified")
+	ErrNoCACertificates           = errors.New("no certificates found in CA file")
+	ErrTLSWithCustomTransport     = errors.New("TLS options can't be combined with a custom OpenSearch transport")
+	ErrTLSRequiresHTTPTransport   = errors.New("TLS options require the OpenSearch transport to be an *http.Transport")
+	ErrNoOpenSearchAddresses      = errors.New("at least one OpenSearch address must be provided")
+	ErrInvalidOpenSearchAddress   = errors.New("invalid OpenSearch address")
+	ErrInvalidProxyURL            = errors.New("invalid OpenSearch proxy URL")
+	ErrProxyWithCustomTransport   = errors.New("a proxy can't be combined with a custom OpenSearch transport")
+	ErrProxyRequiresHTTPTransport = errors.New("a proxy requires the OpenSearch transport to be an *http.Transport")
+	ErrOpenSearchUnreachable      = errors.New("OpenSearch is unreachable")
+
+	ErrOnLevelChangeWithoutAtomicLevel = errors.New("WithOnLevelChange requires WithAtomicLevel")
+)
 
 func DefaultOpenSearchConfig(url string, insecure bool) opensearch.Config {
 	return opensearch.Config{
@@ -39,6 +78,42 @@ func DefaultOpenSearchConfig(url string, insecure bool) opensearch.Config {
 	}
 }
 
+// DefaultOpenSearchConfigMulti is like DefaultOpenSearchConfig for a cluster
+// reachable through several nodes, which the client uses in turn, so a single
+// failing node doesn't stop shipping. It returns an error if urls is empty or
+// holds an invalid URL.
+func DefaultOpenSearchConfigMulti(urls []string, insecure bool) (opensearch.Config, error) {
+	if err := validateAddresses(urls); err != nil {
+		return opensearch.Config{}, err
+	}
+
+	config := DefaultOpenSearchConfig("", insecure)
+	config.Addresses = append([]string{}, urls...)
+
+	return config, nil
+}
+
+// validateAddresses checks that urls holds at least one absolute URL and no
+// invalid ones.
+func validateAddresses(urls []string) error {
+	if len(urls) == 0 {
+		return ErrNoOpenSearchAddresses
+	}
+
+	for _, address := range urls {
+		u, err := url.Parse(address)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidOpenSearchAddress, err)
+		}
+
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%w: %q is not an absolute URL", ErrInvalidOpenSearchAddress, address)
+		}
+	}
+
+	return nil
+}
+
 // MustNewZapLoggerWithOpenSearch creates a zap logger with OpenSearch support.
 // It panics if the required OpenSearch configuration is missing or if initialization fails.
 //
@@ -48,18 +123,44 @@ func DefaultOpenSearchConfig(url string, insecure bool) opensearch.Config {
 // Returns:
 //   - *zap.Logger: A configured zap logger instance
 //   - func() error: A flush function that should be called before program termination
-//     to ensure all logs are written to OpenSearch
+//     to ensure all logs are written to OpenSearch. The logger keeps shipping
+//     logs afterwards, but the background tasks stop for good: the stats report,
+//     the rollover checks and the reconnect attempts. To flush periodically, use
+//     logger.Sync(), which flushes with a 30 seconds timeout and leaves them
+//     running, or Handle.StartPeriodicFlush.
 //
 // OpenSearch Configuration:
 //   - Bulk Indexing: Uses OpenSearch bulk API for efficient log shipping
-//   - Workers: 2 concurrent workers for processing logs
-//   - Buffer Size: 256KB before forcing flush
-//   - Flush Interval: Every 10 seconds
+//   - Workers: 2 concurrent workers for processing logs (see WithOpenSearchWorkers)
+//   - Buffer Size: 256KB before forcing flush (see WithOpenSearchFlushBytes)
+//   - Flush Interval: Every 10 seconds (see WithOpenSearchFlushInterval)
 //   - Error Handling: Logs bulk indexing errors through internal logger
 //
 // The function supports both console and OpenSearch output. When OpenSearch is enabled,
 // both openSearchConfig and openSearchIndex must be provided through the options.
+//
+// Use NewZapLoggerWithOpenSearch to handle configuration problems without a panic.
 func MustNewZapLoggerWithOpenSearch(opts ...LogOptFunc) (*zap.Logger, CleanUp) {
+	opt := newOpenSearchLogOpts(opts...)
+
+	logger, flushFunc, err := newZapLoggerWithOpenSearch(opt)
+	if err != nil {
+		opt.internalLogger.Panic(err.Error())
+	}
+
+	return logger, flushFunc
+}
+
+// NewZapLoggerWithOpenSearch is like MustNewZapLoggerWithOpenSearch, but reports
+// configuration and initialization problems as an error instead of panicking, so
+// callers can fall back to another logger. Errors wrap ErrOpenSearchConfigMissing,
+// ErrOpenSearchIndexMissing, ErrDataStreamWithIndex, ErrCreateOpensearchCore or
+// ErrOnLevelChangeWithoutAtomicLevel.
+func NewZapLoggerWithOpenSearch(opts ...LogOptFunc) (*zap.Logger, CleanUp, error) {
+	return newZapLoggerWithOpenSearch(newOpenSearchLogOpts(opts...))
+}
+
+func newOpenSearchLogOpts(opts ...LogOptFunc) *LogOpts {
 	opt := &LogOpts{
 		level:       zapcore.InfoLevel,
 		withConsole: false,
@@ -69,90 +170,305 @@ func MustNewZapLoggerWithOpenSearch(opts ...LogOptFunc) (*zap.Logger, CleanUp) {
 	}
 	bindLogOpts(opt, opts...)
 
-	// If no internal logger is provided, create a no-op logger
-	if opt.internalLogger == nil {
-		opt.internalLogger = zap.NewNop()
+	switch {
+	case opt.internalLogger == nil:
+		// If no internal logger is provided, report problems to stderr, see WithInternalLoggerWriter
+		level := zapcore.WarnLevel
+		if opt.internalLoggerLevel != nil {
+			level = *opt.internalLoggerLevel
+		}
+
+		opt.internalLogger = newInternalLogger(opt.internalLoggerWriter, level)
+	case opt.internalLoggerLevel != nil:
+		opt.internalLogger = opt.internalLogger.WithOptions(zap.IncreaseLevel(*opt.internalLoggerLevel))
+	}
+
+	return opt
+}
+
+func newZapLoggerWithOpenSearch(opt *LogOpts) (*zap.Logger, CleanUp, error) {
+	if err := opt.validateLevelHooks(); err != nil {
+		return nil, nil, err
 	}
 
 	var cores []zapcore.Core
 
 	if opt.withConsole {
-		consoleEnc := genProdEncoder()
-		coreConsole := zapcore.NewCore(consoleEnc, zapcore.AddSync(os.Stdout), opt.level)
+		coreConsole := newConsoleCore(opt)
 		cores = append(cores, coreConsole)
 	}
 
+	if opt.syslog != nil {
+		cores = append(cores, newSyslogCore(opt))
+	}
+
+	cores = append(cores, opt.newExtraCores()...)
+
 	if opt.openSearchConfig == nil {
-		opt.internalLogger.Panic("OpenSearch config must be provided when OpenSearch logging is enabled")
+		return nil, nil, ErrOpenSearchConfigMissing
+	}
+
+	switch opt.openSearchRefresh {
+	case "", "true", "false", "wait_for":
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", ErrInvalidRefreshPolicy, opt.openSearchRefresh)
+	}
+
+	switch {
+	case opt.rolloverAlias != "":
+		if opt.openSearchIndex != "" || opt.openSearchErrorIndex != "" || opt.openSearchDataStream != "" {
+			return nil, nil, ErrRolloverWithIndex
+		}
+
+		if opt.rolloverConditions.empty() {
+			return nil, nil, ErrNoRolloverConditions
+		}
+	case opt.openSearchDataStream != "":
+		if opt.openSearchIndex != "" || opt.openSearchErrorIndex != "" {
+			return nil, nil, ErrDataStreamWithIndex
+		}
+	case opt.openSearchIndex == "":
+		return nil, nil, ErrOpenSearchIndexMissing
+	default:
+		if err := ValidateIndexFormat(opt.indexDateFormat); err != nil {
+			opt.internalLogger.Warn("Index names won't rotate", zap.Error(err))
+		}
+	}
+
+	// the outputs besides OpenSearch, synced by CleanUp
+	localCores := zapcore.NewTee(cores...)
+
+	opt.rolloverStop = make(chan struct{})
+	stopRollover := sync.OnceFunc(func() { close(opt.rolloverStop) })
+
+	var (
+		writers       func() openSearchWriters
+		waitReady     = func(context.Context) error { return nil }
+		stopReconnect = func() {}
+	)
+
+	switch {
+	case opt.openSearchAsyncInit:
+		async := newAsyncCore(opt)
+		cores = append(cores, opt.sample(async))
+		writers, waitReady = async.writers, async.wait
+	case opt.openSearchReconnect > 0 && pingOpenSearch(opt) != nil:
+		fallback := []zapcore.Core{newFileCore(opt)}
+		if !opt.withConsole {
+			fallback = append(fallback, newConsoleCore(opt))
+		}
+
+		reconnect := newReconnectCore(opt, zapcore.NewTee(fallback...))
+		localCores = zapcore.NewTee(append(fallback, localCores)...)
+		cores = append(cores, opt.sample(reconnect))
+		writers, stopReconnect = reconnect.writers, reconnect.stop
+	default:
+		openSearchCores, created, err := newOpenSearchCores(opt)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrCreateOpensearchCore, err)
+			if len(cores) > 0 {
+				return nil, nil, fmt.Errorf("console output is configured, but OpenSearch output is not available: %w", err)
+			}
+
+			return nil, nil, err
+		}
+
+		cores = append(cores, opt.sample(zapcore.NewTee(openSearchCores...)))
+		writers = func() openSearchWriters { return created }
+
+		if opt.handle != nil {
+			opt.handle.bind(created)
+		}
 	}
 
-	if opt.openSearchIndex == "" {
-		opt.internalLogger.Panic("OpenSearch index must be provided when OpenSearch logging is enabled")
+	if len(cores) == 0 {
+		return nil, nil, ErrNoLoggingOutputs
+	}
+
+	coreTee := opt.wrapCore(zapcore.NewTee(cores...))
+	logger := opt.newLogger(coreTee)
+
+	stopReport := func() {}
+
+	if opt.statsReportInterval > 0 {
+		stop, done := make(chan struct{}), make(chan struct{})
+		stopReport = sync.OnceFunc(func() {
+			close(stop)
+			<-done
+		})
+
+		currentStats := func() IndexerStats {
+			return writers().stats()
+		}
+
+		go func() {
+			defer close(done)
+			reportStats(logger, opt.statsReportInterval, currentStats, stop)
+		}()
+	}
+
+	// The writers stay open, see openSearchWriter.Flush, unless closed with
+	// WithCloseOnCleanUp; the background tasks stop.
+	flushFunc := func(ctx context.Context) error {
+		stopReport()
+		stopReconnect()
+		stopRollover()
+		opt.unregisterLevelHooks()
+
+		if err := waitReady(ctx); err != nil {
+			return fmt.Errorf("flush error: %w", err)
+		}
+
+		var errs []error
+
+		writers().flushDedup()
+
+		flush := writers().flush
+		if opt.closeOnCleanUp {
+			flush = writers().close
+		}
+
+		if err := flush(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("flush error: %w", err))
+		}
+
+		// the other outputs, e.g. WithBufferedIO buffers
+		if err := localCores.Sync(); err != nil {
+			errs = append(errs, fmt.Errorf("sync error: %w", err))
+		}
+
+		if err := opt.stopBuffers(); err != nil {
+			errs = append(errs, fmt.Errorf("sync error: %w", err))
+		}
+
+		for _, hook := range opt.cleanUpHooks {
+			if err := hook(ctx, writers().stats()); err != nil {
+				errs = append(errs, fmt.Errorf("clean up hook: %w", err))
+			}
+		}
+
+		return errors.Join(errs...)
 	}
 
-	var openSearchWriter *openSearchWriter
+	return logger, flushFunc, nil
+}
 
-	createOpenSearchCore := func() (zapcore.Core, error) {
-		indexNameGenerator := NewIndexGenerator(IndexConfig{
-			BaseIndexName: opt.openSearchIndex,
+// newOpenSearchCores creates the OpenSearch core and, with WithOpenSearchErrorIndex,
+// a second core shipping error and higher levels to the error index.
+func newOpenSearchCores(opt *LogOpts) ([]zapcore.Core, openSearchWriters, error) {
+	newIndexGenerator := func(index string) *IndexGenerator {
+		return NewIndexGenerator(IndexConfig{
+			BaseIndexName: index,
 			Format:        opt.indexDateFormat,
 			Location:      opt.timeLocation,
+			Clock:         opt.clock,
 		})
+	}
 
-		core, writer, err := newOpenSearchCore(
-			opt.openSearchConfig,
-			indexNameGenerator,
-			opt.level,
-			opt.internalLogger,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create OpenSearch core: %w", err)
+	var indexNameGenerator *IndexGenerator
+	if opt.writeTarget() == "" {
+		indexNameGenerator = newIndexGenerator(opt.openSearchIndex)
+	}
+
+	client, err := opt.openSearchClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := opt.ensureIndexTemplate(client); err != nil {
+		return nil, nil, err
+	}
+
+	if opt.rolloverAlias != "" {
+		if err := opt.ensureRolloverAlias(client); err != nil {
+			return nil, nil, err
 		}
 
-		openSearchWriter = writer
+		go runRollover(client, opt.rolloverAlias, opt.rolloverConditions, opt.internalLogger, opt.rolloverStop)
+	}
+
+	core, writer, err := newOpenSearchCore(opt, indexNameGenerator)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		return core, nil
+	if opt.openSearchErrorIndex == "" {
+		return []zapcore.Core{core}, openSearchWriters{writer}, nil
 	}
 
-	openSearchCore, err := createOpenSearchCore()
+	errorCore, errorWriter, err := newOpenSearchCore(opt, newIndexGenerator(opt.openSearchErrorIndex))
 	if err != nil {
-		opt.internalLogger.Panic("Failed to create OpenSearch core", zap.Error(err))
+		_ = writer.FlushWithContext(context.Background())
+		return nil, nil, fmt.Errorf("error index: %w", err)
 	}
 
-	cores = append(cores, openSearchCore)
+	isError := zap.LevelEnablerFunc(func(level zapcore.Level) bool {
+		return level >= zapcore.ErrorLevel
+	})
+	isNotError := zap.LevelEnablerFunc(func(level zapcore.Level) bool {
+		return level < zapcore.ErrorLevel
+	})
 
-	if len(cores) == 0 {
-		opt.internalLogger.Panic("No logging outputs specified")
+	cores := []zapcore.Core{newLevelFilterCore(core, isNotError), newLevelFilterCore(errorCore, isError)}
+
+	return cores, openSearchWriters{writer, errorWriter}, nil
+}
+
+// openSearchWriters are the writers of the OpenSearch cores of a logger.
+type openSearchWriters []*openSearchWriter
+
+// flush flushes all writers and keeps them open.
+func (ws openSearchWriters) flush(ctx context.Context) error {
+	var errs []error
+
+	for _, w := range ws {
+		if err := w.Flush(ctx); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	coreTee := zapcore.NewTee(cores...)
-	logger := zap.New(coreTee, zap.AddCaller())
+	return errors.Join(errs...)
+}
 
-	flushFunc := func(ctx context.Context) error {
-		if openSearchWriter != nil {
-			if err := openSearchWriter.FlushWithContext(ctx); err != nil {
-				return fmt.Errorf("flush error: %w", err)
-			}
+// close flushes and closes all writers.
+func (ws openSearchWriters) close(ctx context.Context) error {
+	var errs []error
 
-			newCore, err := createOpenSearchCore()
-			if err != nil {
-				return err
-			}
+	for _, w := range ws {
+		if err := w.FlushWithContext(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
-			for i, core := range cores {
-				if core == openSearchCore {
-					cores[i] = newCore
-					openSearchCore = newCore
+	return errors.Join(errs...)
+}
 
-					break
-				}
-			}
+// flushDedup ships the WithDedup summaries of the open windows.
+func (ws openSearchWriters) flushDedup() {
+	for _, w := range ws {
+		if w.dedup != nil {
+			w.dedup.flush()
 		}
+	}
+}
 
-		return nil
+// stats returns the counters of all writers.
+func (ws openSearchWriters) stats() IndexerStats {
+	var stats IndexerStats
+
+	counted := map[*compressionCounter]bool{}
+
+	for _, w := range ws {
+		stats = stats.merge(w.stats())
+
+		// writers sharing a client share its counter
+		if w.compression != nil && !counted[w.compression] {
+			counted[w.compression] = true
+			stats = stats.withCompression(w.compression)
+		}
 	}
 
-	return logger, flushFunc
+	return stats
 }
 
 // FlushLogsWithTimeout attempts to flush logs with a timeout.
@@ -162,203 +478,1149 @@ func FlushLogsWithTimeout(flushFunc CleanUp, timeout time.Duration, logger *zap.
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 
-		if err := flushFunc(ctx); err != nil {
-			logger.Error("Error during flush", zap.Error(err))
-			return
-		}
+		flushLogs(ctx, flushFunc, logger)
+	}
+}
+
+// FlushLogsWithContext is like FlushLogsWithTimeout, but derives the flush context
+// from parent, so the deadline of a global shutdown context is honored. Without
+// a shorter deadline on parent the flush is bounded to 30 seconds.
+func FlushLogsWithContext(flushFunc CleanUp, parent context.Context, logger *zap.Logger) func() {
+	return func() {
+		ctx, cancel := context.WithTimeout(parent, shutdownTimeout)
+		defer cancel()
+
+		flushLogs(ctx, flushFunc, logger)
+	}
+}
 
-		logger.Info("Logs flushed successfully")
+func flushLogs(ctx context.Context, flushFunc CleanUp, logger *zap.Logger) {
+	if err := flushFunc(ctx); err != nil {
+		logger.Error("Error during flush", zap.Error(err))
+		return
 	}
+
+	logger.Info("Logs flushed successfully")
 }
 
+// BulkIndexerFactory creates the bulk indexers of the OpenSearch cores, see
+// WithBulkIndexerFactory. opensearchutil.NewBulkIndexer is the default.
+type BulkIndexerFactory func(config opensearchutil.BulkIndexerConfig) (opensearchutil.BulkIndexer, error)
+
 type openSearchWriter struct {
+	// an interface, replaced in tests through WithBulkIndexerFactory
 	indexer       opensearchutil.BulkIndexer
 	client        *opensearch.Client
 	indexerConfig opensearchutil.BulkIndexerConfig
+	newIndexer    BulkIndexerFactory
 	mu            sync.Mutex
 	closed        bool
 	logger        *zap.Logger
 
+	// nil when shipping to target
 	indexNameGenerator *IndexGenerator
-
-	stopChan chan struct{}
+	// optional, receives the entries older than warmThreshold
+	warmIndexNameGenerator *IndexGenerator
+	warmThreshold          time.Duration
+	// optional, ships entries to the index of their tenant
+	tenants *tenantRouter
+	// the data stream or rollover alias
+	target string
+	// bulk action, "index" or "create"
+	action         string
+	versionFunc    func(entry []byte) int64
+	documentIDFunc func(entry map[string]interface{}) string
+	messageKey     string
+	timeKey        string
+	levelKey       string
+	nameKey        string
+	// optional, warns about entries with skewed timestamps
+	skew *skewDetector
+	// optional, suppresses repeated entries
+	dedup       *deduplicator
+	dedupFields []string
+	// nil disables fingerprints, an empty slice hashes the message only
+	fingerprintFields []string
+	// entries missing one of these are flagged, or dropped with dropIncomplete
+	requiredFields []string
+	dropIncomplete bool
+	// optional, masks sensitive values
+	redact func(entry map[string]interface{})
+	// decode every entry, see decodes
+	validate bool
+	// string fields longer than this are compressed, 0 disables it
+	compressThreshold int
+	// the keys of the encoder, never compressed
+	reservedKeys []string
+	// encoded entries larger than this are truncated or dropped, 0 disables it
+	maxDocSize    int
+	stacktraceKey string
+	// optional, receives entries that could not be shipped
+	fallback *fallbackWriter
+	// optional, retries items failing with a transient status
+	retry *retryPolicy
+	// optional, counts the outcome of items
+	metrics Metrics
+	// optional, called with the entries dropped by the rate and size limits
+	dropHook func(zapcore.Entry)
+	// set with compression, shared with the other writers of the client
+	compression *compressionCounter
+	// optional, shared with the other writers of the logger
+	rateLimit    *rateLimit
+	docRateLimit *rate.Limiter
+	// optional, shared with the other writers of the logger
+	breaker *circuitBreaker
+	// optional, signals successful bulk requests
+	notifier *flushNotifier
+	// flushes once no entry arrived for flushOnIdle, if set
+	flushOnIdle time.Duration
+	idleTimer   *time.Timer
+
+	// bounds the adds blocked on a full queue
+	writeTimeout time.Duration
+	clock        func() time.Time
+	// optional, bounds the entries pending in the bulk indexers
+	backpressure *backpressure
+	// the last ID given to an item handed to the bulk indexers, shared with
+	// the OnFlushStart hook
+	itemIDs *atomic.Uint64
+	// cancelled by FlushWithContext or the WithShutdownContext context, aborting
+	// the pending adds
+	stopCtx context.Context
+	stop    context.CancelFunc
+	dropped atomic.Uint64
+	retried atomic.Uint64
+
+	// indexers replaced by Flush that are still being closed
+	closing []opensearchutil.BulkIndexer
+	// counters of the indexers replaced by Flush
+	retired  IndexerStats
+	flushing sync.WaitGroup
 }
 
 func (w *openSearchWriter) Write(buffer []byte) (n int, err error) {
+	// shipping the summary of an entry evicted by WithDedup writes again, so
+	// it runs once w.mu is released
+	var evicted *dedupEntry
+	defer func() {
+		if evicted != nil {
+			w.dedup.summarize(evicted)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(w.stopCtx, w.writeTimeout)
+	defer cancel()
+
+	// the bulk indexer callbacks free up room, waiting for it under w.mu would
+	// hold up Flush and the other writes
+	capacityErr := w.backpressure.wait(ctx)
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	// failed entries written to the fallback file aren't dropped
+	var saved bool
+	defer func() {
+		if err != nil && !saved {
+			w.dropped.Add(1)
+		}
+	}()
+
 	if w.closed {
 		return 0, ErrWriterClosed
 	}
 
-	var logEntry map[string]interface{}
+	var (
+		logEntry     map[string]interface{}
+		encodedEntry []byte
+	)
 
-	err = json.Unmarshal(buffer, &logEntry)
-	if err != nil {
-		return 0, fmt.Errorf("failed to parse log entry: %w", err)
+	if !w.decodes() {
+		// zap reuses buffer once Write returns
+		encodedEntry = bytes.Clone(bytes.TrimSuffix(buffer, []byte{'\n'}))
+	} else {
+		err = json.Unmarshal(buffer, &logEntry)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse log entry: %w", err)
+		}
+
+		if w.redact != nil {
+			w.redact(logEntry)
+		}
+
+		if w.skew != nil {
+			w.skew.check(logEntry[w.timeKey])
+		}
+
+		if missing := missingFields(logEntry, w.requiredFields); len(missing) > 0 {
+			if w.dropIncomplete {
+				w.logger.Warn("Dropping log entry missing required fields",
+					zap.Strings("missing", missing),
+					zap.Any("message", logEntry[w.messageKey]))
+				w.dropped.Add(1)
+
+				return len(buffer), nil
+			}
+
+			logEntry[missingFieldsKey] = missing
+		}
+
+		if w.dedup != nil {
+			// summaries are never suppressed
+			var suppressed bool
+
+			_, summary := logEntry[repeatedKey]
+			if !summary {
+				suppressed, evicted = w.dedup.suppress(entryFingerprint(logEntry, w.messageKey, w.dedupFields), logEntry)
+			}
+
+			if suppressed {
+				return len(buffer), nil
+			}
+		}
+
+		if w.fingerprintFields != nil {
+			logEntry[fingerprintKey] = entryFingerprint(logEntry, w.messageKey, w.fingerprintFields)
+		}
+
+		if w.compressThreshold > 0 {
+			if err = compressLargeFields(logEntry, w.compressThreshold, w.reservedKeys); err != nil {
+				return 0, err
+			}
+		}
+
+		encodedEntry, err = json.Marshal(logEntry)
+		if err != nil {
+			return 0, fmt.Errorf("failed to re-encode log entry: %w", err)
+		}
 	}
 
-	encodedEntry, err := json.Marshal(logEntry)
-	if err != nil {
-		return 0, fmt.Errorf("failed to re-encode log entry: %w", err)
+	if w.maxDocSize > 0 && len(encodedEntry) > w.maxDocSize {
+		size := len(encodedEntry)
+
+		encodedEntry, err = w.truncateEntry(logEntry, encodedEntry)
+		if err != nil {
+			return 0, err
+		}
+
+		if encodedEntry == nil {
+			w.logger.Warn("Dropping log entry exceeding the OpenSearch document size limit",
+				zap.Int("size", size), zap.Int("max_size", w.maxDocSize))
+			w.drop(buffer)
+
+			return len(buffer), nil
+		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), writerCtxTimeout)
-	defer cancel()
+	if !w.breaker.allow() {
+		w.skipEntry(encodedEntry)
+		return len(buffer), nil
+	}
+
+	if w.docRateLimit != nil && !w.docRateLimit.Allow() {
+		w.logger.Debug("Dropping log entry exceeding the OpenSearch document rate limit")
+		w.drop(encodedEntry)
+
+		return len(buffer), nil
+	}
+
+	if !w.rateLimit.allow(ctx, len(encodedEntry)) {
+		w.logger.Debug("Dropping log entry exceeding the OpenSearch rate limit",
+			zap.Int("size", len(encodedEntry)))
+		w.drop(encodedEntry)
+
+		return len(buffer), nil
+	}
 
 	select {
-	case <-w.stopChan:
+	case <-w.stopCtx.Done():
+		if w.fallback != nil {
+			w.skipEntry(encodedEntry)
+			return len(buffer), nil
+		}
+
 		return 0, ErrWriterIsStopping
 	default:
-		err = w.indexer.Add(
-			ctx,
-			opensearchutil.BulkIndexerItem{
-				Action: "index",
-				Index:  w.indexNameGenerator.GetIndexName(),
-				Body:   bytes.NewReader(encodedEntry),
-			},
-		)
+		item := w.newBulkItem(encodedEntry)
+
+		switch {
+		case w.tenants != nil:
+			item.Index = w.tenantIndexName(logEntry)
+		case w.isWarm(logEntry):
+			item.Index = w.warmIndexNameGenerator.GetIndexName()
+		}
+
+		if w.documentIDFunc != nil {
+			item.DocumentID = w.documentIDFunc(logEntry)
+		}
+
+		err = capacityErr
+		if err == nil {
+			err = w.addItem(ctx, item)
+		}
+
 		if err != nil {
+			w.breaker.failure()
+			saved = w.failItem(item)
+
 			return 0, fmt.Errorf("failed to add document to bulk indexer: %w", err)
 		}
+
+		w.resetIdleTimer()
 	}
 
 	return len(buffer), nil
 }
 
-var (
-	ErrWriterClosed     = errors.New("writer already closed")
-	ErrWriterIsStopping = errors.New("writer is stopping")
-)
-
-// FlushWithContext flushes logs with context support
-func (w *openSearchWriter) FlushWithContext(ctx context.Context) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+// resetIdleTimer schedules the WithFlushOnIdle flush, pushing it back on every
+// entry. It must be called under w.mu.
+func (w *openSearchWriter) resetIdleTimer() {
+	if w.flushOnIdle <= 0 {
+		return
+	}
 
-	if w.closed {
-		return ErrWriterClosed
+	if w.idleTimer != nil {
+		w.idleTimer.Reset(w.flushOnIdle)
+		return
 	}
 
-	// Signal to stop accepting new writes
-	close(w.stopChan)
-	w.closed = true
+	w.idleTimer = time.AfterFunc(w.flushOnIdle, func() {
+		if err := w.Sync(); err != nil && !errors.Is(err, ErrWriterClosed) {
+			w.logger.Warn("Idle flush failed", zap.Error(err))
+		}
+	})
+}
 
-	stats := w.indexer.Stats()
-	w.logger.Info("Starting flush",
-		zap.Uint64("added", stats.NumAdded),
-		zap.Uint64("flushed", stats.NumFlushed),
-		zap.Uint64("failed", stats.NumFailed))
+// decodes reports whether Write decodes and re-encodes entries, because an
+// option inspects or changes their fields or WithOpenSearchValidateEntries is
+// set. Otherwise the encoded entry is shipped as is.
+func (w *openSearchWriter) decodes() bool {
+	return w.validate || w.redact != nil || w.skew != nil || w.dedup != nil || len(w.requiredFields) > 0 ||
+		w.fingerprintFields != nil || w.documentIDFunc != nil || w.warmIndexNameGenerator != nil || w.tenants != nil ||
+		w.compressThreshold > 0
+}
 
-	// Use provided context for closing
-	if err := w.indexer.Close(ctx); err != nil {
-		w.logger.Error("Error closing bulk indexer", zap.Error(err))
-		return fmt.Errorf("error closing bulk indexer: %w", err)
+// truncateEntry shortens the stack trace of an entry larger than maxDocSize, the
+// usual culprit, so the entry fits. logEntry is nil if the entry wasn't decoded.
+// It returns nil if the entry can't be made to fit.
+func (w *openSearchWriter) truncateEntry(logEntry map[string]interface{}, encodedEntry []byte) ([]byte, error) {
+	if logEntry == nil {
+		if err := json.Unmarshal(encodedEntry, &logEntry); err != nil {
+			return nil, fmt.Errorf("failed to parse log entry: %w", err)
+		}
 	}
 
-	finalStats := w.indexer.Stats()
-	w.logger.Info("Flush completed",
-		zap.Uint64("added", finalStats.NumAdded),
-		zap.Uint64("flushed", finalStats.NumFlushed),
-		zap.Uint64("failed", finalStats.NumFailed))
-
-	return nil
-}
+	stacktrace, ok := logEntry[w.stacktraceKey].(string)
+	if !ok {
+		return nil, nil
+	}
 
-func (w *openSearchWriter) Flush() error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+	// the encoded size of the stack trace, escaping included, that fits
+	budget := encodedLen(stacktrace) - (len(encodedEntry) - w.maxDocSize) - len(truncatedMarker)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second) //nolint:mnd
-	defer cancel()
+	keep := sort.Search(len(stacktrace)+1, func(i int) bool {
+		return encodedLen(strings.ToValidUTF8(stacktrace[:i], "")) > budget
+	}) - 1
+	if keep <= 0 {
+		return nil, nil
+	}
 
-	stats := w.indexer.Stats()
-	w.logger.Info("Flushing logs",
-		zap.Uint64("added", stats.NumAdded),
-		zap.Uint64("flushed", stats.NumFlushed),
-		zap.Uint64("failed", stats.NumFailed))
+	logEntry[w.stacktraceKey] = strings.ToValidUTF8(stacktrace[:keep], "") + truncatedMarker
 
-	err := w.indexer.Close(ctx)
+	encodedEntry, err := json.Marshal(logEntry)
 	if err != nil {
-		w.logger.Error("Error closing bulk indexer", zap.Error(err))
-		return fmt.Errorf("error closing bulk indexer: %w", err)
+		return nil, fmt.Errorf("failed to re-encode log entry: %w", err)
 	}
 
-	stats = w.indexer.Stats()
-	w.logger.Info("Flush completed",
-		zap.Uint64("added", stats.NumAdded),
-		zap.Uint64("flushed", stats.NumFlushed),
-		zap.Uint64("failed", stats.NumFailed))
+	if len(encodedEntry) > w.maxDocSize {
+		return nil, nil
+	}
 
-	return nil
+	return encodedEntry, nil
 }
 
-// newOpenSearchCore creates a new zapcore.Core that writes logs to OpenSearch.
-//
-// Parameters:
-//   - config: OpenSearch client configuration (*opensearch.Config)
-//   - index: Name of the OpenSearch index to write logs to
-//   - level: Minimum log level to process (zapcore.Level)
-//   - logger: Internal logger for reporting indexing errors
-//
-// Returns:
-//   - zapcore.Core: The configured logging core
-//   - *openSearchWriter: The underlying OpenSearch writer
-//   - error: Any error that occurred during setup
-//
-// BulkIndexer Configuration:
-//   - NumWorkers: 2 concurrent workers for processing log entries
-//   - FlushBytes: 256KB buffer size before forcing flush
-//   - FlushInterval: 10 seconds interval for automatic flushing
-//
-// The function initializes a bulk indexer for efficient log shipping to OpenSearch
-// and configures JSON encoding for the log entries. It uses worker pools and
-// buffering for optimized performance.
-func newOpenSearchCore(config *opensearch.Config, indexNameGenerator *IndexGenerator, level zapcore.Level, logger *zap.Logger) (zapcore.Core, *openSearchWriter, error) {
-	client, err := opensearch.NewClient(*config)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create OpenSearch client: %w", err)
-	}
+// encodedLen returns the length of s encoded as a JSON string.
+func encodedLen(s string) int {
+	encoded, _ := json.Marshal(s)
+	return len(encoded)
+}
 
-	indexerConfig := opensearchutil.BulkIndexerConfig{
-		Client:        client,
-		Index:         indexNameGenerator.GetIndexName(),
-		NumWorkers:    numberOfWorkers,
-		FlushBytes:    flushBytes,
-		FlushInterval: flushInterval,
-		OnError: func(ctx context.Context, err error) {
-			logger.Error("Bulk indexer error", zap.Error(err))
-		},
+// drop counts an entry dropped by the rate or size limits and reports it to the
+// drop hook.
+func (w *openSearchWriter) drop(body []byte) {
+	w.dropped.Add(1)
+
+	if w.dropHook == nil {
+		return
 	}
 
-	indexer, err := opensearchutil.NewBulkIndexer(indexerConfig)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create bulk indexer: %w", err)
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		w.logger.Debug("Failed to decode dropped log entry", zap.Error(err))
+		return
 	}
 
+	var ent zapcore.Entry
+
+	ent.Time, _ = entryTime(fields[w.timeKey])
+	ent.Message, _ = fields[w.messageKey].(string)
+	ent.LoggerName, _ = fields[w.nameKey].(string)
+
+	if level, ok := fields[w.levelKey].(string); ok {
+		_ = ent.Level.UnmarshalText([]byte(level))
+	}
+
+	w.dropHook(ent)
+}
+
+// shipSummary ships the summary of the repeats of entry suppressed by WithDedup.
+func (w *openSearchWriter) shipSummary(entry map[string]interface{}, repeated int) {
+	entry[w.messageKey] = fmt.Sprintf("%s (repeated %d times)", toString(entry[w.messageKey]), repeated)
+	entry[w.timeKey] = w.clock().Format(iso8601Layout)
+	entry[repeatedKey] = repeated
+
+	body, err := json.Marshal(entry)
+	if err == nil {
+		_, err = w.Write(body)
+	}
+
+	if err != nil && !errors.Is(err, ErrWriterClosed) {
+		w.logger.Warn("Failed to ship repeated entries summary", zap.Error(err), zap.Int("repeated", repeated))
+	}
+}
+
+// skipEntry handles an entry the circuit breaker kept from the bulk indexer.
+func (w *openSearchWriter) skipEntry(body []byte) {
+	if w.fallback == nil {
+		w.dropped.Add(1)
+		return
+	}
+
+	if err := w.fallback.store(body); err != nil {
+		w.logger.Error("Failed to write fallback entry", zap.Error(err))
+	}
+}
+
+// stats returns the counters of the writer, summed over all its bulk indexers.
+func (w *openSearchWriter) stats() IndexerStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats := w.retired.add(w.indexer.Stats())
+	for _, indexer := range w.closing {
+		stats = stats.add(indexer.Stats())
+	}
+
+	stats.Dropped = w.dropped.Load()
+	stats.Retried = w.retried.Load()
+
+	return stats
+}
+
+// indexName returns the index or data stream an entry of size bytes is written to.
+func (w *openSearchWriter) indexName(size int) string {
+	if w.indexNameGenerator == nil {
+		return w.target
+	}
+
+	return w.indexNameGenerator.indexNameFor(size)
+}
+
+// isWarm reports whether an entry is older than the WithWarmThreshold age.
+func (w *openSearchWriter) isWarm(entry map[string]interface{}) bool {
+	if w.warmIndexNameGenerator == nil {
+		return false
+	}
+
+	ts, ok := entryTime(entry[w.timeKey])
+
+	return ok && w.clock().Sub(ts) > w.warmThreshold
+}
+
+// tenantIndexName returns the index of the tenant of an entry, see WithTenantField.
+func (w *openSearchWriter) tenantIndexName(entry map[string]interface{}) string {
+	generator := w.indexNameGenerator
+	if w.isWarm(entry) {
+		generator = w.warmIndexNameGenerator
+	}
+
+	return generator.GetTenantIndexName(w.tenants.tenant(entry))
+}
+
+// newBulkItem builds the bulk indexer item for an encoded log entry.
+func (w *openSearchWriter) newBulkItem(body []byte) opensearchutil.BulkIndexerItem {
+	item := opensearchutil.BulkIndexerItem{
+		Action: w.action,
+		Index:  w.indexName(len(body)),
+		Body:   bytes.NewReader(body),
+	}
+
+	if w.versionFunc != nil {
+		version := w.versionFunc(body)
+		versionType := versionTypeExternal
+		item.Version = &version
+		item.VersionType = &versionType
+	}
+
+	w.watchItem(&item, body, 0)
+
+	return item
+}
+
+// watchItem sets the callbacks of an item handed to the bulk indexer after attempt
+// retries: failed items are retried, as configured by WithOpenSearchRetry, or
+// written to the fallback file, which forgets them once they are acknowledged.
+func (w *openSearchWriter) watchItem(item *opensearchutil.BulkIndexerItem, body []byte, attempt int) {
+	if w.fallback == nil && w.retry == nil && w.metrics == nil && w.notifier == nil && w.breaker == nil &&
+		w.backpressure == nil {
+		return
+	}
+
+	id := w.itemIDs.Add(1)
+	w.backpressure.track(id)
+
+	if w.fallback != nil {
+		if err := w.fallback.track(id, body); err != nil {
+			w.logger.Error("Failed to write fallback entry", zap.Error(err))
+		}
+	}
+
+	item.OnSuccess = func(ctx context.Context, _ opensearchutil.BulkIndexerItem, _ opensearchutil.BulkIndexerResponseItem) {
+		flushDelivered(ctx)
+		w.backpressure.settle(id)
+
+		if w.fallback != nil {
+			w.fallback.ack(id)
+		}
+
+		if w.metrics != nil {
+			w.metrics.IncFlushed()
+		}
+	}
+
+	item.OnFailure = func(ctx context.Context, failed opensearchutil.BulkIndexerItem, res opensearchutil.BulkIndexerResponseItem, err error) {
+		w.backpressure.settle(id)
+
+		if w.retry.retriable(res.Status, attempt) {
+			if w.fallback != nil {
+				w.fallback.ack(id)
+			}
+
+			w.retried.Add(1)
+			w.scheduleRetry(failed, body, attempt+1)
+
+			return
+		}
+
+		fields := []zap.Field{zap.Int("status", res.Status), zap.String("reason", res.Error.Reason), zap.Error(err)}
+
+		if w.fallback == nil {
+			w.logger.Warn("Bulk item failed", append(fields, zap.Int("retries", attempt))...)
+			return
+		}
+
+		w.logger.Warn("Bulk item failed, writing it to the fallback file", fields...)
+
+		if err := w.fallback.fail(id); err != nil {
+			w.logger.Error("Failed to write fallback entry", zap.Error(err))
+			return
+		}
+
+		fallbackSaved(ctx)
+	}
+}
+
+// createIndexer creates a bulk indexer. With WithMetrics, the entries it counts
+// as failed are reported from its stats, see failureReporter.
+func (w *openSearchWriter) createIndexer() (opensearchutil.BulkIndexer, error) {
+	if w.metrics == nil {
+		return w.newIndexer(w.indexerConfig)
+	}
+
+	failures := &failureReporter{metrics: w.metrics}
+
+	config := w.indexerConfig
+	config.OnFlushEnd = func(ctx context.Context) {
+		if w.indexerConfig.OnFlushEnd != nil {
+			w.indexerConfig.OnFlushEnd(ctx)
+		}
+
+		failures.report()
+	}
+
+	indexer, err := w.newIndexer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	failures.bind(indexer)
+
+	return &reportingIndexer{BulkIndexer: indexer, failures: failures}, nil
+}
+
+// addItem hands an item to the current bulk indexer, it must be called under w.mu.
+func (w *openSearchWriter) addItem(ctx context.Context, item opensearchutil.BulkIndexerItem) error {
+	if err := w.indexer.Add(ctx, item); err != nil {
+		return err
+	}
+
+	if w.metrics != nil {
+		w.metrics.IncAdded()
+	}
+
+	return nil
+}
+
+// scheduleRetry hands a failed item to the current bulk indexer again once the
+// backoff for attempt has passed. Items failing after the writer was closed are
+// given up.
+func (w *openSearchWriter) scheduleRetry(item opensearchutil.BulkIndexerItem, body []byte, attempt int) {
+	time.AfterFunc(w.retry.backoff(attempt), func() {
+		item.Body = bytes.NewReader(body)
+		w.watchItem(&item, body, attempt)
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		if w.closed {
+			w.failItem(item)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(w.stopCtx, w.writeTimeout)
+		defer cancel()
+
+		if err := w.addItem(ctx, item); err != nil {
+			w.failItem(item)
+		}
+	})
+}
+
+// failItem reports an item the bulk indexer refused, routing it to the fallback
+// file, and returns whether it was written there.
+func (w *openSearchWriter) failItem(item opensearchutil.BulkIndexerItem) bool {
+	// unlike the items the bulk indexer rejects, they aren't in its stats
+	if w.metrics != nil {
+		w.metrics.IncFailed()
+	}
+
+	if item.OnFailure == nil {
+		return false
+	}
+
+	var saved bool
+
+	ctx := context.WithValue(context.Background(), fallbackSavedKey{}, &saved)
+	item.OnFailure(ctx, item, opensearchutil.BulkIndexerResponseItem{}, nil)
+
+	return saved
+}
+
+var (
+	ErrWriterClosed     = errors.New("writer already closed")
+	ErrWriterIsStopping = errors.New("writer is stopping")
+	ErrIndexerSaturated = errors.New("too many entries pending in the bulk indexer")
+)
+
+// Flush sends the entries buffered by the bulk indexer to OpenSearch and keeps
+// the writer open ("flush and continue"): a new bulk indexer takes over while
+// the current one is closed, so logging isn't blocked and services can flush on
+// a timer. FlushWithContext flushes and closes the writer for good ("flush and
+// close"), e.g. on shutdown. It gives up once ctx is done, also while a write
+// blocked on a full queue holds the writer.
+func (w *openSearchWriter) Flush(ctx context.Context) error {
+	if err := w.lock(ctx); err != nil {
+		return err
+	}
+
+	if w.closed {
+		w.mu.Unlock()
+		return ErrWriterClosed
+	}
+
+	indexer, err := w.createIndexer()
+	if err != nil {
+		w.mu.Unlock()
+		return fmt.Errorf("failed to create bulk indexer: %w", err)
+	}
+
+	previous := w.indexer
+	w.indexer = indexer
+	w.closing = append(w.closing, previous)
+
+	// entries tracked up to here belong to the previous indexer
+	lastID := w.itemIDs.Load()
+
+	w.flushing.Add(1)
+	w.mu.Unlock()
+
+	defer w.flushing.Done()
+
+	closeErr := runWithContext(ctx, func() error {
+		err := previous.Close(ctx)
+		w.retire(previous)
+
+		return err
+	})
+
+	w.backpressure.settleUpTo(lastID)
+
+	if w.fallback != nil {
+		if err := w.fallback.flush(lastID); err != nil {
+			w.logger.Error("Error flushing fallback file", zap.Error(err))
+		}
+	}
+
+	if closeErr != nil {
+		w.logger.Error("Error closing bulk indexer", zap.Error(closeErr))
+		return fmt.Errorf("error closing bulk indexer: %w", closeErr)
+	}
+
+	return nil
+}
+
+// lock takes w.mu, or returns ctx.Err() once ctx is done.
+func (w *openSearchWriter) lock(ctx context.Context) error {
+	if w.mu.TryLock() {
+		return nil
+	}
+
+	locked := make(chan struct{})
+
+	go func() {
+		w.mu.Lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		// release the lock once the goroutine got it
+		go func() {
+			<-locked
+			w.mu.Unlock()
+		}()
+
+		return ctx.Err()
+	}
+}
+
+// Sync implements zapcore.WriteSyncer, so logger.Sync() flushes and continues.
+func (w *openSearchWriter) Sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+
+	return w.Flush(ctx)
+}
+
+// retire moves the counters of an indexer closed by Flush to w.retired.
+func (w *openSearchWriter) retire(indexer opensearchutil.BulkIndexer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, closing := range w.closing {
+		if closing == indexer {
+			w.closing = append(w.closing[:i], w.closing[i+1:]...)
+			break
+		}
+	}
+
+	w.retired = w.retired.add(indexer.Stats())
+}
+
+// FlushWithContext flushes logs with context support and closes the writer, see Flush.
+func (w *openSearchWriter) FlushWithContext(ctx context.Context) error {
+	if w.dedup != nil {
+		w.dedup.flush()
+	}
+
+	// Abort the adds blocked on a full queue, which hold the lock
+	w.stop()
+
+	if err := w.lock(ctx); err != nil {
+		return err
+	}
+
+	if w.closed {
+		w.mu.Unlock()
+		return ErrWriterClosed
+	}
+
+	w.closed = true
+
+	if w.idleTimer != nil {
+		w.idleTimer.Stop()
+	}
+
+	w.mu.Unlock()
+
+	// let running flushes finish with the fallback file
+	closeErr := runWithContext(ctx, func() error {
+		w.flushing.Wait()
+		return nil
+	})
+
+	w.logger.Info("Starting flush", w.stats().fields()...)
+
+	// Use provided context for closing
+	if closeErr == nil {
+		closeErr = runWithContext(ctx, func() error { return w.indexer.Close(ctx) })
+	}
+
+	if w.fallback != nil {
+		if err := w.fallback.flush(math.MaxUint64); err != nil {
+			w.logger.Error("Error flushing fallback file", zap.Error(err))
+		}
+	}
+
+	if closeErr != nil {
+		w.logger.Error("Error closing bulk indexer", zap.Error(closeErr))
+		return fmt.Errorf("error closing bulk indexer: %w", closeErr)
+	}
+
+	w.logger.Info("Flush completed", w.stats().fields()...)
+
+	return nil
+}
+
+// newOpenSearchCore creates a new zapcore.Core that writes logs to OpenSearch.
+//
+// Parameters:
+//   - opt: Logger options carrying the OpenSearch client configuration, the minimum
+//     log level and the internal logger used for reporting indexing errors
+//   - indexNameGenerator: Generator for the (time based) index names logs are written to,
+//     nil when writing to the data stream set by WithOpenSearchDataStream
+//
+// Returns:
+//   - zapcore.Core: The configured logging core
+//   - *openSearchWriter: The underlying OpenSearch writer
+//   - error: Any error that occurred during setup
+//
+// BulkIndexer Configuration:
+//   - NumWorkers: 2 concurrent workers for processing log entries, unless configured
+//   - FlushBytes: 256KB buffer size before forcing flush, unless configured
+//   - FlushInterval: 10 seconds interval for automatic flushing, unless configured
+//
+// The function initializes a bulk indexer for efficient log shipping to OpenSearch
+// and configures JSON encoding for the log entries. It uses worker pools and
+// buffering for optimized performance.
+func newOpenSearchCore(opt *LogOpts, indexNameGenerator *IndexGenerator) (zapcore.Core, *openSearchWriter, error) {
+	logger := opt.internalLogger
+
+	client, err := opt.openSearchClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var notifier *flushNotifier
+	if opt.flushNotify != nil {
+		notifier = &flushNotifier{ch: opt.flushNotify}
+	}
+
+	breaker := opt.circuitBreaker()
+
+	indexerConfig := opensearchutil.BulkIndexerConfig{
+		Client: client,
+		// Dated index names are set per item, so entries follow the date even
+		// though Flush reuses this config; a default here would only go stale.
+		Index:         opt.writeTarget(),
+		Pipeline:      opt.openSearchPipeline,
+		Refresh:       opt.openSearchRefresh,
+		NumWorkers:    opt.bulkWorkers(),
+		FlushBytes:    opt.bulkFlushBytes(),
+		FlushInterval: opt.bulkFlushInterval(),
+		OnError: func(ctx context.Context, err error) {
+			logger.Error("Bulk indexer error", zap.Error(err))
+			flushFailed(ctx)
+		},
+	}
+
+	var fallback *fallbackWriter
+	if opt.openSearchFallback != "" {
+		fallback = newFallbackWriter(opt.openSearchFallback)
+	}
+
+	backpressure := newBackpressure(opt.openSearchMaxPending)
+	itemIDs := new(atomic.Uint64)
+
+	if notifier != nil || breaker != nil || fallback != nil || backpressure != nil {
+		indexerConfig.OnFlushStart = func(ctx context.Context) context.Context {
+			ctx = startFlush(ctx)
+			flushStateFrom(ctx).lastTracked = itemIDs.Load()
+
+			return ctx
+		}
+		indexerConfig.OnFlushEnd = func(ctx context.Context) {
+			if state := flushStateFrom(ctx); state != nil {
+				notifier.end(state)
+				breaker.record(state)
+
+				if state.failed {
+					backpressure.settleUpTo(state.lastTracked)
+				}
+
+				if err := fallback.endFlush(state); err != nil {
+					logger.Error("Failed to write fallback entries", zap.Error(err))
+				}
+			}
+		}
+	}
+
+	newIndexer := opt.bulkIndexerFactory
+	if newIndexer == nil {
+		newIndexer = opensearchutil.NewBulkIndexer
+	}
+
+	encoderConfig := opt.openSearchEncoderConfig()
+	stopCtx, stop := context.WithCancel(opt.shutdownContext())
+
 	writer := &openSearchWriter{
-		indexer:       indexer,
 		client:        client,
 		indexerConfig: indexerConfig,
+		newIndexer:    newIndexer,
 		logger:        logger,
 		// dynamically generate index name
 		indexNameGenerator: indexNameGenerator,
-		stopChan:           make(chan struct{}),
+		target:             opt.writeTarget(),
+		action:             opt.bulkAction(),
+		versionFunc:        opt.openSearchVersionFunc,
+		documentIDFunc:     opt.openSearchDocumentIDFunc,
+		messageKey:         encoderConfig.MessageKey,
+		timeKey:            encoderConfig.TimeKey,
+		levelKey:           encoderConfig.LevelKey,
+		nameKey:            encoderConfig.NameKey,
+		fingerprintFields:  opt.fingerprintFields,
+		requiredFields:     opt.requiredFields,
+		dropIncomplete:     opt.dropIncomplete,
+		redact:             opt.redactor(),
+		validate:           opt.openSearchValidate,
+		compressThreshold:  opt.compressFieldsThreshold,
+		maxDocSize:         opt.openSearchMaxDocSize,
+		stacktraceKey:      encoderConfig.StacktraceKey,
+		metrics:            opt.metrics,
+		dropHook:           opt.dropHook,
+		compression:        opt.compression,
+		rateLimit:          opt.rateLimit(),
+		docRateLimit:       opt.docRateLimit(),
+		breaker:            breaker,
+		notifier:           notifier,
+		flushOnIdle:        opt.flushOnIdle,
+		writeTimeout:       opt.entryWriteTimeout(),
+		backpressure:       backpressure,
+		itemIDs:            itemIDs,
+		clock:              opt.now(),
+		stopCtx:            stopCtx,
+		stop:               stop,
+		fallback:           fallback,
+	}
+
+	if opt.compressFieldsThreshold > 0 {
+		writer.reservedKeys = []string{
+			encoderConfig.MessageKey, encoderConfig.LevelKey, encoderConfig.TimeKey,
+			encoderConfig.NameKey, encoderConfig.CallerKey, encoderConfig.StacktraceKey,
+		}
+	}
+
+	if opt.dedupWindow > 0 {
+		writer.dedup = newDeduplicator(opt.dedupWindow, writer.shipSummary)
+		// the level tells apart entries with the same message
+		writer.dedupFields = append([]string{encoderConfig.LevelKey}, opt.dedupFields...)
+	}
+
+	if opt.timestampSkewTolerance > 0 {
+		writer.skew = &skewDetector{tolerance: opt.timestampSkewTolerance, logger: logger, clock: writer.clock}
+	}
+
+	if opt.openSearchMaxIndexBytes > 0 && indexNameGenerator != nil {
+		indexNameGenerator.withMaxBytes(opt.openSearchMaxIndexBytes)
 	}
 
-	encoderConfig := zap.NewProductionEncoderConfig()
-	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	if opt.warmThreshold > 0 && indexNameGenerator != nil {
+		writer.warmIndexNameGenerator = indexNameGenerator.withSuffix(warmIndexSuffix)
+		writer.warmThreshold = opt.warmThreshold
+	}
+
+	if opt.tenantField != "" && indexNameGenerator != nil {
+		writer.tenants = newTenantRouter(opt.tenantField, opt.tenantDefault, opt.maxTenants, logger)
+	}
+
+	if opt.openSearchMaxRetries > 0 {
+		writer.retry = &retryPolicy{
+			maxRetries:     opt.openSearchMaxRetries,
+			initialBackoff: opt.openSearchRetryBackoff,
+			jitter:         opt.openSearchRetryJitter,
+		}
+	}
+
+	writer.indexer, err = writer.createIndexer()
+	if err != nil {
+		stop()
+		return nil, nil, fmt.Errorf("failed to create bulk indexer: %w", err)
+	}
 
 	openSearchEncoder := zapcore.NewJSONEncoder(encoderConfig)
 
 	return zapcore.NewCore(
 		openSearchEncoder,
 		zapcore.AddSync(writer),
-		level,
+		opt.levelEnabler(),
 	), writer, nil
 }
 
+// runWithContext runs fn and returns its error, or ctx.Err() as soon as ctx is
+// done, leaving fn running in the background. The bulk indexer only checks its
+// context before waiting for its workers, which may be stuck in a request.
+func runWithContext(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeTarget returns the data stream or rollover alias entries are shipped to,
+// empty when shipping to dated indices.
+func (o *LogOpts) writeTarget() string {
+	if o.rolloverAlias != "" {
+		return o.rolloverAlias
+	}
+
+	return o.openSearchDataStream
+}
+
+// bulkAction returns the bulk action used to index log entries.
+func (o *LogOpts) bulkAction() string {
+	// data streams only accept create
+	if o.appendOnly || o.openSearchDataStream != "" {
+		return bulkActionCreate
+	}
+
+	return bulkActionIndex
+}
+
+// bulkWorkers returns the number of bulk indexer workers, falling back to the
+// default for non-positive values and clamping to a sane upper bound.
+func (o *LogOpts) bulkWorkers() int {
+	if o.openSearchWorkers <= 0 {
+		return numberOfWorkers
+	}
+
+	if limit := runtime.NumCPU() * maxWorkersPerCPU; o.openSearchWorkers > limit {
+		o.internalLogger.Warn("Too many OpenSearch workers requested, clamping",
+			zap.Int("requested", o.openSearchWorkers),
+			zap.Int("limit", limit))
+
+		return limit
+	}
+
+	return o.openSearchWorkers
+}
+
+func (o *LogOpts) bulkFlushBytes() int {
+	if o.openSearchFlushBytes <= 0 {
+		return flushBytes
+	}
+
+	return o.openSearchFlushBytes
+}
+
+func (o *LogOpts) bulkFlushInterval() time.Duration {
+	if o.openSearchFlushInterval <= 0 {
+		return flushInterval
+	}
+
+	return o.openSearchFlushInterval
+}
+
+func (o *LogOpts) entryWriteTimeout() time.Duration {
+	if o.writeTimeout <= 0 {
+		return writerCtxTimeout
+	}
+
+	return o.writeTimeout
+}
+
+// openSearchEncoderConfig returns the encoder config of the OpenSearch documents,
+// with the keys set with WithMessageKey, WithLevelKey, etc.
+func (o *LogOpts) openSearchEncoderConfig() zapcore.EncoderConfig {
+	config := genJSONEncoderConfig()
+
+	if o.messageKey != "" {
+		config.MessageKey = o.messageKey
+	}
+
+	if o.levelKey != "" {
+		config.LevelKey = o.levelKey
+	}
+
+	if o.timeKey != "" {
+		config.TimeKey = o.timeKey
+	}
+
+	if o.nameKey != "" {
+		config.NameKey = o.nameKey
+	}
+
+	if o.callerKey != "" {
+		config.CallerKey = o.callerKey
+	}
+
+	return config
+}
+
+func (o *LogOpts) now() func() time.Time {
+	if o.clock == nil {
+		return time.Now
+	}
+
+	return o.clock
+}
+
+func (o *LogOpts) shutdownContext() context.Context {
+	if o.shutdownCtx == nil {
+		return context.Background()
+	}
+
+	return o.shutdownCtx
+}
+
+// pingOpenSearch checks that the configured cluster answers requests.
+func pingOpenSearch(opt *LogOpts) error {
+	client, err := opt.openSearchClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writerCtxTimeout)
+	defer cancel()
+
+	res, err := client.Info(client.Info.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrOpenSearchUnreachable, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("%w: %s", ErrOpenSearchUnreachable, res.Status())
+	}
+
+	return nil
+}
+
+// healthRanks orders the OpenSearch cluster health statuses.
+var healthRanks = map[string]int{"red": 1, "yellow": 2, "green": 3}
+
+// IsOpenSearchReady reports whether a GET of url answers 200 within timeout. The
+// request isn't authenticated, see IsOpenSearchReadyWithConfig for secured
+// clusters.
 func IsOpenSearchReady(url string, timeout time.Duration, insecure bool) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
@@ -381,3 +1643,53 @@ func IsOpenSearchReady(url string, timeout time.Duration, insecure bool) bool {
 
 	return resp.StatusCode == http.StatusOK
 }
+
+// IsOpenSearchReadyWithConfig reports whether the cluster configured by cfg
+// answers within timeout, authenticating and connecting like a client created
+// from cfg. With an empty status, it requests the root endpoint. Otherwise, it
+// waits for the cluster health to reach status ("green", "yellow" or "red")
+// through /_cluster/health?wait_for_status=status.
+func IsOpenSearchReadyWithConfig(cfg opensearch.Config, timeout time.Duration, status string) bool {
+	if _, ok := healthRanks[status]; !ok && status != "" {
+		return false
+	}
+
+	client, err := opensearch.NewClient(cfg)
+	if err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if status == "" {
+		res, err := client.Info(client.Info.WithContext(ctx))
+		if err != nil {
+			return false
+		}
+		defer res.Body.Close()
+
+		return res.StatusCode == http.StatusOK
+	}
+
+	health := client.Cluster.Health
+	res, err := health(health.WithContext(ctx), health.WithWaitForStatus(status), health.WithTimeout(timeout))
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return false
+	}
+
+	var body struct {
+		Status string `json:"status"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return false
+	}
+
+	return healthRanks[body.Status] >= healthRanks[status]
+}
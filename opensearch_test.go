@@ -0,0 +1,2637 @@
+package zlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeOpenSearch is a minimal in-process stand-in for an OpenSearch cluster. It
+// answers the client's product check and records the documents sent to the
+// bulk API, so the write path can be tested without a live cluster.
+type fakeOpenSearch struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	docs []fakeDoc
+	// itemStatus decides the status of each bulk item, 201 when nil
+	itemStatus func(doc fakeDoc) int
+	// bulkStatus fails whole bulk requests when set
+	bulkStatus int
+	// bulkBlock holds bulk requests until closed when set
+	bulkBlock chan struct{}
+	// templates holds the index templates by name
+	templates map[string]map[string]interface{}
+	// templatePuts counts the index template updates
+	templatePuts int
+	// down fails every request with 503 when set
+	down bool
+	// aliases maps the rollover aliases to their write index
+	aliases map[string]string
+}
+
+type fakeDoc struct {
+	Action string
+	Meta   map[string]interface{}
+	Source map[string]interface{}
+	// the pipeline and refresh parameters of the bulk request
+	Pipeline string
+	Refresh  string
+}
+
+func (d fakeDoc) Index() string {
+	return toString(d.Meta["_index"])
+}
+
+func (d fakeDoc) ID() string {
+	return toString(d.Meta["_id"])
+}
+
+func newFakeOpenSearch(t testing.TB) *fakeOpenSearch {
+	t.Helper()
+
+	fake := &fakeOpenSearch{}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	t.Cleanup(fake.Close)
+
+	return fake
+}
+
+func (f *fakeOpenSearch) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	f.mu.Lock()
+	down := f.down
+	f.mu.Unlock()
+
+	if down {
+		http.Error(w, `{"error":"unavailable"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/_bulk") {
+		f.serveBulk(w, r)
+		return
+	}
+
+	if name, ok := strings.CutPrefix(r.URL.Path, "/_index_template/"); ok {
+		f.serveIndexTemplate(w, r, name)
+		return
+	}
+
+	if alias, ok := strings.CutPrefix(r.URL.Path, "/_alias/"); ok {
+		f.serveAlias(w, alias)
+		return
+	}
+
+	if alias, ok := strings.CutSuffix(r.URL.Path, "/_rollover"); ok {
+		f.serveRollover(w, r, strings.TrimPrefix(alias, "/"))
+		return
+	}
+
+	if r.Method == http.MethodPut && strings.Count(r.URL.Path, "/") == 1 {
+		f.serveCreateIndex(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+		return
+	}
+
+	_, _ = w.Write([]byte(`{"version":{"number":"2.11.0","distribution":"opensearch"}}`))
+}
+
+func (f *fakeOpenSearch) serveIndexTemplate(w http.ResponseWriter, r *http.Request, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodHead:
+		if _, ok := f.templates[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case http.MethodPut:
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if f.templates == nil {
+			f.templates = map[string]map[string]interface{}{}
+		}
+
+		f.templates[name] = body
+		f.templatePuts++
+		_, _ = w.Write([]byte(`{"acknowledged":true}`))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeOpenSearch) serveAlias(w http.ResponseWriter, alias string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.aliases[alias]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeOpenSearch) serveCreateIndex(w http.ResponseWriter, r *http.Request, index string) {
+	var body struct {
+		Aliases map[string]struct {
+			IsWriteIndex bool `json:"is_write_index"`
+		} `json:"aliases"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for alias, config := range body.Aliases {
+		if config.IsWriteIndex {
+			if f.aliases == nil {
+				f.aliases = map[string]string{}
+			}
+
+			f.aliases[alias] = index
+		}
+	}
+
+	_, _ = w.Write([]byte(`{"acknowledged":true,"index":"` + index + `"}`))
+}
+
+// serveRollover rolls the write index of alias over once it holds max_docs
+// documents, the only condition the fake supports.
+func (f *fakeOpenSearch) serveRollover(w http.ResponseWriter, r *http.Request, alias string) {
+	var body struct {
+		Conditions struct {
+			MaxDocs int `json:"max_docs"`
+		} `json:"conditions"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current, ok := f.aliases[alias]
+	if !ok {
+		http.Error(w, `{"error":"alias not found"}`, http.StatusNotFound)
+		return
+	}
+
+	count := 0
+
+	for _, doc := range f.docs {
+		if doc.Index() == current {
+			count++
+		}
+	}
+
+	next := current
+	rolledOver := body.Conditions.MaxDocs > 0 && count >= body.Conditions.MaxDocs
+
+	if rolledOver {
+		base, generation, _ := strings.Cut(strings.TrimPrefix(current, alias), "-")
+		n, _ := strconv.Atoi(generation)
+		next = fmt.Sprintf("%s%s-%06d", alias, base, n+1)
+		f.aliases[alias] = next
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"old_index": current, "new_index": next, "rolled_over": rolledOver,
+	})
+}
+
+// Template returns the index template stored under name and the number of
+// template updates so far.
+func (f *fakeOpenSearch) Template(name string) (map[string]interface{}, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.templates[name], f.templatePuts
+}
+
+func (f *fakeOpenSearch) serveBulk(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	bulkStatus, bulkBlock := f.bulkStatus, f.bulkBlock
+	f.mu.Unlock()
+
+	if bulkBlock != nil {
+		select {
+		case <-bulkBlock:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if bulkStatus != 0 {
+		http.Error(w, `{"error":"fake failure"}`, bulkStatus)
+		return
+	}
+
+	var body io.Reader = r.Body
+
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		body = zr
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	var (
+		items     []map[string]interface{}
+		hasErrors bool
+	)
+
+	for scanner.Scan() {
+		var action map[string]map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		doc := fakeDoc{Pipeline: r.URL.Query().Get("pipeline"), Refresh: r.URL.Query().Get("refresh")}
+		for name, meta := range action {
+			doc.Action, doc.Meta = name, meta
+		}
+
+		if scanner.Scan() {
+			_ = json.Unmarshal(scanner.Bytes(), &doc.Source)
+		}
+
+		f.mu.Lock()
+		if index, ok := f.aliases[doc.Index()]; ok {
+			doc.Meta["_index"] = index
+		}
+
+		status := http.StatusCreated
+		if f.itemStatus != nil {
+			status = f.itemStatus(doc)
+		}
+
+		if doc.Action == bulkActionCreate && f.hasDoc(doc.Index(), doc.ID()) {
+			status = http.StatusConflict
+		}
+
+		result := map[string]interface{}{"_index": doc.Index(), "status": status}
+		if status < http.StatusMultipleChoices {
+			f.docs = append(f.docs, doc)
+		} else {
+			hasErrors = true
+			result["error"] = map[string]interface{}{"type": "fake_exception", "reason": "rejected by fake"}
+		}
+		f.mu.Unlock()
+
+		items = append(items, map[string]interface{}{doc.Action: result})
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"took": 1, "errors": hasErrors, "items": items})
+}
+
+// hasDoc reports whether a document with the given ID was indexed, f.mu must be held.
+func (f *fakeOpenSearch) hasDoc(index, id string) bool {
+	if id == "" {
+		return false
+	}
+
+	for _, doc := range f.docs {
+		if doc.Index() == index && doc.ID() == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Docs returns the documents indexed so far.
+func (f *fakeOpenSearch) Docs() []fakeDoc {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]fakeDoc{}, f.docs...)
+}
+
+func (f *fakeOpenSearch) SetItemStatus(fn func(doc fakeDoc) int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.itemStatus = fn
+}
+
+func (f *fakeOpenSearch) SetBulkStatus(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.bulkStatus = status
+}
+
+func (f *fakeOpenSearch) SetDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.down = down
+}
+
+// BlockBulk holds bulk requests until the end of the test, simulating a hung cluster.
+func (f *fakeOpenSearch) BlockBulk(t *testing.T) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	block := make(chan struct{})
+	f.bulkBlock = block
+
+	// runs before the server is closed, which waits for the held requests
+	t.Cleanup(func() { close(block) })
+}
+
+func (f *fakeOpenSearch) Config() opensearch.Config {
+	return opensearch.Config{Addresses: []string{f.URL}}
+}
+
+// newWriter builds an OpenSearch core shipping to the fake cluster and returns its writer.
+func (f *fakeOpenSearch) newWriter(t testing.TB, opts ...LogOptFunc) *openSearchWriter {
+	t.Helper()
+
+	config := f.Config()
+	opt := &LogOpts{
+		level:            zapcore.InfoLevel,
+		openSearchConfig: &config,
+		internalLogger:   zap.NewNop(),
+	}
+	bindLogOpts(opt, opts...)
+
+	_, writer, err := newOpenSearchCore(opt, NewIndexGenerator(IndexConfig{BaseIndexName: "zlog-test", Clock: opt.clock}))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = writer.FlushWithContext(context.Background())
+	})
+
+	return writer
+}
+
+// newTestOpenSearchWriter builds a writer shipping to a throwaway fake cluster.
+func newTestOpenSearchWriter(t *testing.T, opts ...LogOptFunc) *openSearchWriter {
+	t.Helper()
+
+	return newFakeOpenSearch(t).newWriter(t, opts...)
+}
+
+func TestOpenSearchVersionFunc(t *testing.T) {
+	body := []byte(`{"msg":"hello","seq":42}`)
+
+	writer := newTestOpenSearchWriter(t)
+	item := writer.newBulkItem(body)
+	assert.Nil(t, item.Version)
+	assert.Nil(t, item.VersionType)
+
+	writer = newTestOpenSearchWriter(t, WithOpenSearchVersionFunc(func(entry []byte) int64 {
+		assert.Equal(t, body, entry)
+		return 42
+	}))
+	item = writer.newBulkItem(body)
+	require.NotNil(t, item.Version)
+	require.NotNil(t, item.VersionType)
+	assert.Equal(t, int64(42), *item.Version)
+	assert.Equal(t, "external", *item.VersionType)
+}
+
+func TestNewZapLoggerWithOpenSearchErrors(t *testing.T) {
+	config := DefaultOpenSearchConfig(_testOpensearchURL, _testIsInsecure)
+	badConfig := DefaultOpenSearchConfig("://not-a-url", _testIsInsecure)
+
+	tests := []struct {
+		name    string
+		opts    []LogOptFunc
+		wantErr error
+		console bool
+	}{
+		{
+			name:    "missing config",
+			opts:    []LogOptFunc{WithOpenSearchIndex("zlog-test", "")},
+			wantErr: ErrOpenSearchConfigMissing,
+		},
+		{
+			name:    "missing index",
+			opts:    []LogOptFunc{WithOpenSearchConfig(&config)},
+			wantErr: ErrOpenSearchIndexMissing,
+		},
+		{
+			name:    "data stream with index",
+			opts:    []LogOptFunc{WithOpenSearchConfig(&config), WithOpenSearchDataStream("logs-app"), WithOpenSearchIndex("zlog-test", "")},
+			wantErr: ErrDataStreamWithIndex,
+		},
+		{
+			name:    "invalid address",
+			opts:    []LogOptFunc{WithOpenSearchConfig(&badConfig), WithOpenSearchIndex("zlog-test", "")},
+			wantErr: ErrCreateOpensearchCore,
+		},
+		{
+			name:    "invalid address with console",
+			opts:    []LogOptFunc{WithOpenSearchConfig(&badConfig), WithOpenSearchIndex("zlog-test", ""), WithConsole(true)},
+			wantErr: ErrCreateOpensearchCore,
+			console: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, flushFunc, err := NewZapLoggerWithOpenSearch(tt.opts...)
+			require.ErrorIs(t, err, tt.wantErr)
+			assert.Nil(t, logger)
+			assert.Nil(t, flushFunc)
+			assert.Equal(t, tt.console, strings.Contains(err.Error(), "console output is configured"))
+
+			assert.PanicsWithValue(t, err.Error(), func() {
+				MustNewZapLoggerWithOpenSearch(tt.opts...)
+			})
+		})
+	}
+}
+
+func TestNewZapLoggerWithOpenSearch(t *testing.T) {
+	config := DefaultOpenSearchConfig(_testOpensearchURL, _testIsInsecure)
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", string(DateFormatDot)),
+	)
+	require.NoError(t, err)
+	require.NotNil(t, logger)
+	assert.NoError(t, flushFunc(context.Background()))
+}
+
+func TestOpenSearchWorkers(t *testing.T) {
+	limit := runtime.NumCPU() * maxWorkersPerCPU
+
+	tests := []struct {
+		name    string
+		workers int
+		want    int
+		clamped bool
+	}{
+		{name: "default", workers: 0, want: numberOfWorkers},
+		{name: "negative", workers: -1, want: numberOfWorkers},
+		{name: "custom", workers: 1, want: 1},
+		{name: "upper bound", workers: limit, want: limit},
+		{name: "clamped", workers: limit + 1, want: limit, clamped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obs, logs := observer.New(zapcore.WarnLevel)
+
+			writer := newTestOpenSearchWriter(t,
+				WithOpenSearchWorkers(tt.workers),
+				WithInternalLogger(zap.New(obs)),
+			)
+			assert.Equal(t, tt.want, writer.indexerConfig.NumWorkers)
+			assert.Equal(t, tt.clamped, logs.Len() == 1)
+		})
+	}
+}
+
+func TestOpenSearchFlushSettings(t *testing.T) {
+	writer := newTestOpenSearchWriter(t)
+	assert.Equal(t, flushBytes, writer.indexerConfig.FlushBytes)
+	assert.Equal(t, flushInterval, writer.indexerConfig.FlushInterval)
+
+	writer = newTestOpenSearchWriter(t, WithOpenSearchFlushBytes(-1), WithOpenSearchFlushInterval(0))
+	assert.Equal(t, flushBytes, writer.indexerConfig.FlushBytes)
+	assert.Equal(t, flushInterval, writer.indexerConfig.FlushInterval)
+
+	writer = newTestOpenSearchWriter(t, WithOpenSearchFlushBytes(4096), WithOpenSearchFlushInterval(time.Second))
+	assert.Equal(t, 4096, writer.indexerConfig.FlushBytes)
+	assert.Equal(t, time.Second, writer.indexerConfig.FlushInterval)
+}
+
+func TestFingerprint(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithFingerprint("component"))
+
+	entries := []string{
+		`{"ts":"2024-01-25T12:00:00.000Z","msg":"db timeout","component":"db","request_id":"a"}`,
+		`{"ts":"2024-01-25T12:00:05.000Z","msg":"db timeout","component":"db","request_id":"b"}`,
+		`{"ts":"2024-01-25T12:00:05.000Z","msg":"db timeout","component":"cache","request_id":"c"}`,
+		`{"ts":"2024-01-25T12:00:05.000Z","msg":"db unavailable","component":"db","request_id":"d"}`,
+	}
+	for _, entry := range entries {
+		_, err := writer.Write([]byte(entry))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, len(entries))
+
+	fingerprints := map[string]string{}
+	for _, doc := range docs {
+		fingerprint := toString(doc.Source[fingerprintKey])
+		require.Len(t, fingerprint, 2*fingerprintBytes)
+		fingerprints[toString(doc.Source["request_id"])] = fingerprint
+	}
+
+	assert.Equal(t, fingerprints["a"], fingerprints["b"], "timestamps and unlisted fields are ignored")
+	assert.NotEqual(t, fingerprints["a"], fingerprints["c"], "listed fields are part of the fingerprint")
+	assert.NotEqual(t, fingerprints["a"], fingerprints["d"], "the message is part of the fingerprint")
+}
+
+func TestNoFingerprintByDefault(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t)
+
+	_, err := writer.Write([]byte(`{"msg":"hello"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+	assert.NotContains(t, docs[0].Source, fingerprintKey)
+}
+
+func TestRequiredFields(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithRequiredFields("tenant_id", "request_id"))
+
+	for _, entry := range []string{
+		`{"msg":"complete","tenant_id":"t","request_id":"r"}`,
+		`{"msg":"incomplete","tenant_id":"t"}`,
+	} {
+		_, err := writer.Write([]byte(entry))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	missing := map[string]interface{}{}
+	for _, doc := range fake.Docs() {
+		missing[toString(doc.Source["msg"])] = doc.Source[missingFieldsKey]
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"complete":   nil,
+		"incomplete": []interface{}{"request_id"},
+	}, missing)
+}
+
+func TestRequiredFieldsDrop(t *testing.T) {
+	obs, logs := observer.New(zapcore.WarnLevel)
+
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t,
+		WithRequiredFields("tenant_id"),
+		WithDropIncomplete(true),
+		WithInternalLogger(zap.New(obs)),
+	)
+
+	for _, entry := range []string{`{"msg":"complete","tenant_id":"t"}`, `{"msg":"incomplete"}`} {
+		_, err := writer.Write([]byte(entry))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+	assert.Equal(t, "complete", docs[0].Source["msg"])
+	assert.Equal(t, uint64(1), writer.stats().Dropped)
+
+	warnings := logs.FilterMessage("Dropping log entry missing required fields").All()
+	require.Len(t, warnings, 1)
+	assert.Equal(t, "incomplete", warnings[0].ContextMap()["message"])
+}
+
+func TestOpenSearchIndexRotation(t *testing.T) {
+	now := time.Date(2024, 1, 25, 23, 59, 59, 0, time.UTC)
+
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithClock(func() time.Time { return now }))
+	assert.Empty(t, writer.indexerConfig.Index, "no stale default index")
+
+	write := func(msg string) {
+		_, err := writer.Write([]byte(`{"msg":"` + msg + `"}`))
+		require.NoError(t, err)
+	}
+
+	write("before midnight")
+
+	now = now.Add(2 * time.Second)
+	write("after midnight")
+
+	require.NoError(t, writer.Flush(context.Background()))
+	write("after flush")
+
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	indices := map[string]string{}
+	for _, doc := range fake.Docs() {
+		indices[toString(doc.Source["msg"])] = doc.Index()
+	}
+
+	assert.Equal(t, map[string]string{
+		"before midnight": "zlog-test-2024.01.25",
+		"after midnight":  "zlog-test-2024.01.26",
+		"after flush":     "zlog-test-2024.01.26",
+	}, indices)
+}
+
+func TestOpenSearchFallback(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+
+	fake := newFakeOpenSearch(t)
+	fake.SetItemStatus(func(doc fakeDoc) int {
+		if doc.Source["msg"] == "rejected" {
+			return http.StatusBadRequest
+		}
+
+		return http.StatusCreated
+	})
+
+	writer := fake.newWriter(t, WithOpenSearchFallback(fallbackFile))
+
+	for _, msg := range []string{"accepted", "rejected", "accepted too"} {
+		_, err := writer.Write([]byte(`{"msg":"` + msg + `"}`))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+	assert.Len(t, fake.Docs(), 2)
+	assert.Equal(t, []string{`{"msg":"rejected"}`}, readLines(t, fallbackFile))
+}
+
+func TestOpenSearchFallbackOnFailedFlush(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+
+	fake := newFakeOpenSearch(t)
+	fake.SetBulkStatus(http.StatusInternalServerError)
+
+	writer := fake.newWriter(t, WithOpenSearchFallback(fallbackFile))
+
+	var entries []string
+
+	for i := range 50 {
+		entry := fmt.Sprintf(`{"msg":"entry %d"}`, i)
+		entries = append(entries, entry)
+
+		_, err := writer.Write([]byte(entry))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+	assert.Empty(t, fake.Docs())
+	assert.Equal(t, entries, readLines(t, fallbackFile), "in log order")
+	assert.Zero(t, writer.stats().Dropped)
+}
+
+func TestOpenSearchFallbackDuringOutage(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+
+	fake := newFakeOpenSearch(t)
+	fake.SetBulkStatus(http.StatusInternalServerError)
+
+	writer := fake.newWriter(t,
+		WithOpenSearchFallback(fallbackFile),
+		WithOpenSearchFlushInterval(10*time.Millisecond),
+	)
+
+	_, err := writer.Write([]byte(`{"msg":"during outage"}`))
+	require.NoError(t, err)
+
+	// without flushing the writer: the failed bulk request spills the entry
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(fallbackFile)
+		return err == nil && string(data) == "{\"msg\":\"during outage\"}\n"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestFallbackPendingCap(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+
+	fallback := newFallbackWriter(fallbackFile)
+
+	for i := range maxFallbackPending + 2 {
+		require.NoError(t, fallback.track(uint64(i+1), []byte(fmt.Sprintf(`{"seq":%d}`, i))))
+	}
+
+	assert.Len(t, fallback.pending, maxFallbackPending)
+	assert.Equal(t, []string{`{"seq":0}`, `{"seq":1}`}, readLines(t, fallbackFile), "oldest entries written")
+
+	fallback.ack(4)
+
+	require.NoError(t, fallback.flush(5))
+	assert.Equal(t, []string{`{"seq":0}`, `{"seq":1}`, `{"seq":2}`, `{"seq":4}`}, readLines(t, fallbackFile))
+}
+
+func TestAppendOnly(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t,
+		WithAppendOnly(true),
+		WithOpenSearchDocumentID(func(entry map[string]interface{}) string { return toString(entry["audit_id"]) }),
+		WithOpenSearchFallback(fallbackFile),
+		WithOpenSearchWorkers(1),
+	)
+
+	for _, entry := range []string{
+		`{"msg":"generated id"}`,
+		`{"msg":"original","audit_id":"audit-1"}`,
+		`{"msg":"overwrite","audit_id":"audit-1"}`,
+	} {
+		_, err := writer.Write([]byte(entry))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 2)
+
+	for _, doc := range docs {
+		assert.Equal(t, bulkActionCreate, doc.Action)
+	}
+
+	assert.Equal(t, "original", docs[1].Source["msg"])
+	assert.Equal(t, "audit-1", docs[1].ID())
+	assert.Empty(t, docs[0].ID())
+	assert.Equal(t, []string{`{"audit_id":"audit-1","msg":"overwrite"}`}, readLines(t, fallbackFile),
+		"duplicate ID is rejected")
+}
+
+func TestOpenSearchDocumentID(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithOpenSearchDocumentID(func(entry map[string]interface{}) string {
+		return toString(entry["ts"]) + "|" + toString(entry["msg"])
+	}))
+
+	_, err := writer.Write([]byte(`{"ts":"2024-01-25T12:00:00.000Z","msg":"hello"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+	assert.Equal(t, "2024-01-25T12:00:00.000Z|hello", docs[0].ID())
+}
+
+func TestOpenSearchDataStream(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchDataStream("logs-app"),
+	)
+	require.NoError(t, err)
+
+	logger.Info("hello")
+	require.NoError(t, flushFunc(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+	assert.Equal(t, bulkActionCreate, docs[0].Action)
+	assert.Equal(t, "logs-app", docs[0].Index())
+}
+
+func TestIndexActionByDefault(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t)
+
+	_, err := writer.Write([]byte(`{"msg":"hello"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+	assert.Equal(t, bulkActionIndex, docs[0].Action)
+}
+
+func TestOpenSearchFlushAndContinue(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t)
+
+	for _, msg := range []string{"first", "second"} {
+		_, err := writer.Write([]byte(`{"msg":"` + msg + `"}`))
+		require.NoError(t, err)
+		require.NoError(t, writer.Flush(context.Background()))
+	}
+
+	assert.Len(t, fake.Docs(), 2, "each flush ships the buffered entries")
+
+	stats := writer.stats()
+	assert.Equal(t, uint64(2), stats.Added)
+	assert.Equal(t, uint64(2), stats.Flushed)
+
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	_, err := writer.Write([]byte(`{"msg":"third"}`))
+	require.ErrorIs(t, err, ErrWriterClosed)
+	require.ErrorIs(t, writer.Flush(context.Background()), ErrWriterClosed)
+	assert.Equal(t, uint64(1), writer.stats().Dropped)
+}
+
+func TestOpenSearchFlushFallbackPerIndexer(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+
+	fake := newFakeOpenSearch(t)
+	fake.SetBulkStatus(http.StatusInternalServerError)
+
+	writer := fake.newWriter(t, WithOpenSearchFallback(fallbackFile))
+
+	_, err := writer.Write([]byte(`{"msg":"first"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.Flush(context.Background()))
+	assert.Equal(t, []string{`{"msg":"first"}`}, readLines(t, fallbackFile))
+
+	fake.SetBulkStatus(0)
+
+	_, err = writer.Write([]byte(`{"msg":"second"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	assert.Equal(t, []string{`{"msg":"first"}`}, readLines(t, fallbackFile))
+	require.Len(t, fake.Docs(), 1)
+	assert.Equal(t, "second", fake.Docs()[0].Source["msg"])
+}
+
+func TestOpenSearchCleanUpWhileLogging(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+	)
+	require.NoError(t, err)
+
+	const (
+		loggers = 4
+		entries = 50
+	)
+
+	var wg sync.WaitGroup
+
+	for range loggers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range entries {
+				logger.Info("hello")
+			}
+		}()
+	}
+
+	for range 5 {
+		assert.NoError(t, flushFunc(context.Background()))
+	}
+
+	wg.Wait()
+	require.NoError(t, logger.Sync())
+	assert.Len(t, fake.Docs(), loggers*entries)
+}
+
+func TestInternalLoggerLevel(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	for _, tt := range []struct {
+		name  string
+		opts  []LogOptFunc
+		infos int
+	}{
+		{name: "default", infos: 2},
+		{name: "warn", opts: []LogOptFunc{WithInternalLoggerLevel(zapcore.WarnLevel)}, infos: 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			obs, logs := observer.New(zapcore.DebugLevel)
+
+			opts := append([]LogOptFunc{WithOpenSearchConfig(&config), WithInternalLogger(zap.New(obs))}, tt.opts...)
+			opt := newOpenSearchLogOpts(opts...)
+
+			_, writer, err := newOpenSearchCore(opt, NewIndexGenerator(IndexConfig{BaseIndexName: "zlog-test"}))
+			require.NoError(t, err)
+			require.NoError(t, writer.FlushWithContext(context.Background()))
+
+			assert.Equal(t, tt.infos, logs.FilterLevelExact(zapcore.InfoLevel).Len())
+
+			opt.internalLogger.Warn("problem")
+			assert.Equal(t, 1, logs.FilterLevelExact(zapcore.WarnLevel).Len())
+		})
+	}
+}
+
+func TestDefaultInternalLogger(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	errOut := &zaptest.Buffer{}
+	original := stderr
+	stderr = errOut
+
+	t.Cleanup(func() { stderr = original })
+
+	redirected := &zaptest.Buffer{}
+
+	for _, tt := range []struct {
+		name string
+		opts []LogOptFunc
+		out  *zaptest.Buffer
+	}{
+		{name: "stderr", out: errOut},
+		{name: "writer", opts: []LogOptFunc{WithInternalLoggerWriter(redirected)}, out: redirected},
+		{name: "nop", opts: []LogOptFunc{WithInternalLogger(zap.NewNop()), WithInternalLoggerWriter(redirected)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			errOut.Reset()
+			redirected.Reset()
+
+			opts := append([]LogOptFunc{WithOpenSearchConfig(&config), WithOpenSearchIndex("zlog-test", "yyyy-MM-dd")}, tt.opts...)
+			_, flushFunc, err := NewZapLoggerWithOpenSearch(opts...)
+			require.NoError(t, err)
+			require.NoError(t, flushFunc(context.Background()))
+
+			if tt.out == nil {
+				assert.Empty(t, errOut.String())
+				assert.Empty(t, redirected.String())
+
+				return
+			}
+
+			lines := tt.out.Lines()
+			require.Len(t, lines, 1, "informational messages are hidden")
+			assert.Contains(t, lines[0], "zlog\tIndex names won't rotate")
+		})
+	}
+}
+
+func TestOpenSearchErrorIndex(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("app-logs", ""),
+		WithOpenSearchErrorIndex("app-errors"),
+		WithLogLevel(zapcore.DebugLevel),
+	)
+	require.NoError(t, err)
+
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Error("error")
+	logger.DPanic("dpanic")
+
+	require.NoError(t, flushFunc(context.Background()))
+
+	indices := map[string]string{}
+	for _, doc := range fake.Docs() {
+		indices[toString(doc.Source["msg"])] = doc.Index()
+	}
+
+	logsIndex := NewIndexGenerator(IndexConfig{BaseIndexName: "app-logs"}).GetIndexName()
+	errorsIndex := NewIndexGenerator(IndexConfig{BaseIndexName: "app-errors"}).GetIndexName()
+	assert.Equal(t, map[string]string{
+		"debug":  logsIndex,
+		"info":   logsIndex,
+		"error":  errorsIndex,
+		"dpanic": errorsIndex,
+	}, indices)
+}
+
+func TestOpenSearchCoresShareClient(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+	opt := newOpenSearchLogOpts(WithOpenSearchConfig(&config), WithOpenSearchIndex("app-logs", ""),
+		WithOpenSearchErrorIndex("app-errors"))
+
+	_, writers, err := newOpenSearchCores(opt)
+	require.NoError(t, err)
+	require.Len(t, writers, 2)
+
+	assert.Same(t, writers[0].client, writers[1].client)
+
+	for _, writer := range writers {
+		require.NoError(t, writer.FlushWithContext(context.Background()))
+	}
+}
+
+// syncBuffer is a zaptest.Buffer that may be read while a logger writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf zaptest.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Sync() error { return nil }
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestStatsReportInterval(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	buf := &syncBuffer{}
+	original := stdout
+	stdout = buf
+
+	t.Cleanup(func() { stdout = original })
+
+	reports := func() int {
+		return strings.Count(buf.String(), statsReportMessage)
+	}
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithConsole(true),
+		WithStatsReportInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	logger.Info("hello")
+
+	require.Eventually(t, func() bool { return reports() >= 2 }, time.Second, 5*time.Millisecond)
+	assert.Contains(t, buf.String(), `"dropped"`)
+
+	require.NoError(t, logger.Sync())
+
+	synced := reports()
+	require.Eventually(t, func() bool { return reports() > synced }, time.Second, 5*time.Millisecond,
+		"Sync keeps reporting")
+
+	require.NoError(t, flushFunc(context.Background()))
+
+	stopped := reports()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, stopped, reports(), "no reports after cleanup")
+}
+
+func TestOpenSearchCompression(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithOpenSearchCompression(true))
+
+	for range 100 {
+		_, err := writer.Write([]byte(`{"level":"info","msg":"the same message over and over again"}`))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Flush(context.Background()))
+
+	stats := openSearchWriters{writer}.stats()
+	assert.Len(t, fake.Docs(), 100)
+	assert.Positive(t, stats.CompressedBytes)
+	assert.Less(t, stats.CompressedBytes, stats.UncompressedBytes)
+	assert.Less(t, stats.CompressionRatio(), 0.5)
+}
+
+func TestOpenSearchRateLimit(t *testing.T) {
+	const (
+		bytesPerSec = 50_000
+		burst       = 5_000
+	)
+
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithOpenSearchRateLimit(bytesPerSec, burst))
+
+	entry := []byte(`{"msg":"` + strings.Repeat("x", 1000) + `"}`)
+	start := time.Now()
+
+	var written int
+
+	for time.Since(start) < 200*time.Millisecond {
+		_, err := writer.Write(entry)
+		require.NoError(t, err)
+
+		written++
+	}
+
+	elapsed := time.Since(start)
+
+	require.NoError(t, writer.Flush(context.Background()))
+
+	stats := writer.stats()
+	shipped := float64(len(fake.Docs()) * len(entry))
+	budget := burst + bytesPerSec*elapsed.Seconds()
+
+	assert.LessOrEqual(t, shipped, budget+float64(len(entry)))
+	assert.Greater(t, shipped, budget/2)
+	assert.Equal(t, uint64(written), stats.Added+stats.Dropped)
+	assert.Positive(t, stats.Dropped)
+}
+
+func TestOpenSearchRateLimitBurst(t *testing.T) {
+	entry := []byte(`{"msg":"` + strings.Repeat("x", 1000) + `"}`)
+
+	for _, tt := range []struct {
+		name string
+		opts []LogOptFunc
+	}{
+		{name: "default burst", opts: []LogOptFunc{WithOpenSearchRateLimit(50_000, 0)}},
+		{name: "burst below the max doc size", opts: []LogOptFunc{WithOpenSearchRateLimit(50_000, 10), WithOpenSearchMaxDocSize(2000)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeOpenSearch(t)
+			writer := fake.newWriter(t, tt.opts...)
+
+			_, err := writer.Write(entry)
+			require.NoError(t, err)
+			require.NoError(t, writer.Flush(context.Background()))
+
+			assert.Len(t, fake.Docs(), 1)
+			assert.Zero(t, writer.stats().Dropped)
+		})
+	}
+}
+
+func TestFlushNotify(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	flushed := make(chan struct{}, 10)
+	writer := fake.newWriter(t, WithFlushNotify(flushed), WithOpenSearchWorkers(1))
+
+	for i := range 3 {
+		_, err := writer.Write([]byte(`{"msg":"hello"}`))
+		require.NoError(t, err)
+		require.NoError(t, writer.Flush(context.Background()))
+
+		assert.Len(t, flushed, 1, "flush %d", i)
+		<-flushed
+		assert.Len(t, fake.Docs(), i+1)
+	}
+
+	require.NoError(t, writer.Flush(context.Background()))
+	assert.Empty(t, flushed, "nothing delivered")
+
+	fake.SetBulkStatus(http.StatusInternalServerError)
+
+	_, err := writer.Write([]byte(`{"msg":"lost"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.Flush(context.Background()))
+	assert.Empty(t, flushed, "failed flush")
+}
+
+func TestOpenSearchFlushWithContextCancelled(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	// every entry triggers a flush of the worker, which then hangs in the request
+	writer := fake.newWriter(t, WithOpenSearchWorkers(1), WithOpenSearchFlushBytes(1))
+	fake.BlockBulk(t)
+
+	_, err := writer.Write([]byte(`{"msg":"stuck"}`))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = writer.FlushWithContext(ctx)
+
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = writer.Write([]byte(`{"msg":"late"}`))
+	assert.ErrorIs(t, err, ErrWriterClosed)
+}
+
+func TestCleanUpCancelledWhileWriteBlocked(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithBulkIndexerFactory(func(opensearchutil.BulkIndexerConfig) (opensearchutil.BulkIndexer, error) {
+			return &blockingIndexer{}, nil
+		}),
+		WithWriteTimeout(10*time.Second),
+	)
+	require.NoError(t, err)
+
+	go logger.Info("stuck")
+
+	// let the write take the writer
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = flushFunc(ctx)
+
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second, "the blocked write isn't waited for")
+}
+
+func TestCloseOnCleanUp(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithBulkIndexerFactory(func(opensearchutil.BulkIndexerConfig) (opensearchutil.BulkIndexer, error) {
+			return &blockingIndexer{}, nil
+		}),
+		WithWriteTimeout(10*time.Second),
+		WithCloseOnCleanUp(true),
+	)
+	require.NoError(t, err)
+
+	logged := make(chan struct{})
+
+	go func() {
+		defer close(logged)
+		logger.Info("stuck")
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, flushFunc(context.Background()))
+	assert.Less(t, time.Since(start), time.Second, "the blocked write is aborted")
+
+	select {
+	case <-logged:
+	case <-time.After(time.Second):
+		t.Fatal("the blocked write didn't return")
+	}
+
+	require.ErrorIs(t, flushFunc(context.Background()), ErrWriterClosed, "closed for good")
+}
+
+func TestFlushLogsWithContext(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+
+	var deadline time.Time
+
+	// a flush that only ends with its context, like one stuck on a hung cluster
+	hung := func(ctx context.Context) error {
+		deadline, _ = ctx.Deadline()
+		<-ctx.Done()
+
+		return ctx.Err()
+	}
+
+	parent, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	FlushLogsWithContext(hung, parent, zap.New(obs))()
+
+	assert.Less(t, time.Since(start), time.Second)
+	assert.WithinDuration(t, start.Add(50*time.Millisecond), deadline, 40*time.Millisecond)
+	require.Equal(t, 1, logs.FilterMessage("Error during flush").Len())
+
+	FlushLogsWithContext(func(ctx context.Context) error {
+		deadline, _ = ctx.Deadline()
+		return nil
+	}, context.Background(), zap.New(obs))()
+
+	assert.WithinDuration(t, time.Now().Add(shutdownTimeout), deadline, time.Second, "default bound")
+	assert.Equal(t, 1, logs.FilterMessage("Logs flushed successfully").Len())
+}
+
+func TestOpenSearchSampling(t *testing.T) {
+	buf := captureStdout(t)
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	var handle Handle
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithConsole(true),
+		WithHandle(&handle),
+		WithSampling(time.Minute, 10, 100),
+	)
+	require.NoError(t, err)
+
+	for range 1000 {
+		logger.Info("storm")
+	}
+
+	require.NoError(t, flushFunc(context.Background()))
+
+	// the first 10, then the 110th, 210th, ... 910th
+	assert.Len(t, fake.Docs(), 10+9)
+	assert.Equal(t, uint64(10+9), handle.Stats().Added)
+	assert.Len(t, buf.Lines(), 1000, "console is not sampled")
+}
+
+func TestOpenSearchWarmThreshold(t *testing.T) {
+	now := time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC)
+
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithWarmThreshold(24*time.Hour), WithClock(func() time.Time { return now }))
+
+	for msg, ts := range map[string]time.Time{
+		"recent":     now.Add(-time.Hour),
+		"backfilled": now.Add(-72 * time.Hour),
+	} {
+		_, err := writer.Write([]byte(`{"msg":"` + msg + `","ts":"` + ts.Format(iso8601Layout) + `"}`))
+		require.NoError(t, err)
+	}
+
+	_, err := writer.Write([]byte(`{"msg":"untimed"}`))
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Flush(context.Background()))
+
+	indices := map[string]string{}
+	for _, doc := range fake.Docs() {
+		indices[toString(doc.Source["msg"])] = doc.Index()
+	}
+
+	assert.Equal(t, map[string]string{
+		"recent":     "zlog-test-2024.01.25",
+		"backfilled": "zlog-test-warm-2024.01.25",
+		"untimed":    "zlog-test-2024.01.25",
+	}, indices)
+}
+
+func TestOpenSearchDocRateLimit(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithOpenSearchDocRateLimit(50), WithInternalLogger(zap.New(obs)))
+
+	for range 500 {
+		_, err := writer.Write([]byte(`{"msg":"runaway"}`))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	stats := writer.stats()
+	assert.InDelta(t, 50, len(fake.Docs()), 5)
+	assert.Equal(t, uint64(500), stats.Added+stats.Dropped)
+
+	completed := logs.FilterMessage("Flush completed").All()
+	require.Len(t, completed, 1)
+	assert.Equal(t, stats.Dropped, completed[0].ContextMap()["dropped"])
+}
+
+func TestIndexTemplate(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	template := func(attemptType string) map[string]interface{} {
+		return map[string]interface{}{
+			"index_patterns": []interface{}{"zlog-test-*"},
+			"template": map[string]interface{}{
+				"mappings": map[string]interface{}{
+					"properties": map[string]interface{}{"attempt": map[string]interface{}{"type": attemptType}},
+				},
+			},
+		}
+	}
+
+	newLogger := func(opts ...LogOptFunc) {
+		opts = append([]LogOptFunc{WithOpenSearchConfig(&config), WithOpenSearchIndex("zlog-test", "")}, opts...)
+
+		_, flushFunc, err := NewZapLoggerWithOpenSearch(opts...)
+		require.NoError(t, err)
+		require.NoError(t, flushFunc(context.Background()))
+	}
+
+	newLogger(WithIndexTemplate("zlog", template("integer")))
+
+	stored, puts := fake.Template("zlog")
+	assert.Equal(t, template("integer"), stored)
+	assert.Equal(t, 1, puts)
+
+	newLogger(WithIndexTemplate("zlog", template("long")))
+
+	stored, puts = fake.Template("zlog")
+	assert.Equal(t, template("integer"), stored, "existing template kept")
+	assert.Equal(t, 1, puts)
+
+	newLogger(WithIndexTemplate("zlog", template("long")), WithIndexTemplateForce(true))
+
+	stored, puts = fake.Template("zlog")
+	assert.Equal(t, template("long"), stored)
+	assert.Equal(t, 2, puts)
+
+	_, _, err := NewZapLoggerWithOpenSearch(WithOpenSearchConfig(&config), WithOpenSearchIndex("zlog-test", ""),
+		WithIndexTemplate("broken", map[string]interface{}{"invalid": func() {}}))
+	require.ErrorIs(t, err, ErrCreateOpensearchCore)
+	assert.ErrorContains(t, err, "index template")
+}
+
+func TestOpenSearchReconnect(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	fake.SetDown(true)
+
+	config := fake.Config()
+	config.DisableRetry = true
+	filename := filepath.Join(t.TempDir(), "zlog.log")
+
+	buf := captureStdout(t)
+	obs, logs := observer.New(zapcore.InfoLevel)
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchReconnect(10*time.Millisecond),
+		WithLjFilename(filename),
+		WithInternalLogger(zap.New(obs)),
+	)
+	require.NoError(t, err)
+
+	logger.Info("while down")
+	require.NoError(t, logger.Sync())
+
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "while down")
+	assert.Contains(t, buf.String(), "while down")
+
+	fake.SetDown(false)
+
+	require.Eventually(t, func() bool {
+		return logs.FilterMessageSnippet("Connected to OpenSearch").Len() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	logger.Info("after reconnect")
+	require.NoError(t, flushFunc(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+	assert.Equal(t, "after reconnect", docs[0].Source["msg"])
+
+	content, err = os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "after reconnect")
+}
+
+func TestOpenSearchReconnectCleanUp(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	fake.SetDown(true)
+
+	config := fake.Config()
+	config.DisableRetry = true
+	captureStdout(t)
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchReconnect(10*time.Millisecond),
+		WithLjFilename(filepath.Join(t.TempDir(), "zlog.log")),
+	)
+	require.NoError(t, err)
+
+	// returns once the reconnect attempts stopped
+	require.NoError(t, flushFunc(context.Background()))
+
+	fake.SetDown(false)
+	logger.Info("after cleanup")
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Empty(t, fake.Docs())
+}
+
+func TestOpenSearchCircuitBreaker(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+	cooldown := 100 * time.Millisecond
+
+	fake := newFakeOpenSearch(t)
+	fake.SetBulkStatus(http.StatusInternalServerError)
+
+	obs, logs := observer.New(zapcore.InfoLevel)
+	writer := fake.newWriter(t,
+		WithOpenSearchCircuitBreaker(2, cooldown),
+		WithOpenSearchFallback(fallbackFile),
+		WithInternalLogger(zap.New(obs)),
+	)
+
+	for _, msg := range []string{"first", "second"} {
+		_, err := writer.Write([]byte(`{"msg":"` + msg + `"}`))
+		require.NoError(t, err)
+		require.NoError(t, writer.Flush(context.Background()))
+	}
+
+	require.Equal(t, 1, logs.FilterMessageSnippet("circuit breaker opened").Len())
+
+	_, err := writer.Write([]byte(`{"msg":"skipped"}`))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), writer.stats().Added, "the bulk indexer is skipped")
+	assert.Equal(t, []string{`{"msg":"first"}`, `{"msg":"second"}`, `{"msg":"skipped"}`}, readLines(t, fallbackFile))
+
+	fake.SetBulkStatus(0)
+	time.Sleep(cooldown)
+
+	_, err = writer.Write([]byte(`{"msg":"recovered"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.Flush(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+	assert.Equal(t, "recovered", docs[0].Source["msg"])
+	assert.Equal(t, 1, logs.FilterMessageSnippet("circuit breaker closed").Len())
+}
+
+func TestOpenSearchCircuitBreakerReopens(t *testing.T) {
+	cooldown := 50 * time.Millisecond
+
+	fake := newFakeOpenSearch(t)
+	fake.SetBulkStatus(http.StatusInternalServerError)
+
+	writer := fake.newWriter(t, WithOpenSearchCircuitBreaker(1, cooldown))
+
+	_, err := writer.Write([]byte(`{"msg":"first"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.Flush(context.Background()))
+
+	time.Sleep(cooldown)
+
+	// the probe fails, so the circuit opens again right away
+	_, err = writer.Write([]byte(`{"msg":"probe"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.Flush(context.Background()))
+
+	_, err = writer.Write([]byte(`{"msg":"skipped"}`))
+	require.NoError(t, err)
+
+	stats := writer.stats()
+	assert.Equal(t, uint64(2), stats.Added)
+	assert.Equal(t, uint64(1), stats.Dropped)
+}
+
+func TestManagedRollover(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+	obs, logs := observer.New(zapcore.InfoLevel)
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithManagedRollover("zlog-logs", RolloverConditions{MaxDocs: 2, Interval: 10 * time.Millisecond}),
+		WithInternalLogger(zap.New(obs)),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1, logs.FilterMessage("Rollover index created").Len())
+
+	logger.Info("first")
+	logger.Info("second")
+	require.NoError(t, logger.Sync())
+
+	require.Eventually(t, func() bool {
+		return logs.FilterMessage("Index rolled over").Len() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	logger.Info("third")
+	require.NoError(t, flushFunc(context.Background()))
+
+	indices := map[string]string{}
+	for _, doc := range fake.Docs() {
+		assert.Equal(t, bulkActionIndex, doc.Action)
+		indices[toString(doc.Source["msg"])] = doc.Index()
+	}
+
+	assert.Equal(t, map[string]string{
+		"first":  "zlog-logs-000001",
+		"second": "zlog-logs-000001",
+		"third":  "zlog-logs-000002",
+	}, indices)
+}
+
+func TestManagedRolloverOptions(t *testing.T) {
+	config := opensearch.Config{Addresses: []string{"http://localhost:9200"}}
+	conditions := RolloverConditions{MaxAge: "1d"}
+
+	_, _, err := NewZapLoggerWithOpenSearch(WithOpenSearchConfig(&config),
+		WithManagedRollover("zlog-logs", conditions), WithOpenSearchIndex("zlog", ""))
+	require.ErrorIs(t, err, ErrRolloverWithIndex)
+
+	_, _, err = NewZapLoggerWithOpenSearch(WithOpenSearchConfig(&config),
+		WithManagedRollover("zlog-logs", conditions), WithOpenSearchDataStream("zlog-stream"))
+	require.ErrorIs(t, err, ErrRolloverWithIndex)
+
+	_, _, err = NewZapLoggerWithOpenSearch(WithOpenSearchConfig(&config),
+		WithManagedRollover("zlog-logs", RolloverConditions{}))
+	require.ErrorIs(t, err, ErrNoRolloverConditions)
+}
+
+func TestOpenSearchWriterFastPath(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t)
+
+	buffer := []byte(`{"msg":"fast","level":"info"}` + "\n")
+	_, err := writer.Write(buffer)
+	require.NoError(t, err)
+
+	// zap reuses the buffer
+	copy(buffer, `{"msg":"oops"`)
+	require.NoError(t, writer.Flush(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+	assert.Equal(t, map[string]interface{}{"msg": "fast", "level": "info"}, docs[0].Source)
+
+	validating := fake.newWriter(t, WithOpenSearchValidateEntries(true))
+
+	_, err = validating.Write([]byte(`{"msg":`))
+	require.ErrorContains(t, err, "failed to parse log entry")
+}
+
+func BenchmarkOpenSearchWriterWrite(b *testing.B) {
+	entry := []byte(`{"level":"info","ts":"2024-01-02T03:04:05.678Z","caller":"zlog/bench.go:42",` +
+		`"msg":"request served","method":"GET","path":"/api/v1/items","status":200,"duration":0.0123}` + "\n")
+
+	for _, bench := range []struct {
+		name string
+		opts []LogOptFunc
+	}{
+		{name: "fast", opts: nil},
+		{name: "validate", opts: []LogOptFunc{WithOpenSearchValidateEntries(true)}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			writer := newFakeOpenSearch(b).newWriter(b, bench.opts...)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for range b.N {
+				if _, err := writer.Write(entry); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenSearchPipeline(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+
+	writer := fake.newWriter(t, WithOpenSearchPipeline("geoip"))
+	_, err := writer.Write([]byte(`{"msg":"enrich me"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.Flush(context.Background()))
+
+	plain := fake.newWriter(t)
+	_, err = plain.Write([]byte(`{"msg":"as is"}`))
+	require.NoError(t, err)
+	require.NoError(t, plain.Flush(context.Background()))
+
+	pipelines := map[string]string{}
+	for _, doc := range fake.Docs() {
+		pipelines[toString(doc.Source["msg"])] = doc.Pipeline
+	}
+
+	assert.Equal(t, map[string]string{"enrich me": "geoip", "as is": ""}, pipelines)
+}
+
+// capturingIndexer is an in-memory bulk indexer accepting every item.
+type capturingIndexer struct {
+	mu    sync.Mutex
+	items []opensearchutil.BulkIndexerItem
+	// the bodies of items, read on Add
+	bodies []string
+	closed bool
+}
+
+func (c *capturingIndexer) Add(ctx context.Context, item opensearchutil.BulkIndexerItem) error {
+	body, err := io.ReadAll(item.Body)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = append(c.items, item)
+	c.bodies = append(c.bodies, string(body))
+
+	return nil
+}
+
+func (c *capturingIndexer) Close(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+
+	for _, item := range c.items {
+		if item.OnSuccess != nil {
+			item.OnSuccess(ctx, item, opensearchutil.BulkIndexerResponseItem{Status: http.StatusCreated})
+		}
+	}
+
+	return nil
+}
+
+func (c *capturingIndexer) Stats() opensearchutil.BulkIndexerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := uint64(len(c.items))
+	if !c.closed {
+		return opensearchutil.BulkIndexerStats{NumAdded: n}
+	}
+
+	return opensearchutil.BulkIndexerStats{NumAdded: n, NumFlushed: n}
+}
+
+func TestBulkIndexerFactory(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		indexers []*capturingIndexer
+	)
+
+	factory := func(config opensearchutil.BulkIndexerConfig) (opensearchutil.BulkIndexer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		indexer := &capturingIndexer{}
+		indexers = append(indexers, indexer)
+
+		return indexer, nil
+	}
+
+	// never contacted
+	config := opensearch.Config{Addresses: []string{"http://127.0.0.1:1"}}
+	handle := &Handle{}
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", string(DateFormatDot)),
+		WithOpenSearchDocumentID(func(entry map[string]interface{}) string { return toString(entry["request_id"]) }),
+		WithBulkIndexerFactory(factory),
+		WithHandle(handle),
+	)
+	require.NoError(t, err)
+
+	logger.Info("captured", zap.String("request_id", "r-1"))
+	require.NoError(t, flushFunc(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, indexers, 2, "flushing replaces the indexer")
+
+	first := indexers[0]
+	require.Len(t, first.items, 1)
+	assert.True(t, first.closed)
+
+	item := first.items[0]
+	assert.Equal(t, bulkActionIndex, item.Action)
+	assert.Equal(t, "zlog-test-"+time.Now().UTC().Format(string(DateFormatDot)), item.Index)
+	assert.Equal(t, "r-1", item.DocumentID)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(first.bodies[0]), &body))
+	assert.Equal(t, "captured", body["msg"])
+	assert.Equal(t, "info", body["level"])
+
+	assert.Equal(t, uint64(1), handle.Stats().Flushed)
+}
+
+func TestCompressLargeFields(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithCompressLargeFields(64))
+
+	payload := strings.Repeat(`{"item":"value"},`, 100)
+	entry, err := json.Marshal(map[string]interface{}{"msg": "request", "payload": payload, "status": 200})
+	require.NoError(t, err)
+
+	_, err = writer.Write(entry)
+	require.NoError(t, err)
+	require.NoError(t, writer.Flush(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+
+	source := docs[0].Source
+	assert.Equal(t, "request", source["msg"], "short fields are kept")
+	assert.InDelta(t, 200, source["status"], 0)
+	assert.NotContains(t, source, "payload")
+
+	compressed := toString(source["payload_compressed"])
+	assert.Less(t, len(compressed), len(payload))
+
+	decoded, err := DecompressField(compressed)
+	require.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+}
+
+func TestCompressLargeFieldsKeepsReservedKeys(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithCompressLargeFields(64), WithMessageKey("message"))
+
+	message := strings.Repeat("a long message ", 20)
+	stack := strings.Repeat("main.main()\n", 20)
+	entry, err := json.Marshal(map[string]interface{}{"message": message, "stacktrace": stack, "payload": message})
+	require.NoError(t, err)
+
+	_, err = writer.Write(entry)
+	require.NoError(t, err)
+	require.NoError(t, writer.Flush(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+
+	source := docs[0].Source
+	assert.Equal(t, message, source["message"], "the renamed message key stays searchable")
+	assert.Equal(t, stack, source["stacktrace"])
+	assert.Contains(t, source, "payload_compressed")
+}
+
+// failingIndexer refuses every item, like a bulk indexer whose queue stays full.
+type failingIndexer struct {
+	capturingIndexer
+	err error
+}
+
+func (f *failingIndexer) Add(context.Context, opensearchutil.BulkIndexerItem) error {
+	return f.err
+}
+
+func TestOpenSearchWriterFailedAdd(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+	errQueueFull := errors.New("queue full")
+
+	writer := newTestOpenSearchWriter(t,
+		WithBulkIndexerFactory(func(opensearchutil.BulkIndexerConfig) (opensearchutil.BulkIndexer, error) {
+			return &failingIndexer{err: errQueueFull}, nil
+		}),
+		WithOpenSearchFallback(fallbackFile),
+	)
+
+	_, err := writer.Write([]byte(`{"msg":"refused"}`))
+	require.ErrorIs(t, err, errQueueFull)
+	assert.ErrorContains(t, err, "failed to add document to bulk indexer")
+	assert.Zero(t, writer.stats().Dropped, "saved to the fallback file")
+	assert.Equal(t, []string{`{"msg":"refused"}`}, readLines(t, fallbackFile))
+}
+
+func TestOpenSearchWriterFailedAddUnwritableFallback(t *testing.T) {
+	// the parent of the fallback file is a regular file
+	parent := filepath.Join(t.TempDir(), "parent")
+	require.NoError(t, os.WriteFile(parent, nil, 0o600))
+
+	writer := newTestOpenSearchWriter(t,
+		WithBulkIndexerFactory(func(opensearchutil.BulkIndexerConfig) (opensearchutil.BulkIndexer, error) {
+			return &failingIndexer{err: errors.New("queue full")}, nil
+		}),
+		WithOpenSearchFallback(filepath.Join(parent, "fallback.log")),
+	)
+
+	_, err := writer.Write([]byte(`{"msg":"refused"}`))
+	require.Error(t, err)
+	assert.Equal(t, uint64(1), writer.stats().Dropped, "not saved to the fallback file")
+}
+
+func TestOpenSearchWriterStopping(t *testing.T) {
+	indexer := &capturingIndexer{}
+	writer := newTestOpenSearchWriter(t,
+		WithBulkIndexerFactory(func(opensearchutil.BulkIndexerConfig) (opensearchutil.BulkIndexer, error) {
+			return indexer, nil
+		}),
+	)
+
+	writer.stop()
+
+	_, err := writer.Write([]byte(`{"msg":"late"}`))
+	require.ErrorIs(t, err, ErrWriterIsStopping)
+	assert.Empty(t, indexer.items)
+	assert.Equal(t, uint64(1), writer.stats().Dropped)
+}
+
+// blockingIndexer never has room for an item, like a bulk indexer whose queue
+// stays full while OpenSearch is unreachable.
+type blockingIndexer struct {
+	capturingIndexer
+}
+
+func (b *blockingIndexer) Add(ctx context.Context, _ opensearchutil.BulkIndexerItem) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestWriteTimeout(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+	writer := newTestOpenSearchWriter(t,
+		WithBulkIndexerFactory(func(opensearchutil.BulkIndexerConfig) (opensearchutil.BulkIndexer, error) {
+			return &blockingIndexer{}, nil
+		}),
+		WithWriteTimeout(50*time.Millisecond),
+		WithOpenSearchFallback(fallbackFile),
+	)
+
+	start := time.Now()
+	_, err := writer.Write([]byte(`{"msg":"stuck"}`))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), writerCtxTimeout)
+	assert.Equal(t, []string{`{"msg":"stuck"}`}, readLines(t, fallbackFile))
+}
+
+func TestShutdownContext(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	writer := newTestOpenSearchWriter(t,
+		WithBulkIndexerFactory(func(opensearchutil.BulkIndexerConfig) (opensearchutil.BulkIndexer, error) {
+			return &blockingIndexer{}, nil
+		}),
+		WithShutdownContext(ctx),
+		WithOpenSearchFallback(fallbackFile),
+	)
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := writer.Write([]byte(`{"msg":"in flight"}`))
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), writerCtxTimeout, "the write timeout isn't waited for")
+
+	_, err = writer.Write([]byte(`{"msg":"after shutdown"}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{`{"msg":"in flight"}`, `{"msg":"after shutdown"}`}, readLines(t, fallbackFile))
+}
+
+// slowIndexer accepts every item, but acknowledges them only when told to, like
+// a bulk indexer falling behind.
+type slowIndexer struct {
+	capturingIndexer
+	// the first Add calls time out
+	timeouts int
+}
+
+func (s *slowIndexer) Add(ctx context.Context, item opensearchutil.BulkIndexerItem) error {
+	s.mu.Lock()
+	if s.timeouts > 0 {
+		s.timeouts--
+		s.mu.Unlock()
+
+		return context.DeadlineExceeded
+	}
+	s.mu.Unlock()
+
+	return s.capturingIndexer.Add(ctx, item)
+}
+
+func (s *slowIndexer) Close(context.Context) error {
+	return nil
+}
+
+// ack acknowledges the item at i.
+func (s *slowIndexer) ack(i int) {
+	s.mu.Lock()
+	item := s.items[i]
+	s.mu.Unlock()
+
+	item.OnSuccess(context.Background(), item, opensearchutil.BulkIndexerResponseItem{Status: http.StatusCreated})
+}
+
+func TestOpenSearchBackpressure(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+	indexer := &slowIndexer{}
+	writer := newTestOpenSearchWriter(t,
+		WithBulkIndexerFactory(func(opensearchutil.BulkIndexerConfig) (opensearchutil.BulkIndexer, error) {
+			return indexer, nil
+		}),
+		WithOpenSearchBackpressure(2),
+		WithWriteTimeout(50*time.Millisecond),
+		WithOpenSearchFallback(fallbackFile),
+	)
+
+	write := func(msg string) error {
+		_, err := writer.Write([]byte(`{"msg":"` + msg + `"}`))
+		return err
+	}
+
+	require.NoError(t, write("first"))
+	require.NoError(t, write("second"))
+
+	start := time.Now()
+	err := write("saturated")
+	require.ErrorIs(t, err, ErrIndexerSaturated)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond, "blocked for the write timeout")
+	assert.Equal(t, []string{`{"msg":"saturated"}`}, readLines(t, fallbackFile))
+
+	time.AfterFunc(20*time.Millisecond, func() { indexer.ack(0) })
+
+	start = time.Now()
+	require.NoError(t, write("unblocked"))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond, "blocked until an entry was flushed")
+
+	indexer.mu.Lock()
+	defer indexer.mu.Unlock()
+
+	assert.Equal(t, []string{`{"msg":"first"}`, `{"msg":"second"}`, `{"msg":"unblocked"}`}, indexer.bodies)
+}
+
+func TestOpenSearchBackpressureIgnoresFailedAdds(t *testing.T) {
+	indexer := &slowIndexer{timeouts: 2}
+	writer := newTestOpenSearchWriter(t,
+		WithBulkIndexerFactory(func(opensearchutil.BulkIndexerConfig) (opensearchutil.BulkIndexer, error) {
+			return indexer, nil
+		}),
+		WithOpenSearchBackpressure(1),
+		WithWriteTimeout(time.Second),
+	)
+
+	for range 2 {
+		_, err := writer.Write([]byte(`{"msg":"timed out"}`))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+
+	start := time.Now()
+	_, err := writer.Write([]byte(`{"msg":"added"}`))
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second, "timed out adds aren't pending")
+}
+
+func TestOpenSearchBackpressureFailedFlush(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	fake.SetBulkStatus(http.StatusInternalServerError)
+
+	writer := fake.newWriter(t,
+		WithOpenSearchBackpressure(1),
+		WithOpenSearchFlushInterval(10*time.Millisecond),
+		WithWriteTimeout(5*time.Second),
+	)
+
+	for i := range 3 {
+		start := time.Now()
+		_, err := writer.Write([]byte(fmt.Sprintf(`{"msg":"entry %d"}`, i)))
+		require.NoError(t, err)
+		assert.Less(t, time.Since(start), 5*time.Second, "entries of failed requests aren't pending")
+	}
+}
+
+func TestOpenSearchBackpressureDoesNotBlockFlush(t *testing.T) {
+	indexer := &slowIndexer{}
+	writer := newTestOpenSearchWriter(t,
+		WithBulkIndexerFactory(func(opensearchutil.BulkIndexerConfig) (opensearchutil.BulkIndexer, error) {
+			return indexer, nil
+		}),
+		WithOpenSearchBackpressure(1),
+		WithWriteTimeout(time.Second),
+	)
+
+	_, err := writer.Write([]byte(`{"msg":"pending"}`))
+	require.NoError(t, err)
+
+	blocked := make(chan error, 1)
+
+	go func() {
+		_, err := writer.Write([]byte(`{"msg":"blocked"}`))
+		blocked <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, writer.Flush(context.Background()))
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "the waiting write doesn't hold the writer")
+	require.NoError(t, <-blocked, "room made by the flush")
+}
+
+func TestFlushOnIdle(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithFlushOnIdle(100*time.Millisecond), WithOpenSearchFlushInterval(time.Hour))
+
+	for i := range 3 {
+		_, err := writer.Write([]byte(fmt.Sprintf(`{"msg":"burst %d"}`, i)))
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	assert.Empty(t, fake.Docs(), "every entry pushes the flush back")
+
+	require.Eventually(t, func() bool {
+		return len(fake.Docs()) == 3
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestOpenSearchMaxDocSize(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithOpenSearchMaxDocSize(1024))
+
+	stacktrace := strings.Repeat("main.handler\n\t/app/main.go:42\n", 1000)
+	entry, err := json.Marshal(map[string]interface{}{"msg": "panic", "stacktrace": stacktrace})
+	require.NoError(t, err)
+
+	_, err = writer.Write(entry)
+	require.NoError(t, err)
+
+	huge, err := json.Marshal(map[string]interface{}{"msg": "huge", "payload": strings.Repeat("x", 4096)})
+	require.NoError(t, err)
+
+	_, err = writer.Write(huge)
+	require.NoError(t, err, "oversized entries are dropped, not failed")
+
+	_, err = writer.Write([]byte(`{"msg":"small"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.Flush(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 2)
+
+	source := docs[0].Source
+	assert.Equal(t, "panic", source["msg"])
+
+	truncated := toString(source["stacktrace"])
+	assert.True(t, strings.HasPrefix(stacktrace, strings.TrimSuffix(truncated, truncatedMarker)))
+	assert.True(t, strings.HasSuffix(truncated, truncatedMarker))
+
+	encoded, err := json.Marshal(source)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(encoded), 1024)
+
+	assert.Equal(t, "small", docs[1].Source["msg"])
+	assert.Equal(t, uint64(1), writer.stats().Dropped)
+}
+
+func TestOpenSearchMaxIndexBytes(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	now := time.Now()
+	writer := fake.newWriter(t, WithOpenSearchMaxIndexBytes(100), WithClock(func() time.Time { return now }))
+
+	for i := 0; i < 6; i++ {
+		_, err := writer.Write([]byte(fmt.Sprintf(`{"msg":"entry","n":%d,"pad":"xxxxxxxx"}`, i)))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Flush(context.Background()))
+
+	index := "zlog-test-" + now.UTC().Format(string(DateFormatDot))
+	indices := make([]string, 0, 6)
+
+	for _, doc := range fake.Docs() {
+		indices = append(indices, doc.Index())
+	}
+
+	// the bulk workers ship in any order
+	sort.Strings(indices)
+
+	// entries are 40 bytes, two fit in an index
+	assert.Equal(t, []string{index, index, index + "-000001", index + "-000001", index + "-000002", index + "-000002"}, indices)
+}
+
+func TestIndexRotationOptions(t *testing.T) {
+	// 00:00 on Jan 2 in Tokyo
+	clock := WithClock(func() time.Time { return time.Date(2024, 1, 1, 15, 0, 0, 0, time.UTC) })
+
+	tests := []struct {
+		name string
+		opts []LogOptFunc
+		want string
+	}{
+		{
+			name: "defaults",
+			want: "zlog-test-2024.01.01",
+		},
+		{
+			name: "Tokyo",
+			opts: []LogOptFunc{WithTimeLocation(MustLoadLocation("Asia/Tokyo"))},
+			want: "zlog-test-2024.01.02",
+		},
+		{
+			name: "Tokyo with dash format",
+			opts: []LogOptFunc{WithTimeLocation(MustLoadLocation("Asia/Tokyo")), WithIndexDateFormat(DateFormatDash)},
+			want: "zlog-test-2024-01-02",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeOpenSearch(t)
+			config := fake.Config()
+
+			opts := append([]LogOptFunc{WithOpenSearchConfig(&config), WithOpenSearchIndex("zlog-test", ""), clock}, tt.opts...)
+			logger, flushFunc, err := NewZapLoggerWithOpenSearch(opts...)
+			require.NoError(t, err)
+
+			logger.Info("rotated")
+			require.NoError(t, flushFunc(context.Background()))
+
+			docs := fake.Docs()
+			require.Len(t, docs, 1)
+			assert.Equal(t, tt.want, docs[0].Index())
+		})
+	}
+}
+
+func TestInvalidIndexFormatWarning(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+	obs, logs := observer.New(zapcore.WarnLevel)
+
+	_, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", "yyyy-MM-dd"),
+		WithInternalLogger(zap.New(obs)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, flushFunc(context.Background()))
+
+	warnings := logs.FilterMessage("Index names won't rotate").All()
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0].ContextMap()["error"], "yyyy-MM-dd")
+}
+
+func TestCleanUpSyncs(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	original := stdout
+	t.Cleanup(func() { stdout = original })
+
+	out := &syncCounter{}
+	stdout = out
+
+	logger, cleanUp, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithConsole(true),
+	)
+	require.NoError(t, err)
+
+	logger.Info("synced")
+
+	require.NoError(t, cleanUp(context.Background()))
+	assert.Equal(t, 1, out.syncs)
+	assert.Len(t, fake.Docs(), 1)
+
+	// syncing a pipe fails with EINVAL, like /dev/stdout on some platforms
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		reader.Close()
+		writer.Close()
+	})
+
+	stdout = zapcore.AddSync(writer)
+
+	_, cleanUp, err = NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithConsole(true),
+	)
+	require.NoError(t, err)
+	assert.NoError(t, cleanUp(context.Background()))
+}
+
+func TestDropHook(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	var (
+		mu      sync.Mutex
+		dropped []zapcore.Entry
+	)
+
+	hook := WithDropHook(func(entry zapcore.Entry) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		dropped = append(dropped, entry)
+	})
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithSampling(time.Minute, 2, 0),
+		hook,
+	)
+	require.NoError(t, err)
+
+	for range 5 {
+		logger.Warn("storm")
+	}
+
+	require.NoError(t, flushFunc(context.Background()))
+	assert.Len(t, fake.Docs(), 2)
+
+	mu.Lock()
+	require.Len(t, dropped, 3, "sampled out")
+	assert.Equal(t, "storm", dropped[0].Message)
+	assert.Equal(t, zapcore.WarnLevel, dropped[0].Level)
+	dropped = nil
+	mu.Unlock()
+
+	writer := fake.newWriter(t, WithOpenSearchDocRateLimit(1), WithOpenSearchMaxDocSize(64), hook)
+
+	_, err = writer.Write([]byte(`{"level":"error","ts":"2024-01-25T12:00:00.000Z","logger":"api","msg":"huge","payload":"` + strings.Repeat("x", 100) + `"}`))
+	require.NoError(t, err)
+
+	for range 3 {
+		_, err = writer.Write([]byte(`{"level":"info","msg":"runaway"}`))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Flush(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, dropped, 3, "the oversized entry and two rate limited ones")
+	assert.Equal(t, zapcore.Entry{
+		Level:      zapcore.ErrorLevel,
+		Time:       time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC),
+		LoggerName: "api",
+		Message:    "huge",
+	}, dropped[0])
+	assert.Equal(t, "runaway", dropped[1].Message)
+	assert.Equal(t, uint64(3), writer.stats().Dropped)
+}
+
+func TestOpenSearchRefresh(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	for _, policy := range []string{"wait", "True", "immediate"} {
+		_, _, err := NewZapLoggerWithOpenSearch(
+			WithOpenSearchConfig(&config),
+			WithOpenSearchIndex("zlog-test", ""),
+			WithOpenSearchRefresh(policy),
+		)
+		require.ErrorIs(t, err, ErrInvalidRefreshPolicy, policy)
+	}
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchRefresh("wait_for"),
+	)
+	require.NoError(t, err)
+
+	logger.Info("searchable")
+	require.NoError(t, flushFunc(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+	assert.Equal(t, "wait_for", docs[0].Refresh)
+}
+
+func TestDedup(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithDedup(50*time.Millisecond, "user"))
+
+	write := func(entry string) {
+		_, err := writer.Write([]byte(entry))
+		require.NoError(t, err)
+	}
+
+	for range 100 {
+		write(`{"level":"error","msg":"boom","user":"alice"}`)
+	}
+
+	write(`{"level":"info","msg":"boom","user":"alice"}`)
+	write(`{"level":"error","msg":"boom","user":"bob"}`)
+
+	require.NoError(t, writer.Flush(context.Background()))
+	require.Len(t, fake.Docs(), 3, "the first of each distinct entry")
+
+	require.Eventually(t, func() bool {
+		require.NoError(t, writer.Flush(context.Background()))
+		return len(fake.Docs()) == 4
+	}, time.Second, 10*time.Millisecond)
+
+	var summaries []map[string]interface{}
+	for _, doc := range fake.Docs() {
+		if _, ok := doc.Source[repeatedKey]; ok {
+			summaries = append(summaries, doc.Source)
+		}
+	}
+
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "boom (repeated 99 times)", summaries[0]["msg"])
+	assert.Equal(t, "alice", summaries[0]["user"])
+	assert.EqualValues(t, 99, summaries[0][repeatedKey])
+
+	// a closed window starts over
+	write(`{"level":"error","msg":"boom","user":"alice"}`)
+	write(`{"level":"error","msg":"boom","user":"alice"}`)
+	write(`{"level":"error","msg":"boom","user":"alice"}`)
+
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 6, "the pending summary is shipped on close")
+	assert.True(t, slices.ContainsFunc(docs[4:], func(doc fakeDoc) bool {
+		return doc.Source["msg"] == "boom (repeated 2 times)"
+	}))
+}
+
+func TestDedupEviction(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithDedup(time.Hour),
+	)
+	require.NoError(t, err)
+
+	const distinct = dedupMaxEntries + 1
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := range distinct {
+			logger.Info(fmt.Sprintf("entry %d", i))
+			logger.Info(fmt.Sprintf("entry %d", i))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("logging blocked once the oldest window was evicted")
+	}
+
+	require.NoError(t, flushFunc(context.Background()))
+
+	var summaries int
+
+	for _, doc := range fake.Docs() {
+		if _, ok := doc.Source[repeatedKey]; ok {
+			assert.Regexp(t, `^entry \d+ \(repeated 1 times\)$`, doc.Source["msg"])
+			summaries++
+		}
+	}
+
+	assert.Equal(t, distinct, summaries, "the evicted window and the open ones on CleanUp")
+	assert.Len(t, fake.Docs(), 2*distinct)
+}
+
+func TestIsOpenSearchReadyWithConfig(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		status = "red"
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `{"version":{"number":"2.11.0"}}`)
+		case "/_cluster/health":
+			assert.Equal(t, "yellow", r.URL.Query().Get("wait_for_status"))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			fmt.Fprintf(w, `{"status":%q}`, status)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	assert.False(t, IsOpenSearchReady(server.URL, time.Second, false), "unauthenticated")
+	assert.False(t, IsOpenSearchReadyWithConfig(opensearch.Config{Addresses: []string{server.URL}}, time.Second, ""))
+
+	config := opensearch.Config{Addresses: []string{server.URL}, Username: "admin", Password: "secret"}
+	assert.True(t, IsOpenSearchReadyWithConfig(config, time.Second, ""))
+	assert.False(t, IsOpenSearchReadyWithConfig(config, time.Second, "yellow"), "still red")
+
+	mu.Lock()
+	status = "green"
+	mu.Unlock()
+
+	assert.True(t, IsOpenSearchReadyWithConfig(config, time.Second, "yellow"))
+	assert.False(t, IsOpenSearchReadyWithConfig(config, time.Second, "orange"))
+}
+
+func TestTenantField(t *testing.T) {
+	now := time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC)
+	obs, logs := observer.New(zapcore.WarnLevel)
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t,
+		WithTenantField("tenant_id", "shared", 3),
+		WithInternalLogger(zap.New(obs)),
+		WithClock(func() time.Time { return now }),
+	)
+
+	for _, entry := range []string{
+		`{"msg":"present","tenant_id":"acme"}`,
+		`{"msg":"missing"}`,
+		`{"msg":"empty","tenant_id":""}`,
+		`{"msg":"sanitized","tenant_id":"Globex Corp"}`,
+		`{"msg":"long","tenant_id":"` + strings.Repeat("x", 40) + `"}`,
+		`{"msg":"capped","tenant_id":"initech"}`,
+		`{"msg":"known","tenant_id":"acme"}`,
+	} {
+		_, err := writer.Write([]byte(entry))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Flush(context.Background()))
+
+	indices := map[string]string{}
+	for _, doc := range fake.Docs() {
+		indices[toString(doc.Source["msg"])] = doc.Index()
+	}
+
+	long := indices["long"]
+	delete(indices, "long")
+
+	assert.Equal(t, map[string]string{
+		"present":   "zlog-test-acme-2024.01.25",
+		"missing":   "zlog-test-shared-2024.01.25",
+		"empty":     "zlog-test-shared-2024.01.25",
+		"sanitized": "zlog-test-globex-corp-2024.01.25",
+		"capped":    "zlog-test-shared-2024.01.25",
+		"known":     "zlog-test-acme-2024.01.25",
+	}, indices)
+	assert.Regexp(t, `^zlog-test-[0-9a-f]{1,16}-2024\.01\.25$`, long, "hashed")
+
+	warnings := logs.FilterMessageSnippet("Too many tenants").All()
+	require.Len(t, warnings, 1)
+	assert.Equal(t, "initech", warnings[0].ContextMap()["tenant"])
+}
+
+func TestOpenSearchEncoderKeys(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithMessageKey("@message"),
+		WithLevelKey("@level"),
+		WithTimeKey("@timestamp"),
+		WithNameKey("service"),
+		WithCallerKey("source"),
+		WithRequiredFields("@message", "@timestamp"),
+	)
+	require.NoError(t, err)
+
+	logger.Named("api").Warn("renamed")
+	require.NoError(t, flushFunc(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+
+	source := docs[0].Source
+	assert.Equal(t, "renamed", source["@message"])
+	assert.Equal(t, "warn", source["@level"])
+	assert.Equal(t, "api", source["service"])
+	assert.Contains(t, source["source"], "opensearch_test.go")
+	assert.Contains(t, source, "@timestamp")
+
+	for _, key := range []string{"msg", "level", "ts", "logger", "caller"} {
+		assert.NotContains(t, source, key)
+	}
+}
@@ -0,0 +1,82 @@
+package zlog
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitPolicy selects what happens to entries exceeding WithOpenSearchRateLimit.
+type RateLimitPolicy int
+
+const (
+	// RateLimitDrop drops the excess entries; they are counted in IndexerStats.Dropped.
+	RateLimitDrop RateLimitPolicy = iota
+	// RateLimitWait blocks the logging goroutine until the entry fits in the
+	// budget, for up to the write timeout, see WithWriteTimeout, and drops it
+	// after that. The wait holds the OpenSearch output, so every goroutine
+	// logging meanwhile waits in line: the entries are shipped one at a time.
+	RateLimitWait
+)
+
+// rateLimit caps the bytes per second handed to the bulk indexers. It's shared
+// by all writers of a logger, so the cap applies to the service as a whole.
+type rateLimit struct {
+	limiter *rate.Limiter
+	policy  RateLimitPolicy
+}
+
+// rateLimit returns the limit of the logger, creating it on first use, or nil
+// without WithOpenSearchRateLimit.
+func (o *LogOpts) rateLimit() *rateLimit {
+	if o.openSearchRateLimit <= 0 {
+		return nil
+	}
+
+	if o.openSearchRateLimiter == nil {
+		burst := o.openSearchRateBurst
+		if burst <= 0 {
+			burst = o.openSearchRateLimit
+		}
+
+		// entries larger than the burst would never be shipped
+		burst = max(burst, int64(o.openSearchMaxDocSize))
+
+		o.openSearchRateLimiter = &rateLimit{
+			limiter: rate.NewLimiter(rate.Limit(o.openSearchRateLimit), int(burst)),
+			policy:  o.openSearchRatePolicy,
+		}
+	}
+
+	return o.openSearchRateLimiter
+}
+
+// allow reports whether an entry of n bytes may be shipped. Entries larger than
+// the burst never are.
+func (l *rateLimit) allow(ctx context.Context, n int) bool {
+	if l == nil {
+		return true
+	}
+
+	if l.policy == RateLimitWait {
+		return l.limiter.WaitN(ctx, n) == nil
+	}
+
+	return l.limiter.AllowN(time.Now(), n)
+}
+
+// docRateLimit returns the limiter of WithOpenSearchDocRateLimit, creating it on
+// first use so all writers of the logger share it, or nil without the option.
+// Bursts of up to one second's worth of documents are allowed.
+func (o *LogOpts) docRateLimit() *rate.Limiter {
+	if o.openSearchDocRateLimit <= 0 {
+		return nil
+	}
+
+	if o.openSearchDocRateLimiter == nil {
+		o.openSearchDocRateLimiter = rate.NewLimiter(rate.Limit(o.openSearchDocRateLimit), o.openSearchDocRateLimit)
+	}
+
+	return o.openSearchDocRateLimiter
+}
@@ -0,0 +1,77 @@
+package zlog
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// JitterMode selects how retry backoffs are randomized, so items failing together
+// don't hit the recovering cluster in sync.
+type JitterMode int
+
+const (
+	// JitterNone waits the exact exponential backoff.
+	JitterNone JitterMode = iota
+	// JitterFull waits a random duration between zero and the backoff.
+	JitterFull
+	// JitterEqual waits half the backoff plus a random duration up to the other half.
+	JitterEqual
+)
+
+const (
+	defaultRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff     = time.Minute
+)
+
+// retryPolicy decides whether and when a failed bulk item is retried.
+type retryPolicy struct {
+	maxRetries     int
+	initialBackoff time.Duration
+	jitter         JitterMode
+}
+
+// retriable reports whether an item failing with status after attempt retries
+// should be retried. A nil policy never retries.
+func (p *retryPolicy) retriable(status, attempt int) bool {
+	if p == nil || attempt >= p.maxRetries {
+		return false
+	}
+
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns the delay before the given retry, doubling with every attempt
+// up to maxRetryBackoff, randomized according to the jitter mode.
+func (p *retryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.baseBackoff(attempt)
+
+	switch p.jitter {
+	case JitterFull:
+		return rand.N(backoff + 1)
+	case JitterEqual:
+		half := backoff / 2 //nolint:mnd
+		return backoff - half + rand.N(half+1)
+	default:
+		return backoff
+	}
+}
+
+// baseBackoff returns the backoff before the given retry without jitter.
+func (p *retryPolicy) baseBackoff(attempt int) time.Duration {
+	backoff := p.initialBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, maxRetryBackoff)
+}
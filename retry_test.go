@@ -0,0 +1,115 @@
+package zlog
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	var none *retryPolicy
+	assert.False(t, none.retriable(http.StatusTooManyRequests, 0))
+
+	policy := &retryPolicy{maxRetries: 2, initialBackoff: 10 * time.Millisecond}
+	assert.True(t, policy.retriable(http.StatusTooManyRequests, 0))
+	assert.True(t, policy.retriable(http.StatusServiceUnavailable, 1))
+	assert.False(t, policy.retriable(http.StatusServiceUnavailable, 2), "retries exhausted")
+	assert.False(t, policy.retriable(http.StatusBadRequest, 0), "not transient")
+	assert.False(t, policy.retriable(0, 0), "refused by the indexer")
+
+	assert.Equal(t, 10*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 20*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 40*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, maxRetryBackoff, policy.backoff(100))
+	assert.Equal(t, defaultRetryBackoff, (&retryPolicy{maxRetries: 1}).backoff(1))
+}
+
+func TestRetryJitter(t *testing.T) {
+	const base = 80 * time.Millisecond
+
+	tests := []struct {
+		mode     JitterMode
+		min, max time.Duration
+	}{
+		{mode: JitterFull, min: 0, max: base},
+		{mode: JitterEqual, min: base / 2, max: base},
+	}
+
+	for _, tt := range tests {
+		policy := &retryPolicy{maxRetries: 5, initialBackoff: base / 4, jitter: tt.mode}
+
+		seen := map[time.Duration]bool{}
+
+		for range 100 {
+			backoff := policy.backoff(3)
+			assert.GreaterOrEqual(t, backoff, tt.min)
+			assert.LessOrEqual(t, backoff, tt.max)
+
+			seen[backoff] = true
+		}
+
+		assert.Greater(t, len(seen), 1, "delays vary for mode %d", tt.mode)
+	}
+}
+
+func TestOpenSearchRetry(t *testing.T) {
+	fallbackFile := filepath.Join(t.TempDir(), "fallback.log")
+
+	var (
+		mu       sync.Mutex
+		attempts = map[string]int{}
+	)
+
+	fake := newFakeOpenSearch(t)
+	fake.SetItemStatus(func(doc fakeDoc) int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		msg := toString(doc.Source["msg"])
+		attempts[msg]++
+
+		switch {
+		case msg == "broken":
+			return http.StatusServiceUnavailable
+		case msg == "flaky" && attempts[msg] <= 2:
+			return http.StatusTooManyRequests
+		default:
+			return http.StatusCreated
+		}
+	})
+
+	writer := fake.newWriter(t,
+		WithOpenSearchRetry(2, time.Millisecond),
+		WithOpenSearchFallback(fallbackFile),
+	)
+
+	for _, msg := range []string{"flaky", "broken"} {
+		_, err := writer.Write([]byte(`{"msg":"` + msg + `"}`))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		require.NoError(t, writer.Flush(context.Background()))
+
+		_, err := os.Stat(fallbackFile)
+
+		return len(fake.Docs()) == 1 && writer.stats().Retried == 4 && err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+
+	assert.Equal(t, "flaky", fake.Docs()[0].Source["msg"])
+	assert.Equal(t, []string{`{"msg":"broken"}`}, readLines(t, fallbackFile))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, map[string]int{"flaky": 3, "broken": 3}, attempts)
+}
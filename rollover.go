@@ -0,0 +1,165 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"go.uber.org/zap"
+)
+
+const (
+	rolloverInterval = time.Minute
+	rolloverTimeout  = 10 * time.Second
+)
+
+// RolloverConditions are the conditions of the rollover API, see
+// WithManagedRollover. The write index is rolled over once any of them is met;
+// zero values are left out.
+type RolloverConditions struct {
+	// e.g. "7d"
+	MaxAge  string
+	MaxDocs int64
+	// e.g. "50gb"
+	MaxSize string
+	// how often the conditions are checked, every minute by default
+	Interval time.Duration
+}
+
+func (c RolloverConditions) body() map[string]interface{} {
+	conditions := map[string]interface{}{}
+
+	if c.MaxAge != "" {
+		conditions["max_age"] = c.MaxAge
+	}
+
+	if c.MaxDocs > 0 {
+		conditions["max_docs"] = c.MaxDocs
+	}
+
+	if c.MaxSize != "" {
+		conditions["max_size"] = c.MaxSize
+	}
+
+	return map[string]interface{}{"conditions": conditions}
+}
+
+func (c RolloverConditions) empty() bool {
+	return c.MaxAge == "" && c.MaxDocs <= 0 && c.MaxSize == ""
+}
+
+func (c RolloverConditions) interval() time.Duration {
+	if c.Interval <= 0 {
+		return rolloverInterval
+	}
+
+	return c.Interval
+}
+
+// ensureRolloverAlias bootstraps the write alias of WithManagedRollover: unless
+// it exists, the first index, "<alias>-000001", is created as its write index.
+func (o *LogOpts) ensureRolloverAlias(client *opensearch.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rolloverTimeout)
+	defer cancel()
+
+	alias := o.rolloverAlias
+
+	res, err := client.Indices.ExistsAlias([]string{alias}, client.Indices.ExistsAlias.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to check rollover alias: %w", err)
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+	default:
+		return fmt.Errorf("failed to check rollover alias: %s", res.Status())
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"aliases": map[string]interface{}{alias: map[string]interface{}{"is_write_index": true}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode rollover index: %w", err)
+	}
+
+	index := alias + "-000001"
+
+	res, err = client.Indices.Create(index, client.Indices.Create.WithBody(bytes.NewReader(body)),
+		client.Indices.Create.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to create rollover index: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to create rollover index: %s", res.String())
+	}
+
+	o.internalLogger.Info("Rollover index created", zap.String("alias", alias), zap.String("index", index))
+
+	return nil
+}
+
+// rollover asks the cluster to roll the write index of alias over if the
+// conditions are met.
+func rollover(client *opensearch.Client, alias string, conditions RolloverConditions, logger *zap.Logger) error {
+	body, err := json.Marshal(conditions.body())
+	if err != nil {
+		return fmt.Errorf("failed to encode rollover conditions: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rolloverTimeout)
+	defer cancel()
+
+	res, err := client.Indices.Rollover(alias, client.Indices.Rollover.WithBody(bytes.NewReader(body)),
+		client.Indices.Rollover.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to roll over: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to roll over: %s", res.String())
+	}
+
+	var result struct {
+		OldIndex   string `json:"old_index"`
+		NewIndex   string `json:"new_index"`
+		RolledOver bool   `json:"rolled_over"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode rollover response: %w", err)
+	}
+
+	if result.RolledOver {
+		logger.Info("Index rolled over", zap.String("alias", alias),
+			zap.String("old_index", result.OldIndex), zap.String("new_index", result.NewIndex))
+	}
+
+	return nil
+}
+
+// runRollover checks the rollover conditions every interval until stop is closed.
+func runRollover(client *opensearch.Client, alias string, conditions RolloverConditions, logger *zap.Logger, stop <-chan struct{}) {
+	ticker := time.NewTicker(conditions.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := rollover(client, alias, conditions, logger); err != nil {
+				logger.Warn("Rollover failed", zap.String("alias", alias), zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package zlog
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// iso8601Layout is the layout of zapcore.ISO8601TimeEncoder.
+	iso8601Layout = "2006-01-02T15:04:05.000Z0700"
+	// skewWarningInterval rate limits the timestamp skew warning.
+	skewWarningInterval = time.Minute
+)
+
+// skewDetector warns on the internal logger about entries whose timestamp is
+// further than tolerance from the wall clock, hinting at clock drift or bad
+// timestamps. It's used under the writer's mutex.
+type skewDetector struct {
+	tolerance time.Duration
+	logger    *zap.Logger
+	clock     func() time.Time
+
+	lastWarning time.Time
+	// skewed entries since the last warning
+	suppressed int
+}
+
+// check inspects the encoded timestamp of an entry.
+func (d *skewDetector) check(timestamp interface{}) {
+	ts, ok := entryTime(timestamp)
+	if !ok {
+		return
+	}
+
+	now := d.clock()
+
+	skew := ts.Sub(now)
+	if skew.Abs() <= d.tolerance {
+		return
+	}
+
+	if now.Sub(d.lastWarning) < skewWarningInterval {
+		d.suppressed++
+		return
+	}
+
+	d.logger.Warn("Log entry timestamp is out of the tolerated range",
+		zap.Any("timestamp", timestamp),
+		zap.Duration("skew", skew),
+		zap.Duration("tolerance", d.tolerance),
+		zap.Int("suppressed", d.suppressed))
+
+	d.lastWarning = now
+	d.suppressed = 0
+}
+
+// entryTime parses the encoded timestamp of an entry.
+func entryTime(timestamp interface{}) (time.Time, bool) {
+	encoded, ok := timestamp.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	ts, err := time.Parse(iso8601Layout, encoded)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return ts, true
+}
@@ -0,0 +1,45 @@
+package zlog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestTimestampSkewTolerance(t *testing.T) {
+	now := time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC)
+
+	obs, logs := observer.New(zapcore.WarnLevel)
+	fake := newFakeOpenSearch(t)
+	writer := fake.newWriter(t, WithTimestampSkewTolerance(time.Minute), WithInternalLogger(zap.New(obs)),
+		WithClock(func() time.Time { return now }))
+
+	write := func(ts time.Time) {
+		_, err := writer.Write([]byte(`{"ts":"` + ts.Format(iso8601Layout) + `","msg":"hello"}`))
+		require.NoError(t, err)
+	}
+
+	write(now.Add(30 * time.Second))
+	assert.Zero(t, logs.Len(), "within tolerance")
+
+	write(now.Add(time.Hour))
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, time.Hour, logs.All()[0].ContextMap()["skew"])
+
+	write(now.Add(-time.Hour))
+	assert.Equal(t, 1, logs.Len(), "rate limited")
+
+	now = now.Add(2 * skewWarningInterval)
+	write(now.Add(-time.Hour))
+	require.Equal(t, 2, logs.Len())
+	assert.Equal(t, int64(1), logs.All()[1].ContextMap()["suppressed"])
+
+	require.NoError(t, writer.FlushWithContext(context.Background()))
+	assert.Len(t, fake.Docs(), 4, "skewed entries are still shipped")
+}
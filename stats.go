@@ -0,0 +1,157 @@
+package zlog
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/opensearchutil"
+	"go.uber.org/zap"
+)
+
+const statsReportMessage = "OpenSearch indexer stats"
+
+// Metrics is notified of the outcome of the log entries shipped to OpenSearch,
+// see WithMetrics. Implementations must be safe for concurrent use; the zlogprom
+// package provides one backed by Prometheus counters.
+type Metrics interface {
+	// IncAdded is called for every entry handed to the bulk indexer, retries included.
+	IncAdded()
+	// IncFlushed is called for every entry OpenSearch accepted.
+	IncFlushed()
+	// IncFailed is called for every entry OpenSearch or the bulk indexer rejected,
+	// including the entries of bulk requests failing as a whole, once they end.
+	IncFailed()
+}
+
+// failureReporter reports the entries a bulk indexer counts as failed to Metrics.
+// Only its stats include the entries of the bulk requests failing as a whole,
+// which don't call the callbacks of their items.
+type failureReporter struct {
+	metrics Metrics
+
+	mu sync.Mutex
+	// nil until the indexer is created, while its workers may already flush
+	indexer  opensearchutil.BulkIndexer
+	reported uint64
+}
+
+func (r *failureReporter) bind(indexer opensearchutil.BulkIndexer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.indexer = indexer
+}
+
+// report calls IncFailed for the failures counted since the last report.
+func (r *failureReporter) report() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.indexer == nil {
+		return
+	}
+
+	for failed := r.indexer.Stats().NumFailed; r.reported < failed; r.reported++ {
+		r.metrics.IncFailed()
+	}
+}
+
+// reportingIndexer reports the failures left once the bulk indexer is closed.
+type reportingIndexer struct {
+	opensearchutil.BulkIndexer
+	failures *failureReporter
+}
+
+func (i *reportingIndexer) Close(ctx context.Context) error {
+	err := i.BulkIndexer.Close(ctx)
+	i.failures.report()
+
+	return err
+}
+
+// IndexerStats is a snapshot of the counters of the OpenSearch output of a logger.
+type IndexerStats struct {
+	// entries handed to the bulk indexer, retries included
+	Added   uint64
+	Flushed uint64
+	Failed  uint64
+	// entries that never reached the bulk indexer nor the fallback file
+	Dropped uint64
+	// failed items handed to the bulk indexer again
+	Retried uint64
+	// request body sizes with WithOpenSearchCompression
+	UncompressedBytes uint64
+	CompressedBytes   uint64
+}
+
+// CompressionRatio returns the compressed size of the requests relative to their
+// uncompressed size, 0 without compression.
+func (s IndexerStats) CompressionRatio() float64 {
+	if s.UncompressedBytes == 0 {
+		return 0
+	}
+
+	return float64(s.CompressedBytes) / float64(s.UncompressedBytes)
+}
+
+// add returns s plus the counters of a bulk indexer.
+func (s IndexerStats) add(stats opensearchutil.BulkIndexerStats) IndexerStats {
+	s.Added += stats.NumAdded
+	s.Flushed += stats.NumFlushed
+	s.Failed += stats.NumFailed
+
+	return s
+}
+
+// merge returns the sum of s and other.
+func (s IndexerStats) merge(other IndexerStats) IndexerStats {
+	s.Added += other.Added
+	s.Flushed += other.Flushed
+	s.Failed += other.Failed
+	s.Dropped += other.Dropped
+	s.Retried += other.Retried
+	s.UncompressedBytes += other.UncompressedBytes
+	s.CompressedBytes += other.CompressedBytes
+
+	return s
+}
+
+// withCompression returns s plus the sizes counted by counter.
+func (s IndexerStats) withCompression(counter *compressionCounter) IndexerStats {
+	s.UncompressedBytes += counter.uncompressed.Load()
+	s.CompressedBytes += counter.compressed.Load()
+
+	return s
+}
+
+func (s IndexerStats) fields() []zap.Field {
+	fields := []zap.Field{
+		zap.Uint64("added", s.Added),
+		zap.Uint64("flushed", s.Flushed),
+		zap.Uint64("failed", s.Failed),
+		zap.Uint64("dropped", s.Dropped),
+		zap.Uint64("retried", s.Retried),
+	}
+
+	if s.UncompressedBytes > 0 {
+		fields = append(fields, zap.Float64("compression_ratio", s.CompressionRatio()))
+	}
+
+	return fields
+}
+
+// reportStats logs the result of stats every interval until stop is closed.
+func reportStats(logger *zap.Logger, interval time.Duration, stats func() IndexerStats, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			logger.Info(statsReportMessage, stats().fields()...)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package zlog
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// facility of the entries, "user-level messages"
+	syslogFacilityUser = 1
+	syslogDialTimeout  = 5 * time.Second
+	// RFC 5424 allows at most microseconds
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// syslogSeverities maps the levels to the RFC 5424 severities.
+var syslogSeverities = map[zapcore.Level]int{
+	zapcore.DebugLevel:  7, // debug
+	zapcore.InfoLevel:   6, // informational
+	zapcore.WarnLevel:   4, // warning
+	zapcore.ErrorLevel:  3, // error
+	zapcore.DPanicLevel: 2, // critical
+	zapcore.PanicLevel:  2, // critical
+	zapcore.FatalLevel:  1, // alert
+}
+
+type syslogConfig struct {
+	network string
+	addr    string
+	tag     string
+}
+
+// syslogCore sends entries as RFC 5424 messages, with the JSON encoded entry as
+// the message, to a syslog server. TCP messages are framed by octet counting,
+// see RFC 6587.
+type syslogCore struct {
+	zapcore.LevelEnabler
+	enc  zapcore.Encoder
+	conn *syslogConn
+}
+
+func newSyslogCore(opt *LogOpts) *syslogCore {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &syslogCore{
+		LevelEnabler: opt.levelEnabler(),
+		enc:          genJSONEncoder(),
+		conn:         &syslogConn{config: *opt.syslog, hostname: hostname, pid: os.Getpid()},
+	}
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+
+	return &syslogCore{LevelEnabler: c.LevelEnabler, enc: enc, conn: c.conn}
+}
+
+func (c *syslogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *syslogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	return c.conn.send(ent, buf.Bytes())
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
+
+// syslogConn is the connection to the syslog server, shared by the cores
+// derived with With. It's dialed on first use and again after a failed write.
+type syslogConn struct {
+	mu       sync.Mutex
+	config   syslogConfig
+	hostname string
+	pid      int
+	conn     net.Conn
+}
+
+// send writes one message, redialing once if the connection broke.
+func (s *syslogConn) send(ent zapcore.Entry, msg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	packet := s.format(ent, msg)
+
+	var err error
+
+	for range 2 {
+		if s.conn == nil {
+			s.conn, err = net.DialTimeout(s.config.network, s.config.addr, syslogDialTimeout)
+			if err != nil {
+				return fmt.Errorf("failed to connect to syslog: %w", err)
+			}
+		}
+
+		if _, err = s.conn.Write(packet); err == nil {
+			return nil
+		}
+
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return fmt.Errorf("failed to write to syslog: %w", err)
+}
+
+// format builds the RFC 5424 message for an entry, without structured data.
+func (s *syslogConn) format(ent zapcore.Entry, msg []byte) []byte {
+	if n := len(msg); n > 0 && msg[n-1] == '\n' {
+		msg = msg[:n-1]
+	}
+
+	severity, ok := syslogSeverities[ent.Level]
+	if !ok {
+		severity = syslogSeverities[zapcore.InfoLevel]
+	}
+
+	header := fmt.Sprintf("<%d>1 %s %s %s %d - - ", syslogFacilityUser*8+severity,
+		ent.Time.Format(syslogTimeFormat), s.hostname, s.config.tag, s.pid)
+
+	message := append([]byte(header), msg...)
+
+	if s.config.network == "udp" || s.config.network == "udp4" || s.config.network == "udp6" ||
+		s.config.network == "unixgram" {
+		return message
+	}
+
+	return append([]byte(strconv.Itoa(len(message))+" "), message...)
+}
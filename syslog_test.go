@@ -0,0 +1,122 @@
+package zlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var syslogPattern = regexp.MustCompile(`^<(\d+)>1 (\S+) (\S+) (\S+) (\d+) - - (.*)$`)
+
+// parseSyslog checks an RFC 5424 message and returns its priority, app name and
+// decoded JSON message.
+func parseSyslog(t *testing.T, message string) (int, string, map[string]interface{}) {
+	t.Helper()
+
+	parts := syslogPattern.FindStringSubmatch(message)
+	require.NotNil(t, parts, message)
+
+	_, err := time.Parse(time.RFC3339Nano, parts[2])
+	require.NoError(t, err)
+
+	priority, err := strconv.Atoi(parts[1])
+	require.NoError(t, err)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(parts[6]), &entry))
+
+	return priority, parts[4], entry
+}
+
+func TestSyslogUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	logger, err := NewZapLogger(WithLJ(false), WithConsole(false), WithSyslog("udp", listener.LocalAddr().String(), "billing"))
+	require.NoError(t, err)
+
+	logger.With(zap.String("component", "disk")).Warn("disk low", zap.Int("free_gb", 5))
+
+	require.NoError(t, listener.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	packet := make([]byte, 4096)
+	n, _, err := listener.ReadFrom(packet)
+	require.NoError(t, err)
+
+	priority, tag, entry := parseSyslog(t, string(packet[:n]))
+	assert.Equal(t, 1*8+4, priority, "user facility, warning severity")
+	assert.Equal(t, "billing", tag)
+	assert.Equal(t, "disk low", entry["msg"])
+	assert.Equal(t, "disk", entry["component"])
+	assert.InDelta(t, 5, entry["free_gb"], 0)
+}
+
+func TestSyslogTCPReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	conns := make(chan net.Conn, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			conns <- conn
+		}
+	}()
+
+	logger, err := NewZapLogger(WithLJ(false), WithConsole(false), WithSyslog("tcp", listener.Addr().String(), "billing"))
+	require.NoError(t, err)
+
+	logger.Error("first")
+
+	first := <-conns
+	reader := bufio.NewReader(first)
+
+	length, err := reader.ReadString(' ')
+	require.NoError(t, err)
+
+	size, err := strconv.Atoi(strings.TrimSpace(length))
+	require.NoError(t, err)
+
+	message := make([]byte, size)
+	_, err = io.ReadFull(reader, message)
+	require.NoError(t, err)
+
+	priority, _, entry := parseSyslog(t, string(message))
+	assert.Equal(t, 1*8+3, priority)
+	assert.Equal(t, "first", entry["msg"])
+
+	// the server drops the connection, writes fail until the logger redials
+	first.Close()
+
+	var second net.Conn
+
+	require.Eventually(t, func() bool {
+		logger.Info("again")
+
+		select {
+		case second = <-conns:
+			return true
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	second.Close()
+}
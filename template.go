@@ -0,0 +1,64 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"go.uber.org/zap"
+)
+
+const indexTemplateTimeout = 10 * time.Second
+
+// ensureIndexTemplate creates the index template set with WithIndexTemplate, so
+// the mappings are in place before the first entry creates a new dated index.
+// An existing template is kept unless WithIndexTemplateForce is set.
+func (o *LogOpts) ensureIndexTemplate(client *opensearch.Client) error {
+	if o.indexTemplateName == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), indexTemplateTimeout)
+	defer cancel()
+
+	if !o.indexTemplateForce {
+		res, err := client.Indices.ExistsIndexTemplate(o.indexTemplateName,
+			client.Indices.ExistsIndexTemplate.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("failed to check index template: %w", err)
+		}
+		res.Body.Close()
+
+		switch res.StatusCode {
+		case http.StatusOK:
+			return nil
+		case http.StatusNotFound:
+		default:
+			return fmt.Errorf("failed to check index template: %s", res.Status())
+		}
+	}
+
+	body, err := json.Marshal(o.indexTemplateBody)
+	if err != nil {
+		return fmt.Errorf("failed to encode index template: %w", err)
+	}
+
+	res, err := client.Indices.PutIndexTemplate(o.indexTemplateName, bytes.NewReader(body),
+		client.Indices.PutIndexTemplate.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to put index template: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to put index template: %s", res.String())
+	}
+
+	o.internalLogger.Info("Index template created", zap.String("name", o.indexTemplateName))
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package zlog
+
+import (
+	"hash/fnv"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultTenant names the index of the entries without tenant.
+	defaultTenant = "default"
+	// defaultMaxTenants bounds the tenant indices unless set with WithTenantField.
+	defaultMaxTenants = 100
+	// tenantMaxLen is the length beyond which tenant values are hashed.
+	tenantMaxLen = 32
+)
+
+// tenantRouter picks the tenant component of the index name of an entry, see
+// WithTenantField. It's used under the writer's mutex.
+type tenantRouter struct {
+	key           string
+	defaultTenant string
+	maxTenants    int
+	logger        *zap.Logger
+
+	known map[string]struct{}
+	// the cap was reported
+	capped bool
+}
+
+func newTenantRouter(key, fallback string, maxTenants int, logger *zap.Logger) *tenantRouter {
+	fallback = sanitizeIndexName(fallback)
+	if fallback == "" {
+		fallback = defaultTenant
+	}
+
+	if maxTenants <= 0 {
+		maxTenants = defaultMaxTenants
+	}
+
+	return &tenantRouter{
+		key:           key,
+		defaultTenant: fallback,
+		maxTenants:    maxTenants,
+		logger:        logger,
+		known:         make(map[string]struct{}),
+	}
+}
+
+// tenant returns the tenant of entry, made valid for index names. Values too
+// long for an index name are hashed, and the tenants beyond the cap share the
+// default tenant's index.
+func (r *tenantRouter) tenant(entry map[string]interface{}) string {
+	value, ok := entry[r.key]
+	if !ok || value == nil {
+		return r.defaultTenant
+	}
+
+	tenant := sanitizeIndexName(toString(value))
+	if tenant == "" {
+		return r.defaultTenant
+	}
+
+	if len(tenant) > tenantMaxLen {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(tenant))
+		tenant = strconv.FormatUint(hash.Sum64(), 16)
+	}
+
+	if _, ok := r.known[tenant]; ok {
+		return tenant
+	}
+
+	if len(r.known) >= r.maxTenants {
+		if !r.capped {
+			r.capped = true
+			r.logger.Warn("Too many tenants, shipping the entries of new ones to the default tenant index",
+				zap.Int("max_tenants", r.maxTenants), zap.String("tenant", tenant))
+		}
+
+		return r.defaultTenant
+	}
+
+	r.known[tenant] = struct{}{}
+
+	return tenant
+}
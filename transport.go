@@ -0,0 +1,324 @@
+package zlog
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"go.uber.org/zap"
+)
+
+// openSearchClient returns the client for the configured cluster, creating it on
+// first use, so cores shipping to the same cluster share one client and
+// connection pool. Clients are cached by config.
+func (o *LogOpts) openSearchClient() (*opensearch.Client, error) {
+	if client, ok := o.openSearchClients[o.openSearchConfig]; ok {
+		return client, nil
+	}
+
+	clientConfig, err := o.openSearchClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure OpenSearch client: %w", err)
+	}
+
+	client, err := opensearch.NewClient(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OpenSearch client: %w", err)
+	}
+
+	if o.openSearchClients == nil {
+		o.openSearchClients = make(map[*opensearch.Config]*opensearch.Client)
+	}
+
+	o.openSearchClients[o.openSearchConfig] = client
+
+	return client, nil
+}
+
+// openSearchClientConfig returns a copy of the configured opensearch.Config with
+// the client related options applied. The caller's config is never modified.
+// The result holds credentials and must never be logged.
+func (o *LogOpts) openSearchClientConfig() (opensearch.Config, error) {
+	config := *o.openSearchConfig
+
+	if o.openSearchAddresses != nil {
+		if err := validateAddresses(o.openSearchAddresses); err != nil {
+			return config, err
+		}
+
+		config.Addresses = o.openSearchAddresses
+	}
+
+	if o.openSearchUsername != "" {
+		config.Username = o.openSearchUsername
+		config.Password = o.openSearchPassword
+	}
+
+	if o.openSearchAPIKey != "" {
+		config.Header = config.Header.Clone()
+		if config.Header == nil {
+			config.Header = http.Header{}
+		}
+
+		config.Header.Set("Authorization", "ApiKey "+o.openSearchAPIKey)
+	}
+
+	if o.openSearchSigner != nil {
+		config.Signer = o.openSearchSigner
+	}
+
+	configureTLS := o.openSearchInsecure || o.openSearchTLS != nil
+
+	switch {
+	case o.openSearchTransport != nil:
+		if o.openSearchTLS != nil {
+			return config, ErrTLSWithCustomTransport
+		}
+
+		if o.openSearchProxy != "" {
+			return config, ErrProxyWithCustomTransport
+		}
+
+		config.Transport = o.openSearchTransport
+	case configureTLS || o.openSearchProxy != "":
+		transport := cloneHTTPTransport(config.Transport)
+		if transport == nil {
+			if !configureTLS {
+				return config, ErrProxyRequiresHTTPTransport
+			}
+
+			return config, ErrTLSRequiresHTTPTransport
+		}
+
+		if configureTLS {
+			if err := o.configureTLS(transport.TLSClientConfig, &config); err != nil {
+				return config, err
+			}
+		}
+
+		if err := o.configureProxy(transport); err != nil {
+			return config, err
+		}
+
+		config.Transport = transport
+	}
+
+	compress := o.openSearchCompression || config.CompressRequestBody
+
+	switch {
+	case compress && config.Signer != nil:
+		// the signature covers the body, the client compresses it before signing
+		config.CompressRequestBody = true
+	case compress:
+		if o.compression == nil {
+			o.compression = &compressionCounter{}
+		}
+
+		transport := config.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+
+		config.CompressRequestBody = false
+		config.Transport = &compressTransport{next: transport, counter: o.compression}
+	}
+
+	if o.openSearchQuietClient || o.openSearchDebug {
+		config.Logger = &clientLogger{logger: o.internalLogger, bodies: o.openSearchDebug}
+	}
+
+	if o.openSearchRequestTimeout > 0 {
+		transport := config.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+
+		config.Transport = &timeoutTransport{next: transport, timeout: o.openSearchRequestTimeout}
+	}
+
+	return config, nil
+}
+
+// configureTLS applies the TLS options to tlsConfig. A CA bundle is merged with
+// the PEM certificates of config.CACert, which is cleared since the client would
+// otherwise replace the resulting pool.
+func (o *LogOpts) configureTLS(tlsConfig *tls.Config, config *opensearch.Config) error {
+	tlsConfig.InsecureSkipVerify = o.openSearchInsecure //nolint:gosec
+
+	files := o.openSearchTLS
+	if files == nil {
+		return nil
+	}
+
+	if files.certFile != "" || files.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(files.certFile, files.keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client key pair: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if files.caFile != "" {
+		pem, err := os.ReadFile(files.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%w: %s", ErrNoCACertificates, files.caFile)
+		}
+
+		if len(config.CACert) > 0 && !pool.AppendCertsFromPEM(config.CACert) {
+			return fmt.Errorf("%w: opensearch.Config.CACert", ErrNoCACertificates)
+		}
+
+		tlsConfig.RootCAs = pool
+		config.CACert = nil
+	}
+
+	return nil
+}
+
+// configureProxy routes the requests of transport through the proxy set with
+// WithOpenSearchProxy, if any.
+func (o *LogOpts) configureProxy(transport *http.Transport) error {
+	if o.openSearchProxy == "" {
+		return nil
+	}
+
+	proxyURL, err := url.Parse(o.openSearchProxy)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidProxyURL, err)
+	}
+
+	if proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return fmt.Errorf("%w: %q is not an absolute URL", ErrInvalidProxyURL, proxyURL.Redacted())
+	}
+
+	transport.Proxy = http.ProxyURL(proxyURL)
+
+	return nil
+}
+
+// cloneHTTPTransport returns a copy of rt that is safe to modify, with a non-nil
+// TLS config. It returns nil if rt is neither nil nor an *http.Transport.
+func cloneHTTPTransport(rt http.RoundTripper) *http.Transport {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	transport, ok := rt.(*http.Transport)
+	if !ok {
+		return nil
+	}
+
+	transport = transport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{} //nolint:gosec
+	}
+
+	return transport
+}
+
+type tlsFiles struct {
+	certFile string
+	keyFile  string
+	caFile   string
+}
+
+// timeoutTransport bounds the duration of every request, including reading the
+// response body, so a hung connection can't stall a bulk indexer worker.
+type timeoutTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp, nil
+}
+
+// cancelOnClose releases the request context once the response body is consumed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// clientLogger implements opensearchtransport.Logger on top of a zap logger.
+type clientLogger struct {
+	logger *zap.Logger
+	// log the request and response bodies
+	bodies bool
+}
+
+func (l *clientLogger) LogRoundTrip(req *http.Request, res *http.Response, err error, _ time.Time, dur time.Duration) error {
+	fields := []zap.Field{
+		zap.String("method", req.Method),
+		zap.String("url", req.URL.Redacted()),
+		zap.Duration("duration", dur),
+	}
+
+	if res != nil {
+		fields = append(fields, zap.Int("status", res.StatusCode))
+	}
+
+	if l.bodies {
+		fields = append(fields, bodyField("request_body", req.Body))
+
+		if res != nil {
+			fields = append(fields, bodyField("response_body", res.Body))
+		}
+	}
+
+	if err != nil {
+		l.logger.Debug("OpenSearch request failed", append(fields, zap.Error(err))...)
+		return nil
+	}
+
+	l.logger.Debug("OpenSearch request", fields...)
+
+	return nil
+}
+
+func (l *clientLogger) RequestBodyEnabled() bool { return l.bodies }
+
+func (l *clientLogger) ResponseBodyEnabled() bool { return l.bodies }
+
+// bodyField reads a body duplicated for logging by the client.
+func bodyField(key string, body io.ReadCloser) zap.Field {
+	if body == nil || body == http.NoBody {
+		return zap.Skip()
+	}
+
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return zap.String(key, "failed to read body: "+err.Error())
+	}
+
+	return zap.ByteString(key, data)
+}
@@ -0,0 +1,517 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchtransport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestOpenSearchRequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("hang") == "" {
+			_, _ = w.Write([]byte(`{"version":{"number":"2.11.0","distribution":"opensearch"}}`))
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	config := opensearch.Config{Addresses: []string{server.URL}, DisableRetry: true}
+	opt := &LogOpts{openSearchConfig: &config}
+	bindLogOpts(opt, WithOpenSearchRequestTimeout(100*time.Millisecond))
+
+	clientConfig, err := opt.openSearchClientConfig()
+	require.NoError(t, err)
+	assert.Nil(t, config.Transport, "caller's config must not be modified")
+
+	client, err := opensearch.NewClient(clientConfig)
+	require.NoError(t, err)
+
+	// responses within the timeout can still be read after the round trip
+	res, err := client.Info()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, res.String(), "opensearch")
+	res.Body.Close()
+
+	req, err := http.NewRequest(http.MethodGet, "/?hang=1", nil)
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = client.Perform(req)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestOpenSearchCredentials(t *testing.T) {
+	requests := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		_, _ = w.Write([]byte(`{"version":{"number":"2.11.0","distribution":"opensearch"}}`))
+	}))
+	defer server.Close()
+
+	lastRequest := func(t *testing.T, opts ...LogOptFunc) *http.Request {
+		t.Helper()
+
+		config := opensearch.Config{
+			Addresses: []string{server.URL},
+			Header:    http.Header{"X-Team": []string{"logging"}},
+		}
+		opt := &LogOpts{openSearchConfig: &config}
+		bindLogOpts(opt, opts...)
+
+		clientConfig, err := opt.openSearchClientConfig()
+		require.NoError(t, err)
+		assert.Empty(t, config.Username, "caller's config must not be modified")
+		assert.Empty(t, config.Header.Get("Authorization"), "caller's config must not be modified")
+
+		client, err := opensearch.NewClient(clientConfig)
+		require.NoError(t, err)
+
+		res, err := client.Info()
+		require.NoError(t, err)
+		res.Body.Close()
+
+		var last *http.Request
+		for len(requests) > 0 {
+			last = <-requests
+		}
+
+		return last
+	}
+
+	t.Run("basic auth", func(t *testing.T) {
+		req := lastRequest(t, WithOpenSearchBasicAuth("admin", "s3cret"))
+
+		username, password, ok := req.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin", username)
+		assert.Equal(t, "s3cret", password)
+		assert.Equal(t, "logging", req.Header.Get("X-Team"))
+	})
+
+	t.Run("api key", func(t *testing.T) {
+		req := lastRequest(t, WithOpenSearchBasicAuth("admin", "s3cret"), WithOpenSearchAPIKey("key-id:key"))
+
+		assert.Equal(t, "ApiKey key-id:key", req.Header.Get("Authorization"))
+		assert.Equal(t, "logging", req.Header.Get("X-Team"))
+	})
+}
+
+func TestOpenSearchSuppressStartupLogs(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	obs, logs := observer.New(zapcore.DebugLevel)
+	internalLogger := zap.New(obs)
+
+	config := fake.Config()
+	config.Logger = &opensearchtransport.TextLogger{Output: os.Stderr}
+	opt := &LogOpts{openSearchConfig: &config, internalLogger: internalLogger}
+
+	clientConfig, err := opt.openSearchClientConfig()
+	require.NoError(t, err)
+	assert.IsType(t, &opensearchtransport.TextLogger{}, clientConfig.Logger)
+
+	bindLogOpts(opt, WithOpenSearchSuppressStartupLogs(true))
+
+	clientConfig, err = opt.openSearchClientConfig()
+	require.NoError(t, err)
+	require.IsType(t, &clientLogger{}, clientConfig.Logger)
+	assert.Same(t, internalLogger, clientConfig.Logger.(*clientLogger).logger)
+
+	client, err := opensearch.NewClient(clientConfig)
+	require.NoError(t, err)
+
+	res, err := client.Info()
+	require.NoError(t, err)
+	res.Body.Close()
+
+	require.NotZero(t, logs.Len())
+	entry := logs.All()[0]
+	assert.Equal(t, zapcore.DebugLevel, entry.Level)
+	assert.Equal(t, "OpenSearch request", entry.Message)
+	assert.Equal(t, "GET", entry.ContextMap()["method"])
+	assert.Equal(t, int64(http.StatusOK), entry.ContextMap()["status"])
+}
+
+func TestOpenSearchDebug(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	obs, logs := observer.New(zapcore.DebugLevel)
+
+	config := fake.Config()
+	opt := &LogOpts{openSearchConfig: &config, internalLogger: zap.New(obs)}
+	bindLogOpts(opt, WithOpenSearchDebug(true))
+
+	clientConfig, err := opt.openSearchClientConfig()
+	require.NoError(t, err)
+	require.IsType(t, &clientLogger{}, clientConfig.Logger)
+	assert.True(t, clientConfig.Logger.RequestBodyEnabled())
+	assert.True(t, clientConfig.Logger.ResponseBodyEnabled())
+
+	client, err := opensearch.NewClient(clientConfig)
+	require.NoError(t, err)
+
+	res, err := client.Bulk(strings.NewReader(`{"index":{"_index":"zlog-test"}}` + "\n" + `{"msg":"traced"}` + "\n"))
+	require.NoError(t, err)
+	res.Body.Close()
+
+	requests := logs.FilterMessage("OpenSearch request").All()
+	require.NotEmpty(t, requests)
+
+	fields := requests[len(requests)-1].ContextMap()
+	assert.Contains(t, fields["request_body"], `{"msg":"traced"}`)
+	assert.Contains(t, fields["response_body"], `"items"`)
+	assert.Len(t, fake.Docs(), 1, "bodies are still sent and returned")
+}
+
+type countingTransport struct {
+	calls atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.calls.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOpenSearchTransport(t *testing.T) {
+	fake := newFakeOpenSearch(t)
+	custom := &countingTransport{}
+
+	config := fake.Config()
+	config.Transport = &http.Transport{}
+	opt := &LogOpts{openSearchConfig: &config}
+	bindLogOpts(opt, WithInsecure(true), WithOpenSearchTransport(custom))
+
+	clientConfig, err := opt.openSearchClientConfig()
+	require.NoError(t, err)
+	assert.Same(t, custom, clientConfig.Transport, "custom transport wins over insecure")
+
+	client, err := opensearch.NewClient(clientConfig)
+	require.NoError(t, err)
+
+	res, err := client.Info()
+	require.NoError(t, err)
+	res.Body.Close()
+
+	assert.NotZero(t, custom.calls.Load())
+}
+
+func TestOpenSearchInsecure(t *testing.T) {
+	original := &http.Transport{MaxIdleConnsPerHost: 7}
+	config := opensearch.Config{Transport: original}
+	opt := &LogOpts{openSearchConfig: &config}
+	bindLogOpts(opt, WithInsecure(true))
+
+	clientConfig, err := opt.openSearchClientConfig()
+	require.NoError(t, err)
+
+	transport, ok := clientConfig.Transport.(*http.Transport)
+	require.True(t, ok)
+	assert.NotSame(t, original, transport)
+	assert.False(t, original.TLSClientConfig != nil && original.TLSClientConfig.InsecureSkipVerify,
+		"caller's transport must not be modified")
+	assert.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+	assert.Equal(t, 7, transport.MaxIdleConnsPerHost)
+}
+
+// writeClientCert creates a CA and a client certificate signed by it, returning
+// the CA and the paths of the PEM encoded client key pair.
+func writeClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "zlog test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "zlog"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return ca, certFile, keyFile
+}
+
+func TestOpenSearchTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, certFile, keyFile := writeClientCert(t, dir)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(dir, "ca.crt")
+	serverPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, serverPEM, 0o600))
+
+	newTransport := func(opts ...LogOptFunc) http.RoundTripper {
+		config := opensearch.Config{Addresses: []string{server.URL}}
+		opt := &LogOpts{openSearchConfig: &config}
+		bindLogOpts(opt, opts...)
+
+		clientConfig, err := opt.openSearchClientConfig()
+		require.NoError(t, err)
+
+		return clientConfig.Transport
+	}
+
+	get := func(rt http.RoundTripper) error {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+
+		res, err := rt.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+
+		return err
+	}
+
+	require.NoError(t, get(newTransport(WithOpenSearchTLS(certFile, keyFile, caFile))))
+	assert.Error(t, get(newTransport(WithOpenSearchTLS("", "", caFile))), "server requires a client certificate")
+}
+
+func TestOpenSearchTLSErrors(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := writeClientCert(t, dir)
+
+	emptyCA := filepath.Join(dir, "empty.crt")
+	require.NoError(t, os.WriteFile(emptyCA, []byte("not a certificate"), 0o600))
+
+	config := opensearch.Config{Addresses: []string{"http://127.0.0.1:1"}}
+
+	_, _, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config), WithOpenSearchIndex("test", ""),
+		WithOpenSearchTLS(certFile, keyFile, emptyCA),
+	)
+	require.ErrorIs(t, err, ErrNoCACertificates)
+	assert.ErrorContains(t, err, emptyCA)
+
+	_, _, err = NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config), WithOpenSearchIndex("test", ""),
+		WithOpenSearchTLS(filepath.Join(dir, "missing.crt"), keyFile, ""),
+	)
+	assert.ErrorContains(t, err, "failed to load client key pair")
+
+	_, _, err = NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config), WithOpenSearchIndex("test", ""),
+		WithOpenSearchTLS(certFile, keyFile, ""), WithOpenSearchTransport(&countingTransport{}),
+	)
+	assert.ErrorIs(t, err, ErrTLSWithCustomTransport)
+}
+
+func TestDefaultOpenSearchConfigMulti(t *testing.T) {
+	urls := []string{"https://node-1:9200", "https://node-2:9200"}
+
+	config, err := DefaultOpenSearchConfigMulti(urls, true)
+	require.NoError(t, err)
+	assert.Equal(t, urls, config.Addresses)
+
+	transport, ok := config.Transport.(*http.Transport)
+	require.True(t, ok)
+	assert.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+
+	_, err = DefaultOpenSearchConfigMulti(nil, false)
+	require.ErrorIs(t, err, ErrNoOpenSearchAddresses)
+
+	_, err = DefaultOpenSearchConfigMulti([]string{"https://node-1:9200", "node-2"}, false)
+	require.ErrorIs(t, err, ErrInvalidOpenSearchAddress)
+
+	_, err = DefaultOpenSearchConfigMulti([]string{"://node-1"}, false)
+	require.ErrorIs(t, err, ErrInvalidOpenSearchAddress)
+}
+
+func TestOpenSearchAddresses(t *testing.T) {
+	nodes := []*fakeOpenSearch{newFakeOpenSearch(t), newFakeOpenSearch(t)}
+	config := opensearch.Config{Addresses: []string{"http://unused:9200"}}
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchAddresses([]string{nodes[0].URL, nodes[1].URL}),
+	)
+	require.NoError(t, err)
+
+	for range 4 {
+		logger.Info("hello")
+		require.NoError(t, flushFunc(context.Background()))
+	}
+
+	assert.NotEmpty(t, nodes[0].Docs())
+	assert.NotEmpty(t, nodes[1].Docs())
+	assert.Len(t, append(nodes[0].Docs(), nodes[1].Docs()...), 4)
+
+	_, _, err = NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithOpenSearchAddresses(nil),
+	)
+	assert.ErrorIs(t, err, ErrNoOpenSearchAddresses)
+}
+
+func TestOpenSearchProxy(t *testing.T) {
+	original := &http.Transport{TLSClientConfig: &tls.Config{ServerName: "logs.internal"}} //nolint:gosec
+	config := opensearch.Config{Transport: original}
+	opt := &LogOpts{openSearchConfig: &config}
+	bindLogOpts(opt, WithOpenSearchProxy("http://proxy.internal:3128"))
+
+	clientConfig, err := opt.openSearchClientConfig()
+	require.NoError(t, err)
+
+	transport, ok := clientConfig.Transport.(*http.Transport)
+	require.True(t, ok)
+	assert.Nil(t, original.Proxy, "caller's transport must not be modified")
+	assert.Equal(t, "logs.internal", transport.TLSClientConfig.ServerName)
+
+	req := httptest.NewRequest(http.MethodPost, "https://opensearch:9200/_bulk", nil)
+	proxyURL, err := transport.Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, "http://proxy.internal:3128", proxyURL.String())
+
+	for _, proxy := range []string{"proxy.internal:3128", "://"} {
+		opt := &LogOpts{openSearchConfig: &opensearch.Config{}}
+		bindLogOpts(opt, WithOpenSearchProxy(proxy))
+
+		_, err := opt.openSearchClientConfig()
+		assert.ErrorIs(t, err, ErrInvalidProxyURL, proxy)
+	}
+
+	opt = &LogOpts{openSearchConfig: &opensearch.Config{}}
+	bindLogOpts(opt, WithOpenSearchProxy("http://proxy.internal:3128"), WithOpenSearchTransport(&countingTransport{}))
+
+	_, err = opt.openSearchClientConfig()
+	assert.ErrorIs(t, err, ErrProxyWithCustomTransport)
+}
+
+// hashSigner signs requests with the hash of their body, like SigV4.
+type hashSigner struct{}
+
+func (hashSigner) SignRequest(req *http.Request) error {
+	var body []byte
+
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return err
+		}
+
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	hash := sha256.Sum256(body)
+	req.Header.Set("X-Signature", hex.EncodeToString(hash[:]))
+
+	return nil
+}
+
+// signatureChecker fails the requests whose body doesn't match their signature.
+type signatureChecker struct {
+	checked atomic.Int64
+}
+
+func (c *signatureChecker) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		hash := sha256.Sum256(body)
+		if req.Header.Get("X-Signature") != hex.EncodeToString(hash[:]) {
+			return nil, errors.New("signature mismatch")
+		}
+
+		c.checked.Add(1)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOpenSearchSigner(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compression %t", compress), func(t *testing.T) {
+			fake := newFakeOpenSearch(t)
+			checker := &signatureChecker{}
+			writer := fake.newWriter(t,
+				WithOpenSearchSigner(hashSigner{}),
+				WithOpenSearchTransport(checker),
+				WithOpenSearchCompression(compress),
+			)
+
+			_, err := writer.Write([]byte(`{"msg":"signed"}`))
+			require.NoError(t, err)
+			require.NoError(t, writer.Flush(context.Background()))
+
+			assert.Len(t, fake.Docs(), 1)
+			assert.Positive(t, checker.checked.Load())
+		})
+	}
+}
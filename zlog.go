@@ -1,33 +1,188 @@
 package zlog
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/signer"
 	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/time/rate"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// For testing purposes
+var (
+	stdout zapcore.WriteSyncer = zapcore.AddSync(os.Stdout)
+	stderr zapcore.WriteSyncer = zapcore.AddSync(os.Stderr)
+)
+
 type LogOpts struct {
 	devEnv      bool
 	withLJ      bool
 	withConsole bool
+	withStderr  bool
+	// adds the calling function to entries
+	callerFunction bool
+	syslog         *syslogConfig
+	// the outputs added with WithCore
+	extraCores []func(level zapcore.LevelEnabler) zapcore.Core
+	// the caller is added unless disabled with WithCaller
+	disableCaller   bool
+	callerSkip      int
+	stacktraceLevel *zapcore.Level
+	originTag       string
+	consoleJSON     bool
+	compactLevels   bool
+	errorHighlight  bool
+	// override the time layout and separator of the console and file outputs
+	timeEncoder      zapcore.TimeEncoder
+	consoleSeparator string
+
+	// fields left out of the console output
+	consoleHiddenFields []string
 
 	level zapcore.Level
+	// shared by all cores, created from level unless set by WithAtomicLevel
+	atomicLevel   *zap.AtomicLevel
+	onLevelChange []func(old, new zapcore.Level)
+	maxEntries    uint64
+
+	// set up by newLogger, removes the onLevelChange callbacks
+	removeLevelHooks func()
+
+	ljFilename     string
+	teeFormatsPath string
+	lumberJacker   *lumberjack.Logger
+
+	// buffers the file and console outputs, see WithBufferedIO
+	bufferedIO          bool
+	bufferSize          int
+	bufferFlushInterval time.Duration
+	// set up by buffered, stopped by the CleanUp function
+	bufferedSyncers []*zapcore.BufferedWriteSyncer
+
+	openSearchConfig     *opensearch.Config
+	openSearchAddresses  []string
+	openSearchIndex      string
+	openSearchErrorIndex string
+	openSearchDataStream string
+	rolloverAlias        string
+	rolloverConditions   RolloverConditions
+	// closed by the CleanUp function, stops the rollover checks
+	rolloverStop             chan struct{}
+	openSearchInsecure       bool
+	openSearchVersionFunc    func(entry []byte) int64
+	openSearchDocumentIDFunc func(entry map[string]interface{}) string
+	openSearchRequestTimeout time.Duration
+	writeTimeout             time.Duration
+	shutdownCtx              context.Context
+	closeOnCleanUp           bool
+	openSearchUsername       string
+	openSearchPassword       string
+	openSearchAPIKey         string
+	openSearchQuietClient    bool
+	openSearchDebug          bool
+	openSearchFallback       string
+	openSearchValidate       bool
+	compressFieldsThreshold  int
+	openSearchMaxDocSize     int
+	openSearchMaxIndexBytes  int64
+	openSearchPipeline       string
+	openSearchRefresh        string
+	bulkIndexerFactory       BulkIndexerFactory
+	openSearchMaxRetries     int
+	openSearchRetryBackoff   time.Duration
+	openSearchRetryJitter    JitterMode
+	openSearchTransport      http.RoundTripper
+	openSearchTLS            *tlsFiles
+	openSearchProxy          string
+	openSearchSigner         signer.Signer
+	openSearchCompression    bool
+	openSearchAsyncInit      bool
+	openSearchReconnect      time.Duration
+	openSearchRateLimit      int64
+	openSearchRateBurst      int64
+	openSearchRatePolicy     RateLimitPolicy
+	openSearchDocRateLimit   int
+	openSearchMaxPending     int
+	// consecutive failures opening the circuit, 0 disables the breaker
+	openSearchBreakerThreshold int
+	openSearchBreakerCooldown  time.Duration
+	flushNotify                chan<- struct{}
+	flushOnIdle                time.Duration
+	contextKeys                []interface{}
+	contextExtractors          []func(context.Context) []zap.Field
+	openSearchWorkers          int
+	openSearchFlushBytes       int
+	openSearchFlushInterval    time.Duration
+	statsReportInterval        time.Duration
+	fingerprintFields          []string
+	messageKey                 string
+	levelKey                   string
+	timeKey                    string
+	nameKey                    string
+	callerKey                  string
+	dedupWindow                time.Duration
+	dedupFields                []string
+	timestampSkewTolerance     time.Duration
+	requiredFields             []string
+	dropIncomplete             bool
+	redactFields               []string
+	redactMask                 string
+	redactFuncs                []func(map[string]interface{})
+	warmThreshold              time.Duration
+	tenantField                string
+	tenantDefault              string
+	maxTenants                 int
+	indexTemplateName          string
+	indexTemplateBody          map[string]interface{}
+	indexTemplateForce         bool
+	samplingTick               time.Duration
+	samplingFirst              int
+	samplingThereafter         int
+	dropHook                   func(zapcore.Entry)
+	appendOnly                 bool
+	indexDateFormat            string
+	timeLocation               *time.Location
+	clock                      func() time.Time
+
+	// name and base fields of the logger
+	name   string
+	fields []zap.Field
 
-	ljFilename   string
-	lumberJacker *lumberjack.Logger
+	handle  *Handle
+	metrics Metrics
+	// run by the CleanUp function after flushing
+	cleanUpHooks []func(ctx context.Context, stats IndexerStats) error
+	// set up with compression, shared by the writers
+	compression *compressionCounter
+	// set up with WithOpenSearchRateLimit, shared by the writers
+	openSearchRateLimiter *rateLimit
+	// set up with WithOpenSearchDocRateLimit, shared by the writers
+	openSearchDocRateLimiter *rate.Limiter
+	// set up with WithOpenSearchCircuitBreaker, shared by the writers
+	openSearchBreaker *circuitBreaker
 
-	openSearchConfig   *opensearch.Config
-	openSearchIndex    string
-	openSearchInsecure bool
-	indexDateFormat    string
-	timeLocation       *time.Location
+	// clients created for the configs, shared by the OpenSearch cores
+	openSearchClients map[*opensearch.Config]*opensearch.Client
 
 	internalLogger *zap.Logger
+	// output of the default internal logger, stderr if nil
+	internalLoggerWriter io.Writer
+	// nil keeps the level of internalLogger
+	internalLoggerLevel *zapcore.Level
 }
 
 type LogOptFunc func(o *LogOpts)
@@ -56,12 +211,190 @@ func WithConsole(b bool) LogOptFunc {
 	}
 }
 
+// WithStderr splits the console output: entries below the error level go to
+// stdout, errors and above to stderr.
+func WithStderr(b bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.withStderr = b
+	}
+}
+
+// WithConsoleJSON writes the console output as JSON lines, like the OpenSearch
+// output, for log collectors reading stdout. It ignores WithDevEnv and
+// WithCompactLevels.
+func WithConsoleJSON(b bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.consoleJSON = b
+	}
+}
+
+// WithSyslog also sends the entries to the syslog server at addr, e.g.
+// "udp", "localhost:514", as RFC 5424 messages with tag as the app name and the
+// JSON encoded entry as the message. The connection is dialed on first use and
+// again after a failed write.
+func WithSyslog(network, addr, tag string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.syslog = &syslogConfig{network: network, addr: addr, tag: tag}
+	}
+}
+
+// WithCore adds an output built by newCore, e.g. an OpenTelemetry exporter, see
+// the zlogotel package. newCore receives the level of the logger, so the output
+// follows SetLevel, and its entries get the same fields as the other outputs.
+// The core is synced along with them, e.g. by the CleanUp function.
+func WithCore(newCore func(level zapcore.LevelEnabler) zapcore.Core) LogOptFunc {
+	return func(o *LogOpts) {
+		o.extraCores = append(o.extraCores, newCore)
+	}
+}
+
+// WithCaller adds the file and line of the calling code to every entry, which is
+// the default. It's resolved for every entry, so disabling it saves some cost.
+func WithCaller(b bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.disableCaller = !b
+	}
+}
+
+// WithCallerSkip skips n additional stack frames when resolving the caller, so
+// wrapper libraries report the call site of their own callers.
+func WithCallerSkip(n int) LogOptFunc {
+	return func(o *LogOpts) {
+		o.callerSkip = n
+	}
+}
+
+// WithStacktrace adds a stack trace to the entries at level and above, e.g.
+// zapcore.ErrorLevel to debug panics shipped to OpenSearch.
+func WithStacktrace(level zapcore.Level) LogOptFunc {
+	return func(o *LogOpts) {
+		o.stacktraceLevel = &level
+	}
+}
+
+// WithCallerFunction adds the name of the calling function to every entry as a
+// "func" field, e.g. to aggregate errors by function in OpenSearch. The caller
+// is resolved for the caller field anyway, so the cost is the extra field.
+func WithCallerFunction(b bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.callerFunction = b
+	}
+}
+
+// WithOriginTag adds an "origin" field set to tag, e.g. "app", to every entry,
+// telling application logs from framework ones in a shared index. Sub-loggers
+// override it with Origin.
+func WithOriginTag(tag string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.originTag = tag
+	}
+}
+
+// WithTimeEncoder sets how the console and file outputs render timestamps, e.g.
+// zapcore.RFC3339NanoTimeEncoder or zapcore.EpochMillisTimeEncoder, instead of
+// ISO8601, or 15:04:05 in dev environments. JSON outputs keep ISO8601, which
+// OpenSearch parses.
+func WithTimeEncoder(encoder zapcore.TimeEncoder) LogOptFunc {
+	return func(o *LogOpts) {
+		o.timeEncoder = encoder
+	}
+}
+
+// WithConsoleSeparator sets the separator between the elements of the console
+// and file output lines, a tab by default, or a space on the console in dev
+// environments.
+func WithConsoleSeparator(separator string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.consoleSeparator = separator
+	}
+}
+
+// WithCompactLevels renders levels on the console as single letter tags
+// (D, I, W, E, ...) instead of full words. File and OpenSearch outputs keep
+// the full level names so they stay searchable.
+func WithCompactLevels(b bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.compactLevels = b
+	}
+}
+
+// WithErrorFieldHighlight moves the "error" field, e.g. added with zap.Error, to
+// the end of console lines and renders it in red. It's ignored with
+// WithConsoleJSON; file and OpenSearch outputs are unchanged.
+func WithErrorFieldHighlight(b bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.errorHighlight = b
+	}
+}
+
+// WithConsoleHiddenFields leaves the given fields, e.g. trace_id or instance_id,
+// out of the console output, which is meant for humans. Files and OpenSearch
+// still receive them.
+func WithConsoleHiddenFields(keys ...string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.consoleHiddenFields = append([]string{}, keys...)
+	}
+}
+
 func WithLogLevel(lvl zapcore.Level) LogOptFunc {
 	return func(o *LogOpts) {
 		o.level = lvl
 	}
 }
 
+// WithAtomicLevel makes all outputs of the logger follow lvl, so the verbosity
+// can be changed at runtime with lvl.SetLevel, or over HTTP since lvl is an
+// http.Handler. It takes precedence over WithLogLevel.
+func WithAtomicLevel(lvl zap.AtomicLevel) LogOptFunc {
+	return func(o *LogOpts) {
+		o.atomicLevel = &lvl
+	}
+}
+
+// WithOnLevelChange calls fn whenever the level of the logger is changed with
+// SetLevel or LevelHandler, e.g. to keep an audit trail of level changes. It
+// requires WithAtomicLevel, since only that level can be changed, otherwise
+// the constructors fail with ErrOnLevelChangeWithoutAtomicLevel. The callbacks
+// are registered for the level until the CleanUp function of the logger is
+// called; loggers built without one, e.g. by NewZapLogger, keep them as long
+// as the process runs.
+func WithOnLevelChange(fn func(old, new zapcore.Level)) LogOptFunc {
+	return func(o *LogOpts) {
+		o.onLevelChange = append(o.onLevelChange, fn)
+	}
+}
+
+// WithMaxEntries caps the number of entries a logger emits. Once n entries have
+// been written a single "log limit reached" warning is emitted and every later
+// entry is discarded, which keeps a runaway loop from flooding the sinks.
+// Zero (the default) means unlimited.
+func WithMaxEntries(n uint64) LogOptFunc {
+	return func(o *LogOpts) {
+		o.maxEntries = n
+	}
+}
+
+// WithContextKeys registers context keys whose values With attaches to a
+// logger, e.g. user, tenant or correlation IDs stored by middlewares.
+func WithContextKeys(keys ...interface{}) LogOptFunc {
+	return func(o *LogOpts) {
+		o.contextKeys = append(o.contextKeys, keys...)
+	}
+}
+
+// WithContextFields registers functions deriving fields from a context, e.g. the
+// request ID or the OpenTelemetry trace and span IDs, which With adds to the
+// child loggers it returns.
+func WithContextFields(extractors ...func(context.Context) []zap.Field) LogOptFunc {
+	return func(o *LogOpts) {
+		o.contextExtractors = append(o.contextExtractors, extractors...)
+	}
+}
+
+func (o *LogOpts) contextFields() contextFields {
+	return contextFields{keys: o.contextKeys, extractors: o.contextExtractors}
+}
+
 // WithLjFilename if name is supplied
 func WithLjFilename(s string) LogOptFunc {
 	return func(o *LogOpts) {
@@ -69,126 +402,1307 @@ func WithLjFilename(s string) LogOptFunc {
 	}
 }
 
+// WithLumberjack writes the file output to a rotator configured by the caller,
+// e.g. with compression or local time backup names. It takes precedence over
+// WithLjFilename and the .log file of WithTeeFormats.
+func WithLumberjack(lj *lumberjack.Logger) LogOptFunc {
+	return func(o *LogOpts) {
+		o.lumberJacker = lj
+	}
+}
+
+// WithTeeFormats writes every entry to two files derived from path: a human
+// readable console formatted <base>.log and a machine parseable <base>.json,
+// where <base> is path without its extension. It takes precedence over
+// WithLjFilename and has no effect when file output is disabled.
+func WithTeeFormats(path string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.teeFormatsPath = path
+	}
+}
+
 func WithOpenSearchConfig(config *opensearch.Config) LogOptFunc {
 	return func(o *LogOpts) {
 		o.openSearchConfig = config
 	}
 }
 
+// WithOpenSearchAddresses replaces the addresses of the config given to
+// WithOpenSearchConfig, e.g. with all coordinating nodes of the cluster, which the
+// client uses in turn. The constructor fails if urls is empty or holds an
+// invalid URL.
+func WithOpenSearchAddresses(urls []string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchAddresses = append([]string{}, urls...)
+	}
+}
+
+// WithOpenSearchVersionFunc enables external versioning of indexed documents.
+// fn receives the encoded log entry and returns its version; OpenSearch then
+// rejects any document whose version is not newer than the stored one, so the
+// newest event always wins. Versions only apply to documents with an explicit ID.
+func WithOpenSearchVersionFunc(fn func(entry []byte) int64) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchVersionFunc = fn
+	}
+}
+
+// WithOpenSearchDocumentID sets the _id of indexed documents to fn(entry), where
+// entry is the decoded log entry, so documents re-sent by bulk retries don't show
+// up twice. The ID should be derived deterministically, e.g. from a hash of the
+// timestamp, message and host; an empty ID lets OpenSearch generate one.
+// Combine it with WithAppendOnly to make ingestion idempotent.
+func WithOpenSearchDocumentID(fn func(entry map[string]interface{}) string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchDocumentIDFunc = fn
+	}
+}
+
 // WithOpenSearchIndex sets the base index name and optional date format for rotation
 func WithOpenSearchIndex(baseIndex string, dateFormat string) LogOptFunc {
 	return func(o *LogOpts) {
 		o.openSearchIndex = baseIndex
-		o.indexDateFormat = dateFormat
+		if dateFormat != "" {
+			o.indexDateFormat = dateFormat
+		}
+	}
+}
+
+// WithIndexDateFormat sets the date format of the rotated index names,
+// DateFormatDot by default. See WithTimeLocation for the timezone.
+func WithIndexDateFormat(format IndexFormat) LogOptFunc {
+	return func(o *LogOpts) {
+		o.indexDateFormat = string(format)
+	}
+}
+
+// WithOpenSearchErrorIndex ships error and higher levels to their own index,
+// e.g. for alerting, instead of the one set by WithOpenSearchIndex. It shares
+// the date format of WithOpenSearchIndex.
+func WithOpenSearchErrorIndex(index string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchErrorIndex = index
+	}
+}
+
+// WithOpenSearchDataStream ships logs to the given data stream instead of dated
+// indices, leaving rollover to the cluster (e.g. ILM). Entries are indexed with
+// the "create" action data streams require. It can't be combined with
+// WithOpenSearchIndex or WithOpenSearchErrorIndex.
+func WithOpenSearchDataStream(name string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchDataStream = name
+	}
+}
+
+// WithManagedRollover ships logs to the write alias instead of dated indices and
+// lets the cluster rotate indices with the rollover API, checked periodically
+// against conditions until the CleanUp function is called. Unless the alias
+// exists, "<alias>-000001" is created as its write index. It can't be combined with WithOpenSearchIndex,
+// WithOpenSearchErrorIndex or WithOpenSearchDataStream.
+func WithManagedRollover(alias string, conditions RolloverConditions) LogOptFunc {
+	return func(o *LogOpts) {
+		o.rolloverAlias = alias
+		o.rolloverConditions = conditions
+	}
+}
+
+// WithClock sets the clock of the OpenSearch output, time.Now by default. It
+// decides the index names, see IndexConfig.Clock, the age of entries for
+// WithWarmThreshold and the timestamp skew for WithTimestampSkewTolerance.
+func WithClock(clock func() time.Time) LogOptFunc {
+	return func(o *LogOpts) {
+		o.clock = clock
 	}
 }
 
-// WithTimeLocation sets the timezone for index rotation
+// WithTimeLocation sets the timezone for index rotation, UTC by default
 func WithTimeLocation(location *time.Location) LogOptFunc {
 	return func(o *LogOpts) {
 		o.timeLocation = location
 	}
 }
 
-func WithInsecure(insecure bool) LogOptFunc {
+// WithOpenSearchRequestTimeout bounds the duration of each HTTP request sent to
+// OpenSearch, e.g. a bulk request. Unlike the per entry write timeout, this stops
+// a hung connection from stalling a bulk indexer worker indefinitely.
+//
+// The timeout wraps the configured transport, so opensearch.Config.CACert can't
+// be combined with it; configure the certificates on the transport instead.
+func WithOpenSearchRequestTimeout(d time.Duration) LogOptFunc {
 	return func(o *LogOpts) {
-		o.openSearchInsecure = insecure
+		o.openSearchRequestTimeout = d
 	}
 }
 
-func WithInternalLogger(logger *zap.Logger) LogOptFunc {
+// WithWriteTimeout bounds how long writing an entry waits for room in the queue
+// of the OpenSearch bulk indexer, 5s by default. Entries that time out are
+// written to the fallback file if one is set and dropped otherwise.
+func WithWriteTimeout(timeout time.Duration) LogOptFunc {
 	return func(o *LogOpts) {
-		o.internalLogger = logger
+		o.writeTimeout = timeout
 	}
 }
 
-func MustNewLoggerDebug(opts ...LogOptFunc) *zap.Logger {
-	opts = append(opts, WithLogLevel(zapcore.DebugLevel))
-	return MustNewZapLogger(opts...)
+// WithShutdownContext aborts the entries waiting for room in the queue of the
+// OpenSearch bulk indexer once ctx is done, instead of letting them run into
+// the write timeout, e.g. when the service received a termination signal. From
+// then on, entries are written to the fallback file if one is set and dropped
+// otherwise; the buffered ones are still shipped by the CleanUp function.
+func WithShutdownContext(ctx context.Context) LogOptFunc {
+	return func(o *LogOpts) {
+		o.shutdownCtx = ctx
+	}
 }
 
-// MustNewZapLoggerWithFlush creates a zap logger and returns it along with a flush function.
-// This function wraps MustNewZapLogger to provide a consistent interface with MustNewZapLoggerWithOpenSearch.
-func MustNewZapLoggerWithFlush(opts ...LogOptFunc) (*zap.Logger, func() error) {
-	logger := MustNewZapLogger(opts...)
+// WithCloseOnCleanUp makes the CleanUp function close the OpenSearch outputs
+// for good once their entries are shipped ("flush and close"), instead of
+// keeping them open. The entries waiting for room in the queue of the bulk
+// indexer are aborted right away, and so is the close once the context of the
+// CleanUp function is done. Entries logged afterwards are dropped.
+func WithCloseOnCleanUp(b bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.closeOnCleanUp = b
+	}
+}
 
-	// Create a no-op flush function since MustNewZapLogger doesn't have a flush mechanism
-	flushFunc := func() error {
-		return nil
+// WithOpenSearchBasicAuth authenticates against OpenSearch with HTTP basic auth.
+// The credentials are merged into the config given to WithOpenSearchConfig.
+func WithOpenSearchBasicAuth(username, password string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchUsername = username
+		o.openSearchPassword = password
 	}
+}
 
-	return logger, flushFunc
+// WithOpenSearchSigner signs every request sent to OpenSearch with s, e.g. with
+// AWS SigV4 for Amazon OpenSearch Service, see the zlogaws package. With
+// WithOpenSearchCompression, requests are compressed before they are signed and
+// IndexerStats doesn't report their sizes.
+func WithOpenSearchSigner(s signer.Signer) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchSigner = s
+	}
 }
 
-// MustNewZapLogger create a simple zap logger
-func MustNewZapLogger(opts ...LogOptFunc) *zap.Logger {
-	opt := &LogOpts{devEnv: true, level: zapcore.InfoLevel, withLJ: true, withConsole: true}
-	bindLogOpts(opt, opts...)
+// WithOpenSearchAPIKey authenticates against OpenSearch with an API key sent in
+// the Authorization header. It takes precedence over basic auth.
+func WithOpenSearchAPIKey(key string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchAPIKey = key
+	}
+}
 
-	if opt.lumberJacker == nil {
-		filename := "/tmp/zlog.log"
-		if opt.ljFilename != "" {
-			filename = opt.ljFilename
-		}
+// WithOpenSearchSuppressStartupLogs routes the opensearch-go client's own
+// logging to the internal logger at debug level, replacing any Logger set on the
+// config, so client chatter no longer mixes into stderr. With the default
+// internal logger, which only reports warnings, the client stays silent.
+func WithOpenSearchSuppressStartupLogs(b bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchQuietClient = b
+	}
+}
 
-		opt.lumberJacker = newLJ(filename)
+// WithOpenSearchDebug traces every request of the opensearch-go client,
+// including the request and response bodies, on the internal logger at debug
+// level, e.g. when entries mysteriously don't appear. It replaces any Logger set
+// on the config. The bodies hold the shipped entries, so it's meant to be
+// enabled temporarily.
+func WithOpenSearchDebug(b bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchDebug = b
 	}
+}
 
-	lumberJackEnc := genProdEncoder()
-	consoleEnc := lumberJackEnc
+// WithBulkIndexerFactory creates the bulk indexers entries are handed to with
+// factory, e.g. to capture them in memory in tests without a cluster. The items
+// carry the index, action and body; OnSuccess and OnFailure must be called for
+// WithFlushNotify, WithOpenSearchFallback and the metrics to work. A factory is
+// called again every time the logger is flushed.
+func WithBulkIndexerFactory(factory BulkIndexerFactory) LogOptFunc {
+	return func(o *LogOpts) {
+		o.bulkIndexerFactory = factory
+	}
+}
 
-	if opt.devEnv {
-		lumberJackEnc = genDevEncoder(false)
-		consoleEnc = genDevEncoder(true)
+// WithOpenSearchPipeline runs the shipped entries through the given ingest
+// pipeline, e.g. to enrich them with geoip or user agent details. It's set as
+// the pipeline parameter of the bulk requests, which every OpenSearch version
+// supports; the pipeline must exist on the cluster or the requests fail.
+func WithOpenSearchPipeline(name string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchPipeline = name
 	}
+}
 
-	writeSyncer := zapcore.AddSync(opt.lumberJacker)
-	coreLumberJack := zapcore.NewCore(lumberJackEnc, writeSyncer, opt.level)
-	coreConsole := zapcore.NewCore(consoleEnc, zapcore.AddSync(os.Stdout), opt.level)
+// WithOpenSearchRefresh sets the refresh policy of the bulk requests: "true"
+// makes shipped entries searchable right away, "wait_for" waits until the next
+// refresh does, and "false", the cluster's default, doesn't refresh. Refreshing
+// on every bulk request creates many small segments and costs a lot of indexing
+// throughput, keep it to development and tests. Other values make
+// NewZapLoggerWithOpenSearch fail with ErrInvalidRefreshPolicy.
+func WithOpenSearchRefresh(policy string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchRefresh = policy
+	}
+}
 
-	var cores []zapcore.Core
-	if opt.withLJ {
-		cores = append(cores, coreLumberJack)
+// WithOpenSearchValidateEntries parses and re-encodes every entry before handing
+// it to the bulk indexer, normalizing it and rejecting invalid JSON. Without it,
+// entries are shipped as encoded unless an option needs their fields, e.g.
+// WithRedactFields or WithOpenSearchDocumentID.
+func WithOpenSearchValidateEntries(b bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchValidate = b
 	}
+}
 
-	if opt.withConsole {
-		cores = append(cores, coreConsole)
+// WithCompressLargeFields gzips the top-level string fields of entries shipped
+// to OpenSearch that are longer than threshold bytes, e.g. request payloads,
+// and stores them base64 encoded under the key with a "_compressed" suffix,
+// see DecompressField. The compressed fields are no longer searchable, so the
+// message, level, time, name, caller and stacktrace keys are left as they are.
+func WithCompressLargeFields(threshold int) LogOptFunc {
+	return func(o *LogOpts) {
+		o.compressFieldsThreshold = threshold
 	}
+}
 
-	if len(cores) == 0 {
-		log.Println("No logging outputs specified")
-		return nil
+// WithOpenSearchMaxDocSize limits the size of the encoded entries shipped to
+// OpenSearch, which should stay below WithOpenSearchFlushBytes. The stack trace
+// of a larger entry is truncated to fit; entries that still don't fit are
+// dropped with a warning and counted in IndexerStats.Dropped.
+func WithOpenSearchMaxDocSize(bytes int) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchMaxDocSize = bytes
 	}
+}
 
-	coreTee := zapcore.NewTee(cores...)
-	logger := zap.New(coreTee, zap.AddCaller())
+// WithOpenSearchMaxIndexBytes rolls the dated index over to a new one, suffixed
+// with -000001, -000002, ..., once about n bytes of entries were written to it,
+// e.g. logs-2024.01.25-000001. The count restarts with the next date and isn't
+// kept across restarts. It has no effect with WithOpenSearchDataStream or
+// WithManagedRollover.
+func WithOpenSearchMaxIndexBytes(n int64) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchMaxIndexBytes = n
+	}
+}
 
-	if opt.devEnv {
-		ReplaceGlobalToShowLogZapL(logger)
+// WithOpenSearchFallback writes entries that could not be shipped to OpenSearch
+// to a local, lumberjack rotated file instead of dropping them. This covers
+// entries rejected by OpenSearch, entries the bulk indexer refused, entries of
+// bulk requests that failed as a whole, e.g. during an outage, and entries still
+// unacknowledged when the writer is flushed. Entries queued while a request
+// fails may be written to the file and still reach OpenSearch later.
+// The file holds one JSON document per line.
+func WithOpenSearchFallback(filename string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchFallback = filename
 	}
+}
 
-	return logger
+// WithOpenSearchRetry retries documents OpenSearch rejects with a transient
+// status (429, 502, 503 or 504) up to maxRetries times, waiting initialBackoff
+// before the first retry and twice as long before each following one. Retries
+// are counted in the indexer stats. Failed bulk requests as a whole are retried
+// by the OpenSearch client itself, see opensearch.Config.RetryOnStatus.
+func WithOpenSearchRetry(maxRetries int, initialBackoff time.Duration) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchMaxRetries = maxRetries
+		o.openSearchRetryBackoff = initialBackoff
+	}
 }
 
-func genProdEncoder() zapcore.Encoder {
-	encoderConfig := zap.NewProductionEncoderConfig()
-	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+// WithOpenSearchRetryJitter randomizes the backoff of WithOpenSearchRetry, so
+// retries of items that failed together are spread out.
+func WithOpenSearchRetryJitter(mode JitterMode) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchRetryJitter = mode
+	}
+}
 
-	return zapcore.NewConsoleEncoder(encoderConfig)
+// WithOpenSearchWorkers sets the number of concurrent bulk indexer workers.
+// Values <= 0 keep the default of 2; values above runtime.NumCPU()*4 are clamped.
+func WithOpenSearchWorkers(n int) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchWorkers = n
+	}
 }
 
-func genDevEncoder(isConsole bool) zapcore.Encoder {
-	encoderConfig := zap.NewDevelopmentEncoderConfig()
-	encoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout("15:04:05")
-	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+// WithOpenSearchFlushBytes sets the buffer size that forces a bulk request.
+// Values <= 0 keep the default of 256KB.
+func WithOpenSearchFlushBytes(n int) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchFlushBytes = n
+	}
+}
 
-	if isConsole {
-		encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-		encoderConfig.ConsoleSeparator = " "
+// WithOpenSearchFlushInterval sets how often buffered entries are shipped.
+// Values <= 0 keep the default of 10 seconds.
+func WithOpenSearchFlushInterval(d time.Duration) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchFlushInterval = d
 	}
+}
 
-	return zapcore.NewConsoleEncoder(encoderConfig)
+// WithStatsReportInterval makes the OpenSearch logger log its indexer stats
+// (added, flushed, failed and dropped entries) at info level every d, so
+// throughput can be followed without external metrics. Reporting stops when the
+// CleanUp function is called. Zero, the default, disables the report.
+func WithStatsReportInterval(d time.Duration) LogOptFunc {
+	return func(o *LogOpts) {
+		o.statsReportInterval = d
+	}
+}
+
+// WithDedup suppresses the repeats of an entry shipped to OpenSearch for window,
+// e.g. the same error logged thousands of times by a hot loop. Entries repeat
+// if their message, level and the values of fields match. Once the window
+// closes, a single summary is shipped for the suppressed ones: a copy of the
+// first entry with " (repeated N times)" appended to its message and the count
+// in the "repeated" field. The CleanUp function closes the open windows. The
+// console and file outputs aren't deduplicated.
+func WithDedup(window time.Duration, fields ...string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.dedupWindow = window
+		o.dedupFields = append([]string{}, fields...)
+	}
+}
+
+// WithMessageKey sets the key of the message in OpenSearch documents, "msg" by
+// default, e.g. to match an existing index mapping. The other outputs keep the
+// default keys.
+func WithMessageKey(key string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.messageKey = key
+	}
+}
+
+// WithLevelKey sets the key of the level in OpenSearch documents, "level" by
+// default.
+func WithLevelKey(key string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.levelKey = key
+	}
+}
+
+// WithTimeKey sets the key of the timestamp in OpenSearch documents, "ts" by
+// default. Index templates must map the new key as a date.
+func WithTimeKey(key string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.timeKey = key
+	}
+}
+
+// WithNameKey sets the key of the logger name in OpenSearch documents, "logger"
+// by default.
+func WithNameKey(key string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.nameKey = key
+	}
+}
+
+// WithCallerKey sets the key of the caller in OpenSearch documents, "caller" by
+// default.
+func WithCallerKey(key string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.callerKey = key
+	}
+}
+
+// WithFingerprint adds a "fingerprint" field to OpenSearch documents, a stable
+// hash of the message and the given fields, so recurring events can be grouped
+// in dashboards. Volatile fields like timestamps or IDs should not be listed.
+func WithFingerprint(fields ...string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.fingerprintFields = append([]string{}, fields...)
+	}
+}
+
+// WithAppendOnly indexes log entries with the bulk "create" action instead of
+// "index", so an existing document is never overwritten: a document whose ID is
+// already taken is rejected by OpenSearch. This suits immutable audit logs, see
+// WithOpenSearchDocumentID.
+func WithAppendOnly(appendOnly bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.appendOnly = appendOnly
+	}
+}
+
+// WithTimestampSkewTolerance warns on the internal logger, at most once a minute,
+// when entries shipped to OpenSearch carry a timestamp further than tolerance in
+// the future or past, which hints at clock drift or bad timestamps.
+func WithTimestampSkewTolerance(tolerance time.Duration) LogOptFunc {
+	return func(o *LogOpts) {
+		o.timestampSkewTolerance = tolerance
+	}
+}
+
+// WithRequiredFields checks that log entries shipped to OpenSearch carry the
+// given fields, e.g. tenant_id for compliance. Documents missing any of them get
+// a "missing_fields" field listing the gaps, or are dropped with
+// WithDropIncomplete.
+func WithRequiredFields(keys ...string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.requiredFields = append([]string{}, keys...)
+	}
+}
+
+// WithDropIncomplete drops entries missing a field set by WithRequiredFields
+// instead of flagging them, with a warning on the internal logger.
+func WithDropIncomplete(drop bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.dropIncomplete = drop
+	}
+}
+
+// WithIndexTemplate creates the index template name with body, as sent to the
+// _index_template API, when the logger is created, so the mappings of the
+// indices are consistent from their first entry. An existing template is kept
+// unless WithIndexTemplateForce is set. Failures are returned by
+// NewZapLoggerWithOpenSearch.
+func WithIndexTemplate(name string, body map[string]interface{}) LogOptFunc {
+	return func(o *LogOpts) {
+		o.indexTemplateName = name
+		o.indexTemplateBody = body
+	}
+}
+
+// WithIndexTemplateForce replaces an existing template of WithIndexTemplate.
+func WithIndexTemplateForce(force bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.indexTemplateForce = force
+	}
+}
+
+// WithWarmThreshold ships the entries whose timestamp is older than age, e.g.
+// backfilled logs, to the {base}-warm-{date} index instead of the standard one,
+// so it can be allocated to warm nodes. It doesn't apply to data streams.
+func WithWarmThreshold(age time.Duration) LogOptFunc {
+	return func(o *LogOpts) {
+		o.warmThreshold = age
+	}
+}
+
+// WithTenantField ships every entry to the index of the tenant named by its key
+// field, e.g. logs-acme-2024.01.25 for {"tenant_id":"acme"}, and the entries
+// without it to the index of defaultTenant, "default" if empty. Tenant values
+// are sanitized like IndexConfig.Sanitize and hashed if longer than 32 bytes.
+// To bound the number of indices, the entries of the tenants beyond the first
+// maxTenants, 100 if 0, go to the default tenant's index as well.
+//
+// Size based rollover doesn't apply to tenant indices, nor does tenant routing
+// to data streams and managed rollover.
+func WithTenantField(key, defaultTenant string, maxTenants int) LogOptFunc {
+	return func(o *LogOpts) {
+		o.tenantField = key
+		o.tenantDefault = defaultTenant
+		o.maxTenants = maxTenants
+	}
+}
+
+// WithSampling caps the volume shipped to OpenSearch during bursts: of the
+// entries with the same level and message within tick, the first are shipped,
+// then every thereafter-th. The console output is not sampled.
+func WithSampling(tick time.Duration, first, thereafter int) LogOptFunc {
+	return func(o *LogOpts) {
+		o.samplingTick = tick
+		o.samplingFirst = first
+		o.samplingThereafter = thereafter
+	}
+}
+
+// WithDropHook calls hook with every entry discarded by WithSampling, the rate
+// limits or WithOpenSearchMaxDocSize, e.g. to count them in a metric. Entries
+// dropped by the OpenSearch output are rebuilt from their encoded form, so only
+// their level, time, logger name and message are set. The hook runs on the
+// logging path: it must return quickly and must not log through the logger.
+func WithDropHook(hook func(entry zapcore.Entry)) LogOptFunc {
+	return func(o *LogOpts) {
+		o.dropHook = hook
+	}
+}
+
+// WithRedactFields replaces the values of the given fields with mask before
+// entries are shipped to OpenSearch or written to the fallback file, e.g. for
+// emails or tokens. Dotted keys like "user.email" reach into nested objects.
+func WithRedactFields(keys []string, mask string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.redactFields = append([]string{}, keys...)
+		o.redactMask = mask
+	}
+}
+
+// WithRedactFunc lets fn modify the decoded entries before they are shipped to
+// OpenSearch or written to the fallback file, for redaction rules beyond
+// WithRedactFields, which is applied first.
+func WithRedactFunc(fn func(entry map[string]interface{})) LogOptFunc {
+	return func(o *LogOpts) {
+		o.redactFuncs = append(o.redactFuncs, fn)
+	}
+}
+
+// WithOpenSearchCompression gzips the bulk requests sent to OpenSearch, like
+// opensearch.Config.CompressRequestBody, and accounts for the bytes saved in the
+// indexer stats, see IndexerStats.CompressionRatio.
+func WithOpenSearchCompression(compress bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchCompression = compress
+	}
+}
+
+// WithOpenSearchRateLimit caps the size of the entries shipped to OpenSearch to
+// bytesPerSec, allowing bursts of up to burst bytes, so a misbehaving service
+// can't overwhelm a shared cluster. Excess entries are dropped unless
+// WithOpenSearchRateLimitPolicy says otherwise. A burst of zero or less allows
+// one second's worth of bytes. Entries larger than the burst are never shipped,
+// so it's raised to WithOpenSearchMaxDocSize if that's larger.
+func WithOpenSearchRateLimit(bytesPerSec, burst int64) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchRateLimit = bytesPerSec
+		o.openSearchRateBurst = burst
+	}
+}
+
+// WithOpenSearchRateLimitPolicy selects what happens to the entries exceeding
+// WithOpenSearchRateLimit.
+func WithOpenSearchRateLimitPolicy(policy RateLimitPolicy) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchRatePolicy = policy
+	}
+}
+
+// WithOpenSearchDocRateLimit caps the number of entries shipped to OpenSearch to
+// perSecond, however diverse they are, so a runaway loop can't flood the ingest
+// pipeline. Excess entries are dropped and counted in IndexerStats.Dropped.
+// Zero or less means unlimited. It complements the byte budget of
+// WithOpenSearchRateLimit.
+func WithOpenSearchDocRateLimit(perSecond int) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchDocRateLimit = perSecond
+	}
+}
+
+// WithOpenSearchBackpressure bounds the memory held by the OpenSearch output:
+// once maxPending entries were handed to the bulk indexer without OpenSearch
+// acknowledging or rejecting them yet, writing an entry blocks until the indexer
+// catches up, for at most the write timeout, see WithWriteTimeout. Concurrent
+// writes may exceed the bound by their number. Entries that time out are written to the
+// fallback file if one is set and dropped otherwise. This trades the latency of
+// log calls for bounded memory under extreme load.
+func WithOpenSearchBackpressure(maxPending int) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchMaxPending = maxPending
+	}
+}
+
+// WithOpenSearchCircuitBreaker stops shipping entries to OpenSearch after
+// failureThreshold consecutive failed bulk requests or refused entries, each
+// less than cooldown apart, instead of blocking every log call on the writer
+// timeout. Skipped entries go to the WithOpenSearchFallback file if set, and are
+// counted in IndexerStats.Dropped otherwise. After cooldown the entries go
+// through again until the next outcome closes or reopens the circuit.
+func WithOpenSearchCircuitBreaker(failureThreshold int, cooldown time.Duration) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchBreakerThreshold = failureThreshold
+		o.openSearchBreakerCooldown = cooldown
+	}
+}
+
+// WithFlushOnIdle flushes the entries buffered for OpenSearch once no new entry
+// arrived for idle, so the tail of a burst is shipped right away instead of
+// after the flush interval, see WithOpenSearchFlushInterval.
+func WithFlushOnIdle(idle time.Duration) LogOptFunc {
+	return func(o *LogOpts) {
+		o.flushOnIdle = idle
+	}
+}
+
+// WithFlushNotify signals ch after every bulk request that delivered entries to
+// OpenSearch, so tests and coordinating code can wait for delivery instead of
+// sleeping. Signals are dropped rather than blocking the bulk indexer while ch
+// is full, so give it a buffer or keep receiving.
+func WithFlushNotify(ch chan<- struct{}) LogOptFunc {
+	return func(o *LogOpts) {
+		o.flushNotify = ch
+	}
+}
+
+// WithOpenSearchAsyncInit creates the OpenSearch output in the background, so
+// the logger is returned right away with its console output active. Entries
+// logged in the meantime are buffered, up to a limit, and shipped once the
+// output is ready. Initialization errors are reported to the internal logger
+// and by the CleanUp function instead of the constructor.
+func WithOpenSearchAsyncInit(async bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchAsyncInit = async
+	}
+}
+
+// WithOpenSearchReconnect keeps the logger working when OpenSearch is down at
+// startup: NewZapLoggerWithOpenSearch then writes to the lumberjack file, see
+// WithLjFilename, and to the console, and tries to reach OpenSearch every
+// interval in the background, switching to it once it's reachable. Entries
+// logged meanwhile stay in the file. The CleanUp function stops the retries.
+func WithOpenSearchReconnect(interval time.Duration) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchReconnect = interval
+	}
+}
+
+// WithOpenSearchTransport sets the HTTP transport of the OpenSearch client, e.g.
+// to go through an egress proxy or to tune connection pooling. It takes precedence
+// over the transport of the config given to WithOpenSearchConfig and over
+// WithInsecure, which only applies to the default *http.Transport.
+func WithOpenSearchTransport(rt http.RoundTripper) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchTransport = rt
+	}
+}
+
+// WithOpenSearchTLS configures mutual TLS for the OpenSearch client. certFile and
+// keyFile hold the PEM encoded client key pair, caFile the CA bundle used to verify
+// the cluster; either pair or caFile may be empty. The files are loaded when the
+// logger is created and failures are returned by NewZapLoggerWithOpenSearch.
+// It can't be combined with WithOpenSearchTransport.
+func WithOpenSearchTLS(certFile, keyFile, caFile string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchTLS = &tlsFiles{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	}
+}
+
+// WithOpenSearchProxy sends the requests to OpenSearch through the HTTP proxy at
+// proxyURL, keeping the TLS settings of the transport. It can't be combined with
+// WithOpenSearchTransport.
+func WithOpenSearchProxy(proxyURL string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchProxy = proxyURL
+	}
+}
+
+// WithInsecure skips TLS certificate verification of the OpenSearch client. It
+// modifies a copy of the config's *http.Transport and is ignored when a custom
+// transport is set with WithOpenSearchTransport.
+func WithInsecure(insecure bool) LogOptFunc {
+	return func(o *LogOpts) {
+		o.openSearchInsecure = insecure
+	}
+}
+
+// WithInternalLogger sets the logger reporting the problems and events of the
+// OpenSearch output, e.g. failed bulk requests. By default, warnings and above
+// are written to stderr, see WithInternalLoggerWriter; pass zap.NewNop() to
+// silence them.
+func WithInternalLogger(logger *zap.Logger) LogOptFunc {
+	return func(o *LogOpts) {
+		o.internalLogger = logger
+	}
+}
+
+// WithInternalLoggerWriter redirects the default internal logger, which writes
+// warnings and above to stderr. It has no effect with WithInternalLogger.
+func WithInternalLoggerWriter(w io.Writer) LogOptFunc {
+	return func(o *LogOpts) {
+		o.internalLoggerWriter = w
+	}
+}
+
+// WithInternalLoggerLevel raises the level of the internal logger, e.g. to Error
+// to only report failures. It sets the level of the default internal logger,
+// Warn otherwise, but can't lower the level of the logger given to
+// WithInternalLogger.
+func WithInternalLoggerLevel(level zapcore.Level) LogOptFunc {
+	return func(o *LogOpts) {
+		o.internalLoggerLevel = &level
+	}
+}
+
+// WithBufferedIO buffers the writes of the file and console outputs in memory,
+// up to size bytes and for flushInterval at most, taking the disk latency off the
+// logging hot path. A zero size or interval picks zap's defaults, 256 kB and 30s.
+// The buffers are flushed by Sync and the flush functions of
+// MustNewZapLoggerWithFlush and NewZapLoggerWithOpenSearch, which also stop
+// their flush timers: call them once done logging, entries still buffered when
+// the process exits are lost. The OpenSearch output batches its
+// entries already and isn't buffered.
+func WithBufferedIO(size int, flushInterval time.Duration) LogOptFunc {
+	return func(o *LogOpts) {
+		o.bufferedIO = true
+		o.bufferSize = size
+		o.bufferFlushInterval = flushInterval
+	}
+}
+
+// WithName names the logger, like zap.Logger.Named.
+func WithName(name string) LogOptFunc {
+	return func(o *LogOpts) {
+		o.name = name
+	}
+}
+
+// WithFields adds fields to every entry of the logger, like zap.Logger.With.
+func WithFields(fields ...zap.Field) LogOptFunc {
+	return func(o *LogOpts) {
+		o.fields = append(o.fields, fields...)
+	}
+}
+
+// WithHandle binds h to the logger built by the constructor.
+func WithHandle(h *Handle) LogOptFunc {
+	return func(o *LogOpts) {
+		o.handle = h
+	}
+}
+
+// WithMetrics reports the outcome of shipped log entries to m as they happen.
+func WithMetrics(m Metrics) LogOptFunc {
+	return func(o *LogOpts) {
+		o.metrics = m
+	}
+}
+
+// WithCleanUpHook runs hook every time the CleanUp function returned by
+// NewZapLoggerWithOpenSearch has flushed, even if flushing failed, with the
+// counters of the OpenSearch output. Errors of hook are returned by CleanUp.
+// It lets short-lived jobs report their logging outcome on shutdown.
+func WithCleanUpHook(hook func(ctx context.Context, stats IndexerStats) error) LogOptFunc {
+	return func(o *LogOpts) {
+		o.cleanUpHooks = append(o.cleanUpHooks, hook)
+	}
+}
+
+func MustNewLoggerDebug(opts ...LogOptFunc) *zap.Logger {
+	opts = append(opts, WithLogLevel(zapcore.DebugLevel))
+	return MustNewZapLogger(opts...)
+}
+
+// MustNewZapLoggerWithFlush creates a zap logger and returns it along with a flush function.
+// This function wraps MustNewZapLogger to provide a consistent interface with MustNewZapLoggerWithOpenSearch.
+// The flush function syncs the logger, writing out the entries buffered with WithBufferedIO,
+// and stops the flush timers of the buffers. It also removes the WithOnLevelChange callbacks
+// of the logger.
+func MustNewZapLoggerWithFlush(opts ...LogOptFunc) (*zap.Logger, CleanUp) {
+	opt := newZapLogOpts(opts...)
+
+	logger, err := newZapLogger(opt)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	flushFunc := func(context.Context) error {
+		defer opt.unregisterLevelHooks()
+
+		return errors.Join(logger.Sync(), opt.stopBuffers())
+	}
+
+	return logger, flushFunc
+}
+
+// MustNewZapLogger create a simple zap logger. It panics if no output is
+// enabled, see NewZapLogger.
+func MustNewZapLogger(opts ...LogOptFunc) *zap.Logger {
+	logger, err := NewZapLogger(opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return logger
+}
+
+// NewZapLogger is like MustNewZapLogger, but returns ErrNoLoggingOutputs instead
+// of panicking when neither the file, the console nor the syslog output is
+// enabled.
+func NewZapLogger(opts ...LogOptFunc) (*zap.Logger, error) {
+	return newZapLogger(newZapLogOpts(opts...))
+}
+
+func newZapLogOpts(opts ...LogOptFunc) *LogOpts {
+	opt := &LogOpts{devEnv: true, level: zapcore.InfoLevel, withLJ: true, withConsole: true}
+	bindLogOpts(opt, opts...)
+
+	return opt
+}
+
+func newZapLogger(opt *LogOpts) (*zap.Logger, error) {
+	if err := opt.validateLevelHooks(); err != nil {
+		return nil, err
+	}
+
+	teeFormatsBase := strings.TrimSuffix(opt.teeFormatsPath, filepath.Ext(opt.teeFormatsPath))
+
+	if opt.lumberJacker == nil && opt.teeFormatsPath != "" {
+		opt.lumberJacker = newLJ(teeFormatsBase + ".log")
+	}
+
+	var cores []zapcore.Core
+	if opt.withLJ {
+		cores = append(cores, newFileCore(opt))
+
+		if opt.teeFormatsPath != "" {
+			jsonSyncer := opt.buffered(zapcore.AddSync(newLJ(teeFormatsBase + ".json")))
+			cores = append(cores, zapcore.NewCore(genJSONEncoder(), jsonSyncer, opt.levelEnabler()))
+		}
+	}
+
+	if opt.withConsole {
+		cores = append(cores, newConsoleCore(opt))
+	}
+
+	if opt.syslog != nil {
+		cores = append(cores, newSyslogCore(opt))
+	}
+
+	cores = append(cores, opt.newExtraCores()...)
+
+	if len(cores) == 0 {
+		return nil, ErrNoLoggingOutputs
+	}
+
+	coreTee := opt.wrapCore(zapcore.NewTee(cores...))
+	logger := opt.newLogger(coreTee)
+
+	if opt.devEnv {
+		ReplaceGlobalToShowLogZapL(logger)
+	}
+
+	return logger, nil
+}
+
+// newExtraCores builds the outputs added with WithCore.
+func (o *LogOpts) newExtraCores() []zapcore.Core {
+	cores := make([]zapcore.Core, 0, len(o.extraCores))
+	for _, newCore := range o.extraCores {
+		cores = append(cores, newCore(o.levelEnabler()))
+	}
+
+	return cores
+}
+
+// levelEnabler returns the level shared by the cores of the logger.
+func (o *LogOpts) levelEnabler() zap.AtomicLevel {
+	if o.atomicLevel == nil {
+		lvl := zap.NewAtomicLevelAt(o.level)
+		o.atomicLevel = &lvl
+	}
+
+	return *o.atomicLevel
+}
+
+// newLogger builds the logger writing to core and binds it to the handle.
+func (o *LogOpts) newLogger(core zapcore.Core) *zap.Logger {
+	logger := zap.New(core, o.zapOptions()...)
+
+	if len(o.onLevelChange) > 0 {
+		o.removeLevelHooks = addLevelHooks(o.levelEnabler(), o.onLevelChange...)
+	}
+
+	if o.handle != nil {
+		o.handle.bindLogger(logger, o.contextFields())
+	}
+
+	return o.decorate(logger)
+}
+
+// validateLevelHooks rejects WithOnLevelChange without WithAtomicLevel, whose
+// callbacks could never be called. It must run before levelEnabler.
+func (o *LogOpts) validateLevelHooks() error {
+	if len(o.onLevelChange) > 0 && o.atomicLevel == nil {
+		return ErrOnLevelChangeWithoutAtomicLevel
+	}
+
+	return nil
+}
+
+// unregisterLevelHooks removes the WithOnLevelChange callbacks of the logger.
+func (o *LogOpts) unregisterLevelHooks() {
+	if o.removeLevelHooks != nil {
+		o.removeLevelHooks()
+	}
+}
+
+// zapOptions returns the options of WithCaller, WithCallerSkip and WithStacktrace.
+func (o *LogOpts) zapOptions() []zap.Option {
+	opts := []zap.Option{zap.WithCaller(!o.disableCaller)}
+
+	if o.callerSkip != 0 {
+		opts = append(opts, zap.AddCallerSkip(o.callerSkip))
+	}
+
+	if o.stacktraceLevel != nil {
+		opts = append(opts, zap.AddStacktrace(*o.stacktraceLevel))
+	}
+
+	return opts
+}
+
+// decorate applies WithName and WithFields to logger.
+func (o *LogOpts) decorate(logger *zap.Logger) *zap.Logger {
+	if o.name != "" {
+		logger = logger.Named(o.name)
+	}
+
+	if len(o.fields) > 0 {
+		logger = logger.With(o.fields...)
+	}
+
+	return logger
+}
+
+// sample wraps core in the sampler set up with WithSampling, if any.
+func (o *LogOpts) sample(core zapcore.Core) zapcore.Core {
+	if o.samplingTick <= 0 {
+		return core
+	}
+
+	var opts []zapcore.SamplerOption
+	if o.dropHook != nil {
+		opts = append(opts, zapcore.SamplerHook(func(ent zapcore.Entry, dec zapcore.SamplingDecision) {
+			if dec&zapcore.LogDropped != 0 {
+				o.dropHook(ent)
+			}
+		}))
+	}
+
+	return &sampledCore{Core: zapcore.NewSamplerWithOptions(core, o.samplingTick, o.samplingFirst, o.samplingThereafter, opts...)}
+}
+
+// sampledCore samples the entries written to a zap sampler without going
+// through Check as well, e.g. by the cores of wrapCore.
+type sampledCore struct {
+	zapcore.Core
+}
+
+func (c *sampledCore) With(fields []zapcore.Field) zapcore.Core {
+	return &sampledCore{Core: c.Core.With(fields)}
+}
+
+func (c *sampledCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if c.Core.Check(ent, nil) == nil {
+		return nil
+	}
+
+	return c.Core.Write(ent, fields)
+}
+
+// wrapCore applies the options that act on the combined core of a logger.
+func (o *LogOpts) wrapCore(core zapcore.Core) zapcore.Core {
+	if o.callerFunction {
+		core = &callerFunctionCore{Core: core}
+	}
+
+	if o.originTag != "" {
+		core = &originCore{Core: core, origin: o.originTag}
+	}
+
+	if o.maxEntries > 0 {
+		core = newLimitCore(core, o.maxEntries)
+	}
+
+	// must stay the outermost core so With can find it
+	if len(o.contextKeys) > 0 || len(o.contextExtractors) > 0 {
+		core = &contextCore{Core: core, contextFields: o.contextFields()}
+	}
+
+	return core
+}
+
+func genProdEncoderConfig() zapcore.EncoderConfig {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+
+	return encoderConfig
+}
+
+func genDevEncoderConfig(isConsole bool) zapcore.EncoderConfig {
+	encoderConfig := zap.NewDevelopmentEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout("15:04:05")
+	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+
+	if isConsole {
+		encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		encoderConfig.ConsoleSeparator = " "
+	}
+
+	return encoderConfig
+}
+
+func genJSONEncoder() zapcore.Encoder {
+	return zapcore.NewJSONEncoder(genJSONEncoderConfig())
+}
+
+func genJSONEncoderConfig() zapcore.EncoderConfig {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	return encoderConfig
+}
+
+// newFileCore builds the core writing to the lumberjack file, /tmp/zlog.log
+// unless set with WithLjFilename or WithLumberjack.
+func newFileCore(opt *LogOpts) zapcore.Core {
+	if opt.lumberJacker == nil {
+		filename := "/tmp/zlog.log"
+		if opt.ljFilename != "" {
+			filename = opt.ljFilename
+		}
+
+		opt.lumberJacker = newLJ(filename)
+	}
+
+	encoderConfig := genProdEncoderConfig()
+	if opt.devEnv {
+		encoderConfig = genDevEncoderConfig(false)
+	}
+
+	lumberJackEnc := zapcore.NewConsoleEncoder(opt.textEncoderConfig(encoderConfig))
+
+	return zapcore.NewCore(lumberJackEnc, opt.buffered(zapcore.AddSync(opt.lumberJacker)), opt.levelEnabler())
+}
+
+// textEncoderConfig applies WithTimeEncoder and WithConsoleSeparator to the
+// config of a console encoder.
+func (o *LogOpts) textEncoderConfig(config zapcore.EncoderConfig) zapcore.EncoderConfig {
+	if o.timeEncoder != nil {
+		config.EncodeTime = o.timeEncoder
+	}
+
+	if o.consoleSeparator != "" {
+		config.ConsoleSeparator = o.consoleSeparator
+	}
+
+	return config
+}
+
+// buffered wraps ws in a buffer with WithBufferedIO.
+func (o *LogOpts) buffered(ws zapcore.WriteSyncer) zapcore.WriteSyncer {
+	if !o.bufferedIO {
+		return ws
+	}
+
+	syncer := &zapcore.BufferedWriteSyncer{
+		WS:            ws,
+		Size:          o.bufferSize,
+		FlushInterval: o.bufferFlushInterval,
+	}
+	o.bufferedSyncers = append(o.bufferedSyncers, syncer)
+
+	return syncer
+}
+
+// stopBuffers flushes the WithBufferedIO buffers and stops their flush timers.
+// Entries logged afterwards are only written once a buffer fills up or on Sync.
+func (o *LogOpts) stopBuffers() error {
+	var errs []error
+
+	for _, syncer := range o.bufferedSyncers {
+		if err := syncer.Stop(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// newInternalLogger builds the default internal logger, writing entries of level
+// and above to w, or stderr if nil.
+func newInternalLogger(w io.Writer, level zapcore.Level) *zap.Logger {
+	out := stderr
+	if w != nil {
+		out = zapcore.AddSync(w)
+	}
+
+	core := zapcore.NewCore(zapcore.NewConsoleEncoder(genProdEncoderConfig()), zapcore.Lock(out), level)
+
+	return zap.New(core).Named("zlog")
+}
+
+// newConsoleCore builds the core writing to stdout, and to stderr for errors
+// with WithStderr.
+func newConsoleCore(opt *LogOpts) zapcore.Core {
+	core := zapcore.NewCore(genConsoleEncoder(opt), opt.buffered(consoleSyncer{stdout}), opt.levelEnabler())
+	if opt.withStderr {
+		errCore := zapcore.NewCore(genConsoleEncoder(opt), opt.buffered(consoleSyncer{stderr}), opt.levelEnabler())
+		core = zapcore.NewTee(
+			newLevelFilterCore(core, zap.LevelEnablerFunc(func(l zapcore.Level) bool { return l < zapcore.ErrorLevel })),
+			newLevelFilterCore(errCore, zapcore.ErrorLevel),
+		)
+	}
+
+	if len(opt.consoleHiddenFields) > 0 {
+		core = newHiddenFieldsCore(core, opt.consoleHiddenFields)
+	}
+
+	return core
+}
+
+// consoleSyncer ignores the errors of syncing a terminal or pipe, which can't be
+// synced, so syncing the logger only reports real failures.
+type consoleSyncer struct {
+	zapcore.WriteSyncer
+}
+
+func (s consoleSyncer) Sync() error {
+	err := s.WriteSyncer.Sync()
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTTY) {
+		return nil
+	}
+
+	return err
+}
+
+// genConsoleEncoder builds the encoder of the console core, colored in dev
+// environments or JSON with WithConsoleJSON.
+func genConsoleEncoder(opt *LogOpts) zapcore.Encoder {
+	if opt.consoleJSON {
+		return genJSONEncoder()
+	}
+
+	encoderConfig := genProdEncoderConfig()
+	if opt.devEnv {
+		encoderConfig = genDevEncoderConfig(true)
+	}
+
+	if opt.compactLevels {
+		encoderConfig.EncodeLevel = compactLevelEncoder(opt.devEnv)
+	}
+
+	encoderConfig = opt.textEncoderConfig(encoderConfig)
+
+	if opt.errorHighlight {
+		return newErrorHighlightEncoder(encoderConfig)
+	}
+
+	return zapcore.NewConsoleEncoder(encoderConfig)
+}
+
+var (
+	compactLevelNames = map[zapcore.Level]string{
+		zapcore.DebugLevel:  "D",
+		zapcore.InfoLevel:   "I",
+		zapcore.WarnLevel:   "W",
+		zapcore.ErrorLevel:  "E",
+		zapcore.DPanicLevel: "DP",
+		zapcore.PanicLevel:  "P",
+		zapcore.FatalLevel:  "F",
+	}
+
+	// same colors as zapcore.CapitalColorLevelEncoder
+	levelColors = map[zapcore.Level]int{
+		zapcore.DebugLevel:  35, // magenta
+		zapcore.InfoLevel:   34, // blue
+		zapcore.WarnLevel:   33, // yellow
+		zapcore.ErrorLevel:  31, // red
+		zapcore.DPanicLevel: 31,
+		zapcore.PanicLevel:  31,
+		zapcore.FatalLevel:  31,
+	}
+)
+
+// compactLevelEncoder serializes a level to its single letter tag, optionally colored.
+func compactLevelEncoder(colored bool) zapcore.LevelEncoder {
+	return func(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+		name, ok := compactLevelNames[l]
+		if !ok {
+			name = l.CapitalString()
+		}
+
+		if color, ok := levelColors[l]; ok && colored {
+			name = fmt.Sprintf("\x1b[%dm%s\x1b[0m", color, name)
+		}
+
+		enc.AppendString(name)
+	}
+}
+
+// errorHighlightEncoder renders the error field of console lines in red after
+// the other fields.
+type errorHighlightEncoder struct {
+	zapcore.Encoder
+	separator string
+	// the error added with With, if any
+	err string
+}
+
+func newErrorHighlightEncoder(config zapcore.EncoderConfig) *errorHighlightEncoder {
+	// the default of the console encoder
+	separator := config.ConsoleSeparator
+	if separator == "" {
+		separator = "\t"
+	}
+
+	return &errorHighlightEncoder{Encoder: zapcore.NewConsoleEncoder(config), separator: separator}
+}
+
+func (e *errorHighlightEncoder) Clone() zapcore.Encoder {
+	return &errorHighlightEncoder{Encoder: e.Encoder.Clone(), separator: e.separator, err: e.err}
+}
+
+// AddString receives the error fields added with With. The errorVerbose and
+// errorCauses fields zap adds along are kept with the other fields.
+func (e *errorHighlightEncoder) AddString(key, value string) {
+	if key == errorFieldKey {
+		e.err = value
+		return
+	}
+
+	e.Encoder.AddString(key, value)
+}
+
+func (e *errorHighlightEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	msg := e.err
+	rest := make([]zapcore.Field, 0, len(fields))
+
+	for _, field := range fields {
+		switch {
+		case field.Key == errorFieldKey && field.Type == zapcore.ErrorType:
+			var extra []zapcore.Field
+			msg, extra = splitErrorField(field)
+			rest = append(rest, extra...)
+		case field.Key == errorFieldKey && field.Type == zapcore.StringType:
+			msg = field.String
+		default:
+			rest = append(rest, field)
+		}
+	}
+
+	buf, err := e.Encoder.EncodeEntry(ent, rest)
+	if err != nil || msg == "" {
+		return buf, err
+	}
+
+	buf.TrimNewline()
+	buf.AppendString(e.separator)
+	buf.AppendString("\x1b[31merror: ")
+	buf.AppendString(msg)
+	buf.AppendString("\x1b[0m\n")
+
+	return buf, nil
+}
+
+// splitErrorField returns the message of an error field and, as separate fields,
+// the errorVerbose or errorCauses fields zap encodes along with it.
+func splitErrorField(field zapcore.Field) (string, []zapcore.Field) {
+	enc := zapcore.NewMapObjectEncoder()
+	field.AddTo(enc)
+
+	msg, _ := enc.Fields[field.Key].(string)
+	delete(enc.Fields, field.Key)
+
+	keys := make([]string, 0, len(enc.Fields))
+	for key := range enc.Fields {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	extra := make([]zapcore.Field, 0, len(keys))
+	for _, key := range keys {
+		extra = append(extra, zap.Any(key, enc.Fields[key]))
+	}
+
+	return msg, extra
 }
 
 func ReplaceGlobalToShowLogZapL(logger *zap.Logger) {
@@ -2,12 +2,23 @@ package zlog
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 const (
@@ -71,12 +82,15 @@ func TestLogToOpenSearch(t *testing.T) {
 		t.Skip(msg)
 	}
 
+	flushed := make(chan struct{}, 1)
+
 	defaultConfig := DefaultOpenSearchConfig(_testOpensearchURL, _testIsInsecure)
 	logger, flushFunc := MustNewZapLoggerWithOpenSearch(
 		WithOpenSearchConfig(&defaultConfig),
 		WithOpenSearchIndex("zlog-test", string(DateFormatDot)),
 		WithLogLevel(zapcore.InfoLevel),
 		WithConsole(true),
+		WithFlushNotify(flushed),
 	)
 
 	ctx, cancel := context.WithTimeout(context.Background(), _testOpensearchFlushTimeout)
@@ -119,8 +133,509 @@ func TestLogToOpenSearch(t *testing.T) {
 		if err := flushFunc(ctx); err != nil {
 			t.Errorf("Failed to flush logs: %v", err)
 		}
-		time.Sleep(100 * time.Millisecond) // Small delay between logs
+
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			t.Fatalf("Log message %d was not delivered", i+1)
+		}
 	}
 
 	t.Log("Logs sent to OpenSearch. Please verify in the OpenSearch dashboard.")
 }
+
+func TestOpenSearchPipelineLive(t *testing.T) {
+	// Check if OpenSearch is ready
+	if !IsOpenSearchReady(_testOpensearchURL, 5*time.Second, _testIsInsecure) {
+		msg := "OpenSearch is not ready. Skipping test."
+		fmt.Println(msg)
+		t.Skip(msg)
+	}
+
+	const pipeline = "zlog-test-pipeline"
+
+	defaultConfig := DefaultOpenSearchConfig(_testOpensearchURL, _testIsInsecure)
+	client, err := opensearch.NewClient(defaultConfig)
+	require.NoError(t, err)
+
+	res, err := client.Ingest.PutPipeline(pipeline,
+		strings.NewReader(`{"processors":[{"set":{"field":"enriched","value":true}}]}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.False(t, res.IsError(), res.Status())
+
+	logger, flushFunc := MustNewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&defaultConfig),
+		WithOpenSearchIndex(pipeline, string(DateFormatDot)),
+		WithOpenSearchPipeline(pipeline),
+	)
+
+	runID := fmt.Sprint(time.Now().UnixNano())
+	logger.Info("Pipeline message", zap.String("run_id", runID))
+
+	ctx, cancel := context.WithTimeout(context.Background(), _testOpensearchFlushTimeout)
+	defer cancel()
+
+	require.NoError(t, flushFunc(ctx))
+
+	res, err = client.Indices.Refresh(client.Indices.Refresh.WithIndex(pipeline + "-*"))
+	require.NoError(t, err)
+	res.Body.Close()
+
+	res, err = client.Search(client.Search.WithIndex(pipeline+"-*"), client.Search.WithQuery("run_id:"+runID))
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Source map[string]interface{} `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+	require.Len(t, result.Hits.Hits, 1)
+	assert.Equal(t, true, result.Hits.Hits[0].Source["enriched"])
+}
+
+// captureStdout redirects the console core into a buffer for the duration of the test.
+func captureStdout(t *testing.T) *zaptest.Buffer {
+	t.Helper()
+
+	buf := &zaptest.Buffer{}
+	original := stdout
+	stdout = buf
+
+	t.Cleanup(func() { stdout = original })
+
+	return buf
+}
+
+func TestStderr(t *testing.T) {
+	out := captureStdout(t)
+
+	errOut := &zaptest.Buffer{}
+	original := stderr
+	stderr = errOut
+
+	t.Cleanup(func() { stderr = original })
+
+	logger := MustNewZapLogger(WithDevEnv(false), WithLJ(false), WithStderr(true), WithConsoleHiddenFields("trace_id"))
+	logger.Debug("hidden")
+	logger.Info("fine")
+	logger.Warn("careful")
+	logger.Error("boom")
+
+	require.Len(t, out.Lines(), 2)
+	assert.Contains(t, out.Lines()[0], "fine")
+	assert.Contains(t, out.Lines()[1], "careful")
+	require.Len(t, errOut.Lines(), 1)
+	assert.Contains(t, errOut.Lines()[0], "boom")
+}
+
+func TestConsoleJSON(t *testing.T) {
+	buf := captureStdout(t)
+
+	logger := MustNewZapLogger(WithDevEnv(true), WithLJ(false), WithConsoleJSON(true))
+	logger.Warn("careful", zap.String("component", "json"))
+
+	lines := buf.Lines()
+	require.Len(t, lines, 1)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "warn", entry["level"])
+	assert.Equal(t, "careful", entry["msg"])
+	assert.Equal(t, "json", entry["component"])
+	assert.Contains(t, entry, "ts")
+	assert.Contains(t, entry, "caller")
+}
+
+func TestAtomicLevel(t *testing.T) {
+	buf := captureStdout(t)
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithConsole(true),
+		WithAtomicLevel(level),
+	)
+	require.NoError(t, err)
+
+	logger.Debug("filtered")
+	level.SetLevel(zapcore.DebugLevel)
+	logger.Debug("passes")
+
+	require.NoError(t, flushFunc(context.Background()))
+
+	lines := buf.Lines()
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], "passes")
+
+	docs := fake.Docs()
+	require.Len(t, docs, 1)
+	assert.Equal(t, "passes", docs[0].Source["msg"])
+}
+
+func TestCompactLevels(t *testing.T) {
+	buf := captureStdout(t)
+
+	logger := MustNewZapLogger(WithDevEnv(false), WithLJ(false), WithCompactLevels(true))
+	logger.Error("boom")
+	logger.Info("fine")
+
+	lines := buf.Lines()
+	require.Len(t, lines, 2)
+	assert.Equal(t, "E", strings.Split(lines[0], "\t")[1])
+	assert.Equal(t, "I", strings.Split(lines[1], "\t")[1])
+}
+
+func TestTimeEncoderAndSeparator(t *testing.T) {
+	buf := captureStdout(t)
+
+	logger := MustNewZapLogger(WithDevEnv(false), WithLJ(false),
+		WithTimeEncoder(zapcore.RFC3339NanoTimeEncoder), WithConsoleSeparator(" | "))
+	logger.Info("fine")
+
+	lines := buf.Lines()
+	require.Len(t, lines, 1)
+
+	parts := strings.Split(lines[0], " | ")
+	require.GreaterOrEqual(t, len(parts), 3)
+	_, err := time.Parse(time.RFC3339Nano, parts[0])
+	require.NoError(t, err, parts[0])
+	assert.Equal(t, "INFO", parts[1])
+	assert.Equal(t, "fine", parts[len(parts)-1])
+}
+
+func TestCompactLevelsColored(t *testing.T) {
+	enc := zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
+		LevelKey:    "level",
+		EncodeLevel: compactLevelEncoder(true),
+	})
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{Level: zapcore.ErrorLevel}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "\x1b[31mE\x1b[0m\n", buf.String())
+}
+
+func TestLumberjack(t *testing.T) {
+	dir := t.TempDir()
+	lj := &lumberjack.Logger{Filename: filepath.Join(dir, "custom.log"), MaxSize: 1}
+
+	t.Cleanup(func() { _ = lj.Close() })
+
+	logger := MustNewZapLogger(
+		WithDevEnv(false),
+		WithConsole(false),
+		WithLjFilename(filepath.Join(dir, "ignored.log")),
+		WithLumberjack(lj),
+	)
+	logger.Info("rotated by the caller")
+
+	lines := readLines(t, lj.Filename)
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], "rotated by the caller")
+	assert.NoFileExists(t, filepath.Join(dir, "ignored.log"))
+}
+
+func TestTeeFormats(t *testing.T) {
+	dir := t.TempDir()
+
+	logger := MustNewZapLogger(
+		WithDevEnv(false),
+		WithConsole(false),
+		WithTeeFormats(filepath.Join(dir, "app.log")),
+	)
+	logger.Info("first entry", zap.String("component", "tee"))
+	logger.Warn("second entry", zap.Int("attempt", 2))
+
+	consoleLines := readLines(t, filepath.Join(dir, "app.log"))
+	jsonLines := readLines(t, filepath.Join(dir, "app.json"))
+	require.Len(t, consoleLines, 2)
+	require.Len(t, jsonLines, 2)
+
+	assert.Contains(t, consoleLines[0], "INFO\t")
+	assert.Contains(t, consoleLines[0], "first entry")
+	assert.Contains(t, consoleLines[0], `{"component": "tee"}`)
+	assert.Contains(t, consoleLines[1], "WARN\t")
+	assert.Contains(t, consoleLines[1], "second entry")
+
+	var first, second map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(jsonLines[0]), &first))
+	require.NoError(t, json.Unmarshal([]byte(jsonLines[1]), &second))
+
+	assert.Equal(t, "info", first["level"])
+	assert.Equal(t, "first entry", first["msg"])
+	assert.Equal(t, "tee", first["component"])
+	assert.Equal(t, "warn", second["level"])
+	assert.Equal(t, "second entry", second["msg"])
+	assert.Equal(t, float64(2), second["attempt"])
+}
+
+func readLines(t *testing.T, filename string) []string {
+	t.Helper()
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestErrorFieldHighlight(t *testing.T) {
+	buf := captureStdout(t)
+
+	logger := MustNewZapLogger(WithDevEnv(false), WithLJ(false), WithErrorFieldHighlight(true))
+	logger.Error("failed", zap.Error(errors.New("boom")), zap.Int("attempt", 2))
+	logger.With(zap.Error(errors.New("bad config"))).Warn("degraded")
+	logger.Info("fine")
+
+	lines := buf.Lines()
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasSuffix(lines[0], "\t\x1b[31merror: boom\x1b[0m"), lines[0])
+	assert.Contains(t, lines[0], `{"attempt": 2}`)
+	assert.NotContains(t, lines[0], `"error"`)
+	assert.True(t, strings.HasSuffix(lines[1], "\t\x1b[31merror: bad config\x1b[0m"), lines[1])
+	assert.NotContains(t, lines[2], "\x1b[")
+
+	buf.Reset()
+
+	MustNewZapLogger(WithDevEnv(false), WithLJ(false)).Error("failed", zap.Error(errors.New("boom")))
+	assert.Contains(t, buf.String(), `{"error": "boom"}`)
+	assert.NotContains(t, buf.String(), "\x1b[")
+}
+
+// stackError has a verbose format, like the errors of github.com/pkg/errors.
+type stackError struct{}
+
+func (stackError) Error() string { return "boom" }
+
+func (e stackError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		fmt.Fprint(s, "boom\nmain.run")
+		return
+	}
+
+	fmt.Fprint(s, e.Error())
+}
+
+func TestErrorFieldHighlightSeparatorAndVerbose(t *testing.T) {
+	buf := captureStdout(t)
+
+	logger := MustNewZapLogger(WithDevEnv(false), WithLJ(false), WithErrorFieldHighlight(true), WithConsoleSeparator(" | "))
+	logger.Error("failed", zap.Error(stackError{}))
+	logger.With(zap.Error(stackError{})).Error("failed")
+
+	lines := buf.Lines()
+	require.Len(t, lines, 2)
+
+	for _, line := range lines {
+		assert.True(t, strings.HasSuffix(line, " | \x1b[31merror: boom\x1b[0m"), line)
+		assert.Contains(t, line, `"errorVerbose": "boom\nmain.run"`, "on both paths")
+	}
+}
+
+func TestNewZapLoggerNoOutputs(t *testing.T) {
+	logger, err := NewZapLogger(WithLJ(false), WithConsole(false))
+	require.ErrorIs(t, err, ErrNoLoggingOutputs)
+	assert.Nil(t, logger)
+
+	assert.PanicsWithValue(t, ErrNoLoggingOutputs.Error(), func() {
+		MustNewZapLogger(WithLJ(false), WithConsole(false))
+	})
+
+	logger, err = NewZapLogger(WithLJ(false))
+	require.NoError(t, err)
+	assert.NotNil(t, logger)
+}
+
+func TestWithFields(t *testing.T) {
+	buf := captureStdout(t)
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+
+	logger, flushFunc, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithConsole(true),
+		WithFields(zap.String("service", "billing"), zap.String("version", "1.4.2"), zap.String("host", "node-7")),
+	)
+	require.NoError(t, err)
+
+	logger.Info("charged")
+	logger.With(zap.String("invoice", "42")).Warn("retrying")
+	require.NoError(t, flushFunc(context.Background()))
+
+	docs := fake.Docs()
+	require.Len(t, docs, 2)
+
+	for _, doc := range docs {
+		assert.Equal(t, "billing", doc.Source["service"])
+		assert.Equal(t, "1.4.2", doc.Source["version"])
+		assert.Equal(t, "node-7", doc.Source["host"])
+	}
+
+	for _, line := range buf.Lines() {
+		assert.Contains(t, line, `"service": "billing"`)
+	}
+}
+
+// logVia stands in for a wrapper library logging on behalf of its caller.
+func logVia(logger *zap.Logger, msg string) {
+	logger.Warn(msg)
+}
+
+func TestCallerOptions(t *testing.T) {
+	entries := func(buf *zaptest.Buffer) []map[string]interface{} {
+		var all []map[string]interface{}
+
+		for _, line := range buf.Lines() {
+			var entry map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+			all = append(all, entry)
+		}
+
+		buf.Reset()
+
+		return all
+	}
+
+	buf := captureStdout(t)
+
+	logger := MustNewZapLogger(WithLJ(false), WithConsoleJSON(true), WithCallerSkip(1))
+	_, _, line, _ := runtime.Caller(0)
+	logVia(logger, "skipped")
+
+	logged := entries(buf)
+	require.Len(t, logged, 1)
+	assert.True(t, strings.HasSuffix(toString(logged[0]["caller"]), fmt.Sprintf("zlog_test.go:%d", line+1)),
+		"the caller of logVia is reported, got %v", logged[0]["caller"])
+
+	logger = MustNewZapLogger(WithLJ(false), WithConsoleJSON(true), WithCaller(false), WithStacktrace(zapcore.ErrorLevel))
+	logger.Warn("no trace")
+	logger.Error("traced")
+
+	logged = entries(buf)
+	require.Len(t, logged, 2)
+	assert.NotContains(t, logged[0], "caller")
+	assert.NotContains(t, logged[0], "stacktrace")
+	assert.Contains(t, toString(logged[1]["stacktrace"]), "TestCallerOptions")
+}
+
+func TestBufferedIO(t *testing.T) {
+	out := captureStdout(t)
+
+	logger, flushFunc := MustNewZapLoggerWithFlush(WithLJ(false), WithBufferedIO(0, time.Hour))
+	logger.Info("buffered")
+
+	assert.Empty(t, out.String(), "entries wait for the flush")
+	require.NoError(t, flushFunc(context.Background()))
+	require.Len(t, out.Lines(), 1)
+	assert.Contains(t, out.Lines()[0], "buffered")
+
+	logger.Info("after the flush")
+	require.NoError(t, logger.Sync(), "the stopped buffers still write on Sync")
+	require.Len(t, out.Lines(), 2)
+
+	fake := newFakeOpenSearch(t)
+	config := fake.Config()
+	out.Reset()
+
+	logger, cleanUp, err := NewZapLoggerWithOpenSearch(
+		WithOpenSearchConfig(&config),
+		WithOpenSearchIndex("zlog-test", ""),
+		WithConsole(true),
+		WithBufferedIO(0, time.Hour),
+	)
+	require.NoError(t, err)
+
+	logger.Info("shipped")
+
+	assert.Empty(t, out.String())
+	require.NoError(t, cleanUp(context.Background()))
+	assert.Contains(t, out.String(), "shipped")
+	assert.Len(t, fake.Docs(), 1, "the OpenSearch output isn't buffered")
+}
+
+func TestBufferedIOEnabledOutputsOnly(t *testing.T) {
+	opt := newZapLogOpts(WithLJ(false), WithBufferedIO(0, time.Hour))
+
+	_, err := newZapLogger(opt)
+	require.NoError(t, err)
+
+	assert.Len(t, opt.bufferedSyncers, 1, "the disabled file output isn't built")
+	assert.Nil(t, opt.lumberJacker)
+	require.NoError(t, opt.stopBuffers())
+}
+
+func BenchmarkFileOutput(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []LogOptFunc
+	}{
+		{name: "unbuffered"},
+		{name: "buffered", opts: []LogOptFunc{WithBufferedIO(0, 0)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			opts := append([]LogOptFunc{
+				WithDevEnv(false),
+				WithConsole(false),
+				WithLjFilename(filepath.Join(b.TempDir(), "bench.log")),
+			}, bc.opts...)
+			logger, flushFunc := MustNewZapLoggerWithFlush(opts...)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				logger.Info("request served", zap.Int("status", 200), zap.String("path", "/api/orders"))
+			}
+
+			b.StopTimer()
+			require.NoError(b, flushFunc(context.Background()))
+		})
+	}
+}
+
+// syncCounter counts the syncs of a console output.
+type syncCounter struct {
+	zaptest.Buffer
+	syncs int
+}
+
+func (s *syncCounter) Sync() error {
+	s.syncs++
+	return nil
+}
+
+func TestFlushSyncs(t *testing.T) {
+	original := stdout
+	t.Cleanup(func() { stdout = original })
+
+	out := &syncCounter{}
+	stdout = out
+
+	logger, flushFunc := MustNewZapLoggerWithFlush(WithLJ(false))
+	logger.Info("synced")
+
+	require.NoError(t, flushFunc(context.Background()))
+	assert.Equal(t, 1, out.syncs)
+	assert.Contains(t, out.String(), "synced")
+
+	// pipes can't be synced, which isn't worth reporting
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		reader.Close()
+		writer.Close()
+	})
+
+	stdout = zapcore.AddSync(writer)
+
+	_, flushFunc = MustNewZapLoggerWithFlush(WithLJ(false))
+	assert.NoError(t, flushFunc(context.Background()))
+}
@@ -0,0 +1,91 @@
+// Package zlogaws signs the requests of the zlog OpenSearch output with AWS
+// Signature Version 4, as required by Amazon OpenSearch Service. It lives in its
+// own package so zlog itself doesn't depend on the AWS SDK.
+//
+// The signing identity needs the following IAM permissions on the domain,
+// e.g. arn:aws:es:<region>:<account>:domain/<domain>/*:
+//
+//   - es:ESHttpGet, to check the cluster on startup
+//   - es:ESHttpPost, to ship entries with the bulk API and roll indices over
+//   - es:ESHttpPut and es:ESHttpHead, with WithIndexTemplate or
+//     WithManagedRollover, to create templates, indices and aliases
+//
+// OpenSearch Serverless collections, signed for ServiceServerless, need
+// aoss:APIAccessAll and a data access policy granting aoss:WriteDocument,
+// aoss:CreateIndex and aoss:DescribeIndex on the indices.
+package zlogaws
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/coghost/zlog"
+)
+
+// Signing names of the OpenSearch services.
+const (
+	ServiceOpenSearch = "es"
+	ServiceServerless = "aoss"
+)
+
+// WithAWSSigV4 signs the requests sent to OpenSearch for service, usually
+// ServiceOpenSearch, in region with the credentials of creds, e.g. the
+// Credentials of an aws.Config. Pass an aws.CredentialsCache unless creds
+// caches the credentials itself.
+func WithAWSSigV4(region, service string, creds aws.CredentialsProvider) zlog.LogOptFunc {
+	return zlog.WithOpenSearchSigner(newSigner(region, service, creds))
+}
+
+// signer implements the signer.Signer interface of opensearch-go.
+type signer struct {
+	region  string
+	service string
+	creds   aws.CredentialsProvider
+	signer  *v4.Signer
+	// the signing time
+	clock func() time.Time
+}
+
+func newSigner(region, service string, creds aws.CredentialsProvider) *signer {
+	return &signer{
+		region:  region,
+		service: service,
+		creds:   creds,
+		signer:  v4.NewSigner(),
+		clock:   time.Now,
+	}
+}
+
+func (s *signer) SignRequest(req *http.Request) error {
+	var body []byte
+
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return fmt.Errorf("failed to read request body: %w", err)
+		}
+
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	creds, err := s.creds.Retrieve(req.Context())
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+
+	hash := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(hash[:])
+
+	// required by OpenSearch Serverless
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	return s.signer.SignHTTP(req.Context(), creds, req, payloadHash, s.service, s.region, s.clock())
+}
@@ -0,0 +1,130 @@
+package zlogaws
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/coghost/zlog"
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signedRequest is a request received by the fake cluster.
+type signedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// newFakeOpenSearch starts a server accepting bulk requests of a single item and
+// recording every request.
+func newFakeOpenSearch(t *testing.T) (*httptest.Server, <-chan signedRequest) {
+	t.Helper()
+
+	requests := make(chan signedRequest, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- signedRequest{method: r.Method, path: r.URL.Path, header: r.Header.Clone(), body: body}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if strings.HasSuffix(r.URL.Path, "/_bulk") {
+			_, _ = w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"version":{"number":"2.11.0","distribution":"opensearch"}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+func TestWithAWSSigV4(t *testing.T) {
+	server, requests := newFakeOpenSearch(t)
+	config := opensearch.Config{Addresses: []string{server.URL}}
+	creds := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"}, nil
+	})
+
+	signer := newSigner("eu-west-1", ServiceOpenSearch, creds)
+	signer.clock = func() time.Time { return time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC) }
+
+	logger, flushFunc, err := zlog.NewZapLoggerWithOpenSearch(
+		zlog.WithOpenSearchConfig(&config),
+		zlog.WithOpenSearchIndex("zlog-test", ""),
+		zlog.WithOpenSearchSigner(signer),
+	)
+	require.NoError(t, err)
+
+	logger.Info("signed")
+	require.NoError(t, flushFunc(context.Background()))
+
+	var bulk *signedRequest
+
+	for len(requests) > 0 {
+		req := <-requests
+
+		assert.True(t, strings.HasPrefix(req.header.Get("Authorization"),
+			"AWS4-HMAC-SHA256 Credential=AKID/20240125/eu-west-1/es/aws4_request, SignedHeaders="), req.path)
+		assert.Equal(t, "20240125T120000Z", req.header.Get("X-Amz-Date"))
+		assert.Equal(t, "token", req.header.Get("X-Amz-Security-Token"))
+
+		hash := sha256.Sum256(req.body)
+		assert.Equal(t, hex.EncodeToString(hash[:]), req.header.Get("X-Amz-Content-Sha256"), req.path)
+
+		if req.path == "/_bulk" {
+			bulk = &req
+		}
+	}
+
+	require.NotNil(t, bulk)
+	assert.Contains(t, string(bulk.body), "signed", "the body survives signing")
+}
+
+// TestAWSOpenSearchIntegration ships an entry to a real Amazon OpenSearch Service
+// domain, e.g. ZLOG_AWS_OPENSEARCH_URL=https://search-logs-xyz.eu-west-1.es.amazonaws.com,
+// with the credentials of the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN and AWS_REGION environment variables.
+func TestAWSOpenSearchIntegration(t *testing.T) {
+	url := os.Getenv("ZLOG_AWS_OPENSEARCH_URL")
+	if url == "" {
+		t.Skip("ZLOG_AWS_OPENSEARCH_URL is not set")
+	}
+
+	creds := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	})
+
+	config := opensearch.Config{Addresses: []string{url}}
+
+	var handle zlog.Handle
+
+	logger, flushFunc, err := zlog.NewZapLoggerWithOpenSearch(
+		zlog.WithOpenSearchConfig(&config),
+		zlog.WithOpenSearchIndex("zlog-integration", ""),
+		zlog.WithHandle(&handle),
+		WithAWSSigV4(os.Getenv("AWS_REGION"), ServiceOpenSearch, aws.NewCredentialsCache(creds)),
+	)
+	require.NoError(t, err)
+
+	logger.Info("zlogaws integration test")
+	require.NoError(t, flushFunc(context.Background()))
+	assert.Zero(t, handle.Stats().Failed)
+}
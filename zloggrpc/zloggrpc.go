@@ -0,0 +1,94 @@
+// Package zloggrpc logs the calls served by gRPC servers with zlog loggers. It
+// lives in its own package so zlog itself doesn't depend on gRPC.
+package zloggrpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/coghost/zlog"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor logs every unary call with its method, status code,
+// duration and peer, at error level for codes signaling a server problem. The
+// handler finds the request logger, which carries the method and the context
+// fields, see zlog.With, with zlog.FromContext.
+func UnaryServerInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		reqLogger := requestLogger(ctx, logger, info.FullMethod)
+
+		resp, err := handler(zlog.NewContext(ctx, reqLogger), req)
+		logCall(ctx, reqLogger, start, err)
+
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is like UnaryServerInterceptor for streams, logging
+// once the stream ended.
+func StreamServerInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx := stream.Context()
+		reqLogger := requestLogger(ctx, logger, info.FullMethod)
+
+		err := handler(srv, &contextStream{ServerStream: stream, ctx: zlog.NewContext(ctx, reqLogger)})
+		logCall(ctx, reqLogger, start, err)
+
+		return err
+	}
+}
+
+func requestLogger(ctx context.Context, logger *zap.Logger, method string) *zap.Logger {
+	return zlog.With(ctx, logger).With(zap.String("method", method))
+}
+
+func logCall(ctx context.Context, logger *zap.Logger, start time.Time, err error) {
+	code := status.Code(err)
+	fields := []zap.Field{
+		zap.String("code", code.String()),
+		zap.Duration("duration", time.Since(start)),
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+
+	if serverError(code) {
+		logger.Error("gRPC call", fields...)
+		return
+	}
+
+	logger.Info("gRPC call", fields...)
+}
+
+// serverError reports whether code signals a problem of the server rather than
+// of the request, like an HTTP 5xx status.
+func serverError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// contextStream replaces the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
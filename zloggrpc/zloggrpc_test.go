@@ -0,0 +1,97 @@
+package zloggrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coghost/zlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func peerContext() context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}})
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+	interceptor := UnaryServerInterceptor(zap.New(obs))
+	info := &grpc.UnaryServerInfo{FullMethod: "/billing.Invoices/Get"}
+
+	resp, err := interceptor(peerContext(), "req", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		zlog.FromContext(ctx).Info("loading invoice")
+		return "resp", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "resp", resp)
+
+	require.Equal(t, 2, logs.Len())
+	assert.Equal(t, map[string]interface{}{"method": "/billing.Invoices/Get"}, logs.All()[0].ContextMap(),
+		"the handler logs on the request logger")
+
+	call := logs.All()[1]
+	assert.Equal(t, "gRPC call", call.Message)
+	assert.Equal(t, zapcore.InfoLevel, call.Level)
+
+	fields := call.ContextMap()
+	assert.Contains(t, fields, "duration")
+	delete(fields, "duration")
+	assert.Equal(t, map[string]interface{}{
+		"method": "/billing.Invoices/Get",
+		"code":   "OK",
+		"peer":   "10.0.0.1:5000",
+	}, fields)
+
+	_, err = interceptor(peerContext(), "req", info, func(context.Context, interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "no such invoice")
+	})
+	require.Error(t, err)
+	assert.Equal(t, zapcore.InfoLevel, logs.All()[2].Level, "client errors aren't server errors")
+	assert.Equal(t, "NotFound", logs.All()[2].ContextMap()["code"])
+
+	_, err = interceptor(peerContext(), "req", info, func(context.Context, interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Internal, "database down")
+	})
+	require.Error(t, err)
+	assert.Equal(t, zapcore.ErrorLevel, logs.All()[3].Level)
+	assert.Equal(t, "Internal", logs.All()[3].ContextMap()["code"])
+}
+
+// fakeStream is a server stream with a fixed context.
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+	interceptor := StreamServerInterceptor(zap.New(obs))
+	info := &grpc.StreamServerInfo{FullMethod: "/billing.Invoices/Watch", IsServerStream: true}
+
+	err := interceptor(nil, &fakeStream{ctx: peerContext()}, info, func(_ interface{}, stream grpc.ServerStream) error {
+		zlog.FromContext(stream.Context()).Info("watching")
+		return status.Error(codes.Unavailable, "shutting down")
+	})
+	require.Error(t, err)
+
+	require.Equal(t, 2, logs.Len())
+	assert.Equal(t, "/billing.Invoices/Watch", logs.All()[0].ContextMap()["method"])
+
+	call := logs.All()[1]
+	assert.Equal(t, zapcore.ErrorLevel, call.Level)
+	assert.Equal(t, "Unavailable", call.ContextMap()["code"])
+	assert.Equal(t, "10.0.0.1:5000", call.ContextMap()["peer"])
+	assert.Equal(t, "rpc error: code = Unavailable desc = shutting down", call.ContextMap()["error"])
+}
@@ -0,0 +1,29 @@
+package zlogotel_test
+
+import (
+	"context"
+	"os"
+
+	"github.com/coghost/zlog"
+	"github.com/coghost/zlog/zlogotel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutlog"
+	"go.uber.org/zap"
+)
+
+// Exports the entries of the logger along with the console output. To ship them
+// to a collector, use an OTLP exporter instead, e.g. otlploggrpc.New.
+func ExampleWithOTelExporter() {
+	exporter, err := stdoutlog.New(stdoutlog.WithWriter(os.Stdout))
+	if err != nil {
+		panic(err)
+	}
+
+	logger, cleanUp := zlog.MustNewZapLoggerWithFlush(
+		zlog.WithLJ(false),
+		zlog.WithConsole(true),
+		zlogotel.WithOTelExporter(exporter),
+	)
+	defer cleanUp(context.Background())
+
+	logger.Info("order placed", zap.String("order_id", "o-1"))
+}
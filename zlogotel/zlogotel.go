@@ -0,0 +1,232 @@
+// Package zlogotel exports the entries of a zlog logger as OpenTelemetry log
+// records, e.g. to an OpenTelemetry collector, in addition to or instead of the
+// OpenSearch output. It lives in its own package so zlog itself doesn't depend
+// on OpenTelemetry.
+//
+// The example of WithOTelExporter shows the wiring. To export to a collector,
+// pass it an OTLP exporter instead, e.g. one created by otlploggrpc.New.
+//
+// Records carry the message as body, the level as severity and the fields of
+// the entry as attributes, along with the "logger", "caller" and "stacktrace"
+// of the entry if set.
+package zlogotel
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/coghost/zlog"
+	"go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// instrumentationName is the name of the OpenTelemetry logger.
+	instrumentationName = "github.com/coghost/zlog"
+	// flushTimeout bounds the flush of the records on Sync.
+	flushTimeout = 30 * time.Second
+)
+
+// WithOTelExporter exports the entries of the logger through exporter, batching
+// them with the OpenTelemetry SDK. Syncing the logger, e.g. with its CleanUp
+// function, exports the pending records. The resource of the records is the
+// SDK default, see WithOTelLoggerProvider to configure it.
+func WithOTelExporter(exporter sdklog.Exporter) zlog.LogOptFunc {
+	return WithOTelLoggerProvider(sdklog.NewLoggerProvider(sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter))))
+}
+
+// WithOTelLoggerProvider emits the entries of the logger to provider, e.g. the
+// one shared with the other telemetry of the service. Syncing the logger calls
+// the ForceFlush method of provider, if it has one.
+func WithOTelLoggerProvider(provider log.LoggerProvider) zlog.LogOptFunc {
+	return zlog.WithCore(func(level zapcore.LevelEnabler) zapcore.Core {
+		return NewCore(provider, level)
+	})
+}
+
+// NewCore returns a core emitting the entries enabled by level to provider, for
+// loggers built without zlog.
+func NewCore(provider log.LoggerProvider, level zapcore.LevelEnabler) zapcore.Core {
+	c := &core{LevelEnabler: level, logger: provider.Logger(instrumentationName)}
+
+	if flusher, ok := provider.(interface{ ForceFlush(context.Context) error }); ok {
+		c.flush = flusher.ForceFlush
+	}
+
+	return c
+}
+
+type core struct {
+	zapcore.LevelEnabler
+
+	logger log.Logger
+	// optional, exports the pending records
+	flush func(context.Context) error
+	// the fields added with With
+	fields []zapcore.Field
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+
+	return &clone
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	var record log.Record
+
+	record.SetTimestamp(ent.Time)
+	record.SetObservedTimestamp(time.Now())
+	record.SetSeverity(severity(ent.Level))
+	record.SetSeverityText(ent.Level.CapitalString())
+	record.SetBody(log.StringValue(ent.Message))
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range c.fields {
+		field.AddTo(enc)
+	}
+
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+
+	if ent.LoggerName != "" {
+		enc.AddString("logger", ent.LoggerName)
+	}
+
+	if ent.Caller.Defined {
+		enc.AddString("caller", ent.Caller.TrimmedPath())
+	}
+
+	if ent.Stack != "" {
+		enc.AddString("stacktrace", ent.Stack)
+	}
+
+	attrs := make([]log.KeyValue, 0, len(enc.Fields))
+	for key, value := range enc.Fields {
+		attrs = append(attrs, log.KeyValue{Key: key, Value: toValue(value)})
+	}
+
+	record.AddAttributes(attrs...)
+
+	c.logger.Emit(context.Background(), record)
+
+	return nil
+}
+
+func (c *core) Sync() error {
+	if c.flush == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	if err := c.flush(ctx); err != nil {
+		return fmt.Errorf("failed to flush OpenTelemetry log records: %w", err)
+	}
+
+	return nil
+}
+
+// severity maps zap levels to OpenTelemetry severities, the panic and fatal
+// levels to increasing fatal severities.
+func severity(level zapcore.Level) log.Severity {
+	switch level {
+	case zapcore.DebugLevel:
+		return log.SeverityDebug
+	case zapcore.InfoLevel:
+		return log.SeverityInfo
+	case zapcore.WarnLevel:
+		return log.SeverityWarn
+	case zapcore.ErrorLevel:
+		return log.SeverityError
+	case zapcore.DPanicLevel:
+		return log.SeverityFatal1
+	case zapcore.PanicLevel:
+		return log.SeverityFatal2
+	case zapcore.FatalLevel:
+		return log.SeverityFatal3
+	default:
+		return log.SeverityUndefined
+	}
+}
+
+// toValue converts a value of zapcore.MapObjectEncoder.
+func toValue(value interface{}) log.Value {
+	switch v := value.(type) {
+	case string:
+		return log.StringValue(v)
+	case bool:
+		return log.BoolValue(v)
+	case int:
+		return log.IntValue(v)
+	case int8:
+		return log.Int64Value(int64(v))
+	case int16:
+		return log.Int64Value(int64(v))
+	case int32:
+		return log.Int64Value(int64(v))
+	case int64:
+		return log.Int64Value(v)
+	case uint8:
+		return log.Int64Value(int64(v))
+	case uint16:
+		return log.Int64Value(int64(v))
+	case uint32:
+		return log.Int64Value(int64(v))
+	case uint:
+		return uintValue(uint64(v))
+	case uint64:
+		return uintValue(v)
+	case float32:
+		return log.Float64Value(float64(v))
+	case float64:
+		return log.Float64Value(v)
+	case []byte:
+		return log.BytesValue(v)
+	case time.Time:
+		return log.StringValue(v.Format(time.RFC3339Nano))
+	case time.Duration:
+		return log.StringValue(v.String())
+	case []interface{}:
+		values := make([]log.Value, 0, len(v))
+		for _, elem := range v {
+			values = append(values, toValue(elem))
+		}
+
+		return log.SliceValue(values...)
+	case map[string]interface{}:
+		kvs := make([]log.KeyValue, 0, len(v))
+		for key, elem := range v {
+			kvs = append(kvs, log.KeyValue{Key: key, Value: toValue(elem)})
+		}
+
+		return log.MapValue(kvs...)
+	default:
+		// e.g. reflected values
+		return log.StringValue(fmt.Sprint(v))
+	}
+}
+
+// uintValue converts v to an int64 value, or a string one if it would overflow.
+func uintValue(v uint64) log.Value {
+	if v > math.MaxInt64 {
+		return log.StringValue(strconv.FormatUint(v, 10))
+	}
+
+	return log.Int64Value(int64(v))
+}
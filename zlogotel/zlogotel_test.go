@@ -0,0 +1,123 @@
+package zlogotel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/coghost/zlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// recordingExporter keeps the records it exports.
+type recordingExporter struct {
+	mu      sync.Mutex
+	records []sdklog.Record
+}
+
+func (e *recordingExporter) Export(_ context.Context, records []sdklog.Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, record := range records {
+		e.records = append(e.records, record.Clone())
+	}
+
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(context.Context) error { return nil }
+
+func (e *recordingExporter) ForceFlush(context.Context) error { return nil }
+
+func (e *recordingExporter) Records() []sdklog.Record {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]sdklog.Record{}, e.records...)
+}
+
+func attributes(record sdklog.Record) map[string]log.Value {
+	attrs := map[string]log.Value{}
+	record.WalkAttributes(func(kv log.KeyValue) bool {
+		attrs[kv.Key] = kv.Value
+		return true
+	})
+
+	return attrs
+}
+
+func TestWithOTelExporter(t *testing.T) {
+	exporter := &recordingExporter{}
+
+	logger, cleanUp := zlog.MustNewZapLoggerWithFlush(
+		zlog.WithLJ(false),
+		zlog.WithConsole(false),
+		zlog.WithLogLevel(zapcore.InfoLevel),
+		zlog.WithName("billing"),
+		zlog.WithFields(zap.String("service", "api")),
+		WithOTelExporter(exporter),
+	)
+
+	logger.Debug("filtered")
+	logger.With(zap.Int("attempt", 2)).Warn("payment retried",
+		zap.Error(errors.New("card declined")),
+		zap.Object("order", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("id", "o-1")
+			enc.AddFloat64("total", 9.5)
+
+			return nil
+		})),
+		zap.Strings("tags", []string{"eu", "card"}),
+	)
+
+	assert.Empty(t, exporter.Records(), "batched until synced")
+	require.NoError(t, cleanUp(context.Background()))
+
+	records := exporter.Records()
+	require.Len(t, records, 1, "the level of the logger applies")
+
+	record := records[0]
+	assert.Equal(t, "payment retried", record.Body().AsString())
+	assert.Equal(t, log.SeverityWarn, record.Severity())
+	assert.Equal(t, "WARN", record.SeverityText())
+	assert.False(t, record.Timestamp().IsZero())
+	assert.Equal(t, instrumentationName, record.InstrumentationScope().Name)
+
+	attrs := attributes(record)
+	assert.Equal(t, "api", attrs["service"].AsString())
+	assert.Equal(t, int64(2), attrs["attempt"].AsInt64())
+	assert.Equal(t, "card declined", attrs["error"].AsString())
+	assert.Equal(t, "billing", attrs["logger"].AsString())
+	assert.Contains(t, attrs["caller"].AsString(), "zlogotel_test.go")
+	assert.Equal(t, []log.Value{log.StringValue("eu"), log.StringValue("card")}, attrs["tags"].AsSlice())
+	assert.ElementsMatch(t, []log.KeyValue{log.String("id", "o-1"), log.Float64("total", 9.5)}, attrs["order"].AsMap())
+}
+
+func TestAtomicLevel(t *testing.T) {
+	exporter := &recordingExporter{}
+	provider := sdklog.NewLoggerProvider(sdklog.WithProcessor(sdklog.NewSimpleProcessor(exporter)))
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
+	logger := zlog.MustNewZapLogger(
+		zlog.WithLJ(false),
+		zlog.WithConsole(false),
+		zlog.WithAtomicLevel(level),
+		WithOTelLoggerProvider(provider),
+	)
+
+	logger.Debug("hidden")
+	level.SetLevel(zapcore.DebugLevel)
+	logger.Debug("shown")
+
+	records := exporter.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, "shown", records[0].Body().AsString())
+	assert.Equal(t, log.SeverityDebug, records[0].Severity())
+}
@@ -0,0 +1,36 @@
+package zlogprom
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coghost/zlog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// WithMetricsPushgateway pushes the OpenSearch shipping counters of the logger
+// to the Prometheus Pushgateway at url, grouped under jobName, whenever its
+// CleanUp function runs. Batch jobs don't live long enough to be scraped, so
+// they report on shutdown instead. The counters are those of NewMetrics plus
+// zlog_opensearch_logs_dropped_total.
+func WithMetricsPushgateway(url, jobName string) zlog.LogOptFunc {
+	return zlog.WithCleanUpHook(func(ctx context.Context, stats zlog.IndexerStats) error {
+		reg := prometheus.NewRegistry()
+
+		for name, value := range map[string]uint64{
+			"added":   stats.Added,
+			"flushed": stats.Flushed,
+			"failed":  stats.Failed,
+			"dropped": stats.Dropped,
+		} {
+			registerCounter(reg, name, counterHelp[name]).Add(float64(value))
+		}
+
+		if err := push.New(url, jobName).Gatherer(reg).PushContext(ctx); err != nil {
+			return fmt.Errorf("failed to push metrics: %w", err)
+		}
+
+		return nil
+	})
+}
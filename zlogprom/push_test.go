@@ -0,0 +1,117 @@
+package zlogprom
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/coghost/zlog"
+	"github.com/opensearch-project/opensearch-go"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePushgateway records the counters of the pushes it receives.
+type fakePushgateway struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	paths  []string
+	values map[string]float64
+}
+
+func newFakePushgateway(t *testing.T) *fakePushgateway {
+	t.Helper()
+
+	gateway := &fakePushgateway{}
+	gateway.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		values := map[string]float64{}
+		// the decoder buffers every read unless the body is buffered already
+		decoder := expfmt.NewDecoder(bufio.NewReader(r.Body), expfmt.ResponseFormat(r.Header))
+
+		for {
+			var family dto.MetricFamily
+
+			err := decoder.Decode(&family)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			values[family.GetName()] = family.GetMetric()[0].GetCounter().GetValue()
+		}
+
+		gateway.mu.Lock()
+		defer gateway.mu.Unlock()
+
+		gateway.paths = append(gateway.paths, r.Method+" "+r.URL.Path)
+		gateway.values = values
+	}))
+	t.Cleanup(gateway.Close)
+
+	return gateway
+}
+
+func TestWithMetricsPushgateway(t *testing.T) {
+	server := newFakeOpenSearch(t)
+	gateway := newFakePushgateway(t)
+	config := opensearch.Config{Addresses: []string{server.URL}}
+
+	logger, flushFunc, err := zlog.NewZapLoggerWithOpenSearch(
+		zlog.WithOpenSearchConfig(&config),
+		zlog.WithOpenSearchIndex("zlog-test", ""),
+		WithMetricsPushgateway(gateway.URL, "nightly-import"),
+	)
+	require.NoError(t, err)
+
+	logger.Info("accepted")
+	logger.Info("accepted")
+	logger.Info("rejected")
+
+	gateway.mu.Lock()
+	assert.Empty(t, gateway.paths, "nothing is pushed before the clean up")
+	gateway.mu.Unlock()
+
+	require.NoError(t, flushFunc(context.Background()))
+
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+
+	assert.Equal(t, []string{"PUT /metrics/job/nightly-import"}, gateway.paths)
+	assert.Equal(t, map[string]float64{
+		"zlog_opensearch_logs_added_total":   3,
+		"zlog_opensearch_logs_flushed_total": 2,
+		"zlog_opensearch_logs_failed_total":  1,
+		"zlog_opensearch_logs_dropped_total": 0,
+	}, gateway.values)
+}
+
+func TestWithMetricsPushgatewayError(t *testing.T) {
+	server := newFakeOpenSearch(t)
+	config := opensearch.Config{Addresses: []string{server.URL}}
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(gateway.Close)
+
+	_, flushFunc, err := zlog.NewZapLoggerWithOpenSearch(
+		zlog.WithOpenSearchConfig(&config),
+		zlog.WithOpenSearchIndex("zlog-test", ""),
+		WithMetricsPushgateway(gateway.URL, "nightly-import"),
+	)
+	require.NoError(t, err)
+
+	assert.ErrorContains(t, flushFunc(context.Background()), "failed to push metrics")
+}
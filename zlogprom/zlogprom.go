@@ -0,0 +1,71 @@
+// Package zlogprom exports the OpenSearch shipping counters of zlog loggers as
+// Prometheus metrics. It lives in its own package so zlog itself doesn't depend
+// on the Prometheus client.
+package zlogprom
+
+import (
+	"errors"
+
+	"github.com/coghost/zlog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics implements zlog.Metrics with Prometheus counters.
+type Metrics struct {
+	added   prometheus.Counter
+	flushed prometheus.Counter
+	failed  prometheus.Counter
+}
+
+// NewMetrics registers the counters zlog_opensearch_logs_added_total,
+// zlog_opensearch_logs_flushed_total and zlog_opensearch_logs_failed_total with
+// reg. Counters already registered, e.g. by another logger, are shared. It panics
+// if registration fails otherwise, like prometheus.MustRegister.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	return &Metrics{
+		added:   registerCounter(reg, "added", counterHelp["added"]),
+		flushed: registerCounter(reg, "flushed", counterHelp["flushed"]),
+		failed:  registerCounter(reg, "failed", counterHelp["failed"]),
+	}
+}
+
+var counterHelp = map[string]string{
+	"added":   "Log entries handed to the OpenSearch bulk indexer, retries included.",
+	"flushed": "Log entries accepted by OpenSearch.",
+	"failed":  "Log entries rejected by OpenSearch or the bulk indexer.",
+	"dropped": "Log entries that never reached the OpenSearch bulk indexer.",
+}
+
+// WithMetricsRegistry counts the log entries shipped to OpenSearch in counters
+// registered with reg, see NewMetrics.
+func WithMetricsRegistry(reg prometheus.Registerer) zlog.LogOptFunc {
+	return zlog.WithMetrics(NewMetrics(reg))
+}
+
+func (m *Metrics) IncAdded() { m.added.Inc() }
+
+func (m *Metrics) IncFlushed() { m.flushed.Inc() }
+
+func (m *Metrics) IncFailed() { m.failed.Inc() }
+
+func registerCounter(reg prometheus.Registerer, name, help string) prometheus.Counter {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "zlog",
+		Subsystem: "opensearch",
+		Name:      "logs_" + name + "_total",
+		Help:      help,
+	})
+
+	if err := reg.Register(counter); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			if existing, ok := registered.ExistingCollector.(prometheus.Counter); ok {
+				return existing
+			}
+		}
+
+		panic(err)
+	}
+
+	return counter
+}
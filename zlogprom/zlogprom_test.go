@@ -0,0 +1,137 @@
+package zlogprom
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coghost/zlog"
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeOpenSearch starts a server accepting every bulk item except those
+// whose message is "rejected".
+func newFakeOpenSearch(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+			_, _ = w.Write([]byte(`{"version":{"number":"2.11.0","distribution":"opensearch"}}`))
+			return
+		}
+
+		var items []map[string]interface{}
+
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]interface{}
+			_ = json.Unmarshal(scanner.Bytes(), &action)
+
+			var source map[string]interface{}
+			if scanner.Scan() {
+				_ = json.Unmarshal(scanner.Bytes(), &source)
+			}
+
+			result := map[string]interface{}{"status": http.StatusCreated}
+			if source["msg"] == "rejected" {
+				result = map[string]interface{}{"status": http.StatusBadRequest, "error": map[string]interface{}{"reason": "rejected"}}
+			}
+
+			for name := range action {
+				items = append(items, map[string]interface{}{name: result})
+			}
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": true, "items": items})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestWithMetricsRegistry(t *testing.T) {
+	server := newFakeOpenSearch(t)
+	config := opensearch.Config{Addresses: []string{server.URL}}
+	reg := prometheus.NewRegistry()
+
+	logger, flushFunc, err := zlog.NewZapLoggerWithOpenSearch(
+		zlog.WithOpenSearchConfig(&config),
+		zlog.WithOpenSearchIndex("zlog-test", ""),
+		WithMetricsRegistry(reg),
+	)
+	require.NoError(t, err)
+
+	for range 3 {
+		logger.Info("accepted")
+	}
+
+	logger.Info("rejected")
+	require.NoError(t, flushFunc(context.Background()))
+
+	expected := `
+# HELP zlog_opensearch_logs_added_total Log entries handed to the OpenSearch bulk indexer, retries included.
+# TYPE zlog_opensearch_logs_added_total counter
+zlog_opensearch_logs_added_total 4
+# HELP zlog_opensearch_logs_failed_total Log entries rejected by OpenSearch or the bulk indexer.
+# TYPE zlog_opensearch_logs_failed_total counter
+zlog_opensearch_logs_failed_total 1
+# HELP zlog_opensearch_logs_flushed_total Log entries accepted by OpenSearch.
+# TYPE zlog_opensearch_logs_flushed_total counter
+zlog_opensearch_logs_flushed_total 3
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected)))
+}
+
+func TestWithMetricsRegistryFailedRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/_bulk") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"version":{"number":"2.11.0","distribution":"opensearch"}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	config := opensearch.Config{Addresses: []string{server.URL}}
+	metrics := NewMetrics(prometheus.NewRegistry())
+
+	logger, flushFunc, err := zlog.NewZapLoggerWithOpenSearch(
+		zlog.WithOpenSearchConfig(&config),
+		zlog.WithOpenSearchIndex("zlog-test", ""),
+		zlog.WithMetrics(metrics),
+	)
+	require.NoError(t, err)
+
+	for range 3 {
+		logger.Info("lost with the request")
+	}
+
+	require.NoError(t, flushFunc(context.Background()))
+
+	assert.InDelta(t, 3, testutil.ToFloat64(metrics.failed), 0)
+	assert.InDelta(t, 0, testutil.ToFloat64(metrics.flushed), 0)
+}
+
+func TestNewMetricsSharesCounters(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	first := NewMetrics(reg)
+	second := NewMetrics(reg)
+
+	first.IncAdded()
+	second.IncAdded()
+
+	assert.InDelta(t, 2, testutil.ToFloat64(first.added), 0)
+}